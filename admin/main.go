@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"tenderhelp/internal/auth"
 	"tenderhelp/internal/database"
 	"tenderhelp/internal/handlers"
 	"tenderhelp/internal/models"
@@ -14,6 +15,7 @@ import (
 func main() {
 	// Инициализация базы данных
 	db := database.InitDB()
+	handlers.InitDB()
 
 	// Автомиграция моделей
 	db.AutoMigrate(
@@ -23,25 +25,58 @@ func main() {
 		&models.News{},
 		&models.HelpCategory{},
 		&models.HelpArticle{},
+		// Сессии, роли и защита входа общие с основным сервером
+		&handlers.Session{},
+		&handlers.Role{},
+		&handlers.Permission{},
+		&handlers.UserSecurity{},
+		&handlers.RecoveryCode{},
+		&handlers.LoginThrottle{},
+		&handlers.SecurityEvent{},
+		&handlers.ClientAccess{},
+		&handlers.AuditEntry{},
 	)
 
+	// Системные роли и права
+	if err := handlers.EnsureDefaultRoles(); err != nil {
+		log.Fatalf("Ошибка инициализации ролей: %v", err)
+	}
+
+	// Токены второго шага входа (2FA) подписываются тем же секретом
+	handlers.SetTokenManager(auth.NewTokenManager(auth.LoadSecret(), auth.DefaultAccessTokenTTL))
+
 	// Настройка Gin
 	r := gin.Default()
+	// Адрес клиента из X-Forwarded-For принимается только от доверенных прокси
+	if err := r.SetTrustedProxies(handlers.TrustedProxies()); err != nil {
+		log.Fatalf("Некорректный TRUSTED_PROXIES: %v", err)
+	}
+	r.Use(handlers.RequestID())
 
 	// CORS настройки для админки
 	config := cors.DefaultConfig()
 	config.AllowOrigins = []string{"http://localhost:3001", "http://localhost:3000"}
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-CSRF-Token", "X-Request-ID"}
 	r.Use(cors.New(config))
 
 	// Статические файлы для админки
 	r.Static("/static", "./static")
 	r.LoadHTMLGlob("templates/*")
 
-	// Админские API маршруты
+	// Вход и выход
+	r.POST("/api/admin/login", handlers.AdminLogin)
+	r.POST("/api/admin/login/2fa", handlers.AdminLoginMFA)
+
+	// Админские API маршруты: cookie-сессия администратора, для изменяющих
+	// запросов - заголовок X-CSRF-Token
 	admin := r.Group("/api/admin")
+	admin.Use(handlers.RequireAdminSession(), handlers.RequireRole("admin"))
 	{
+		// Сессия
+		admin.GET("/session", handlers.GetAdminSession)
+		admin.POST("/logout", handlers.AdminLogout)
+
 		// Банки
 		admin.GET("/banks", handlers.GetBanks)
 		admin.POST("/banks", handlers.CreateBank)
@@ -57,7 +92,6 @@ func main() {
 
 		// Аналитика
 		admin.GET("/analytics", handlers.GetAdminAnalytics)
-		admin.GET("/stats", handlers.GetAdminStats)
 
 		// Заявки
 		admin.GET("/requests", handlers.GetAdminRequests)
@@ -74,9 +108,7 @@ func main() {
 	}
 
 	// Главная страница админки
-	r.GET("/", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "admin.html", gin.H{})
-	})
+	r.GET("/", handlers.AdminPage("admin.html", "/login"))
 
 	// Страница входа в админку
 	r.GET("/login", func(c *gin.Context) {
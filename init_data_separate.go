@@ -2,7 +2,9 @@ package main
 
 import (
 	"log"
+	"tenderhelp/internal/auth"
 	"tenderhelp/internal/database"
+	"tenderhelp/internal/handlers"
 	"tenderhelp/internal/models"
 	"time"
 )
@@ -10,14 +12,23 @@ import (
 func main() {
 	db := database.InitDB()
 
-	// Создаем тестовых пользователей
+	// Создаем тестовых пользователей. Пароль хранится только в виде хеша,
+	// при первом входе его потребуется сменить.
+	passwordHash, err := auth.HashPassword("password")
+	if err != nil {
+		log.Fatalf("Ошибка хеширования пароля: %v", err)
+	}
+
 	users := []models.User{
-		{Email: "agent@tenderhelp.ru", Password: "password", Name: "Максим Искин Олегович", Role: "agent"},
-		{Email: "manager@tenderhelp.ru", Password: "password", Name: "Сутулина Елизавета", Role: "manager"},
+		{Email: "agent@tenderhelp.ru", Password: passwordHash, Name: "Максим Искин Олегович", Role: "agent", IsActive: true},
+		{Email: "manager@tenderhelp.ru", Password: passwordHash, Name: "Сутулина Елизавета", Role: "manager", IsActive: true},
 	}
 
 	for _, user := range users {
-		db.Create(&user)
+		if err := db.Create(&user).Error; err != nil {
+			continue
+		}
+		db.Create(&handlers.UserSecurity{UserID: user.ID, MustChangePassword: true})
 	}
 
 	// Создаем тестовых клиентов
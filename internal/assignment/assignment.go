@@ -0,0 +1,75 @@
+// Package assignment выбирает ответственного менеджера для новой заявки.
+package assignment
+
+import (
+	"fmt"
+	"time"
+)
+
+// Strategy стратегия распределения заявок
+type Strategy string
+
+const (
+	// RoundRobin по очереди: заявку получает менеджер, дольше всех не
+	// получавший заявок
+	RoundRobin Strategy = "round_robin"
+	// LeastLoaded наименее загруженный: заявку получает менеджер с наименьшим
+	// числом заявок в работе
+	LeastLoaded Strategy = "least_loaded"
+)
+
+// ParseStrategy разбирает название стратегии; пустое значение - LeastLoaded
+func ParseStrategy(value string) (Strategy, error) {
+	switch Strategy(value) {
+	case "":
+		return LeastLoaded, nil
+	case RoundRobin, LeastLoaded:
+		return Strategy(value), nil
+	}
+	return "", fmt.Errorf("неизвестная стратегия распределения: %s", value)
+}
+
+// Candidate менеджер, которому может быть назначена заявка. Capacity -
+// максимальное число заявок в работе (0 - без ограничения), LastAssignedAt -
+// время последнего назначения (нулевое, если назначений не было).
+type Candidate struct {
+	ID             uint
+	Load           int
+	Capacity       int
+	LastAssignedAt time.Time
+}
+
+// Available менеджер может принять еще одну заявку
+func (c Candidate) Available() bool {
+	return c.Capacity <= 0 || c.Load < c.Capacity
+}
+
+// Pick выбирает менеджера по стратегии среди тех, у кого не исчерпан лимит.
+// При равенстве выбирается менеджер, дольше не получавший заявок, затем с
+// меньшим ID. Возвращает false, если свободных менеджеров нет.
+func Pick(strategy Strategy, candidates []Candidate) (uint, bool) {
+	var best *Candidate
+	for i := range candidates {
+		candidate := &candidates[i]
+		if !candidate.Available() {
+			continue
+		}
+		if best == nil || better(strategy, candidate, best) {
+			best = candidate
+		}
+	}
+	if best == nil {
+		return 0, false
+	}
+	return best.ID, true
+}
+
+func better(strategy Strategy, a, b *Candidate) bool {
+	if strategy == LeastLoaded && a.Load != b.Load {
+		return a.Load < b.Load
+	}
+	if !a.LastAssignedAt.Equal(b.LastAssignedAt) {
+		return a.LastAssignedAt.Before(b.LastAssignedAt)
+	}
+	return a.ID < b.ID
+}
@@ -0,0 +1,59 @@
+package assignment
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPick_RoundRobin(t *testing.T) {
+	now := time.Now()
+	candidates := []Candidate{
+		{ID: 1, Load: 0, LastAssignedAt: now},
+		{ID: 2, Load: 5, LastAssignedAt: now.Add(-time.Hour)},
+		{ID: 3, Load: 1},
+	}
+	if id, ok := Pick(RoundRobin, candidates); !ok || id != 3 {
+		t.Errorf("Ожидался менеджер без назначений (3), получен %d", id)
+	}
+
+	candidates[2].LastAssignedAt = now.Add(time.Minute)
+	if id, _ := Pick(RoundRobin, candidates); id != 2 {
+		t.Errorf("Ожидался менеджер, дольше всех не получавший заявок (2), получен %d", id)
+	}
+}
+
+func TestPick_LeastLoaded(t *testing.T) {
+	now := time.Now()
+	candidates := []Candidate{
+		{ID: 1, Load: 3},
+		{ID: 2, Load: 1, LastAssignedAt: now},
+		{ID: 3, Load: 1, LastAssignedAt: now.Add(-time.Hour)},
+	}
+	if id, ok := Pick(LeastLoaded, candidates); !ok || id != 3 {
+		t.Errorf("Ожидался наименее загруженный менеджер (3), получен %d", id)
+	}
+}
+
+func TestPick_Capacity(t *testing.T) {
+	candidates := []Candidate{
+		{ID: 1, Load: 2, Capacity: 2},
+		{ID: 2, Load: 10, Capacity: 0},
+	}
+	if id, ok := Pick(LeastLoaded, candidates); !ok || id != 2 {
+		t.Errorf("Менеджер с исчерпанным лимитом не должен выбираться, получен %d", id)
+	}
+
+	candidates[1].Capacity = 10
+	if _, ok := Pick(RoundRobin, candidates); ok {
+		t.Error("Ожидалось отсутствие свободных менеджеров")
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	if s, err := ParseStrategy(""); err != nil || s != LeastLoaded {
+		t.Errorf("Стратегия по умолчанию: %v, %v", s, err)
+	}
+	if _, err := ParseStrategy("random"); err == nil {
+		t.Error("Ожидалась ошибка для неизвестной стратегии")
+	}
+}
@@ -0,0 +1,139 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Record содержимое записи журнала аудита, которое покрывается хешем
+type Record struct {
+	Sequence   uint64
+	PrevHash   string
+	Time       time.Time
+	ActorID    uint
+	ActorRole  string
+	APIKeyID   uint
+	Action     string
+	EntityType string
+	EntityID   string
+	Changes    string
+	IP         string
+	RequestID  string
+}
+
+// canonicalRecord фиксированное представление записи для хеширования.
+// Порядок и имена полей менять нельзя: от них зависят хеши уже
+// сохраненных записей.
+type canonicalRecord struct {
+	Sequence   uint64 `json:"seq"`
+	PrevHash   string `json:"prev"`
+	Time       string `json:"time"`
+	ActorID    uint   `json:"actor"`
+	ActorRole  string `json:"role"`
+	APIKeyID   uint   `json:"key"`
+	Action     string `json:"action"`
+	EntityType string `json:"entity"`
+	EntityID   string `json:"entity_id"`
+	Changes    string `json:"changes"`
+	IP         string `json:"ip"`
+	RequestID  string `json:"request_id"`
+}
+
+// Chain вычисляет и проверяет хеши записей. С ключом используется
+// HMAC-SHA256: без ключа нельзя пересчитать цепочку после правки записей
+// напрямую в базе данных.
+type Chain struct {
+	key []byte
+}
+
+// NewChain создает цепочку с ключом подписи (может быть пустым)
+func NewChain(key []byte) *Chain {
+	return &Chain{key: key}
+}
+
+// ChainFromEnv создает цепочку с ключом из AUDIT_SIGNING_KEY
+func ChainFromEnv() *Chain {
+	return NewChain([]byte(os.Getenv("AUDIT_SIGNING_KEY")))
+}
+
+// NormalizeTime приводит время к точности и зоне, в которых оно хешируется.
+// Время записи нужно нормализовать до сохранения, чтобы хеш совпал после
+// чтения из базы данных.
+func NormalizeTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+// Hash вычисляет хеш записи
+func (c *Chain) Hash(r Record) string {
+	data, _ := json.Marshal(canonicalRecord{
+		Sequence:   r.Sequence,
+		PrevHash:   r.PrevHash,
+		Time:       NormalizeTime(r.Time).Format(time.RFC3339Nano),
+		ActorID:    r.ActorID,
+		ActorRole:  r.ActorRole,
+		APIKeyID:   r.APIKeyID,
+		Action:     r.Action,
+		EntityType: r.EntityType,
+		EntityID:   r.EntityID,
+		Changes:    r.Changes,
+		IP:         r.IP,
+		RequestID:  r.RequestID,
+	})
+
+	if len(c.key) > 0 {
+		mac := hmac.New(sha256.New, c.key)
+		mac.Write(data)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verifier последовательно проверяет записи цепочки. Записи передаются
+// в порядке возрастания Sequence, начиная с первой.
+type Verifier struct {
+	chain    *Chain
+	prevSeq  uint64
+	prevHash string
+	checked  int
+}
+
+// NewVerifier создает проверку цепочки с начала журнала
+func (c *Chain) NewVerifier() *Verifier {
+	return &Verifier{chain: c}
+}
+
+// Next проверяет очередную запись и ее сохраненный хеш. Ошибка указывает
+// на первое место, где цепочка нарушена: пропуск записи, подмену ссылки
+// на предыдущую запись или изменение содержимого.
+func (v *Verifier) Next(r Record, storedHash string) error {
+	if r.Sequence != v.prevSeq+1 {
+		return fmt.Errorf("запись %d: ожидался номер %d (запись удалена или вставлена)", r.Sequence, v.prevSeq+1)
+	}
+	if r.PrevHash != v.prevHash {
+		return fmt.Errorf("запись %d: ссылка на предыдущую запись не совпадает", r.Sequence)
+	}
+	if !hmac.Equal([]byte(v.chain.Hash(r)), []byte(storedHash)) {
+		return fmt.Errorf("запись %d: содержимое изменено", r.Sequence)
+	}
+
+	v.prevSeq = r.Sequence
+	v.prevHash = storedHash
+	v.checked++
+	return nil
+}
+
+// Checked возвращает число проверенных записей
+func (v *Verifier) Checked() int {
+	return v.checked
+}
+
+// LastHash возвращает хеш последней проверенной записи
+func (v *Verifier) LastHash() string {
+	return v.prevHash
+}
@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"testing"
+	"time"
+)
+
+func buildChain(chain *Chain, n int) ([]Record, []string) {
+	records := make([]Record, 0, n)
+	hashes := make([]string, 0, n)
+	prev := ""
+	for i := 1; i <= n; i++ {
+		r := Record{
+			Sequence:   uint64(i),
+			PrevHash:   prev,
+			Time:       NormalizeTime(time.Now()),
+			ActorID:    7,
+			ActorRole:  "agent",
+			Action:     "application.update",
+			EntityType: "application",
+			EntityID:   "42",
+			Changes:    `[{"path":"amount","old":1,"new":2}]`,
+			IP:         "10.0.0.1",
+			RequestID:  "req-1",
+		}
+		prev = chain.Hash(r)
+		records = append(records, r)
+		hashes = append(hashes, prev)
+	}
+	return records, hashes
+}
+
+func verifyAll(chain *Chain, records []Record, hashes []string) error {
+	v := chain.NewVerifier()
+	for i, r := range records {
+		if err := v.Next(r, hashes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestChain_Verify(t *testing.T) {
+	chain := NewChain([]byte("secret"))
+	records, hashes := buildChain(chain, 5)
+
+	if err := verifyAll(chain, records, hashes); err != nil {
+		t.Fatalf("Корректная цепочка не прошла проверку: %v", err)
+	}
+
+	// Изменение содержимого
+	tampered := append([]Record(nil), records...)
+	tampered[2].Changes = `[]`
+	if err := verifyAll(chain, tampered, hashes); err == nil {
+		t.Error("Измененная запись должна обнаруживаться")
+	}
+
+	// Удаление записи
+	removed := append(append([]Record(nil), records[:2]...), records[3:]...)
+	removedHashes := append(append([]string(nil), hashes[:2]...), hashes[3:]...)
+	if err := verifyAll(chain, removed, removedHashes); err == nil {
+		t.Error("Удаленная запись должна обнаруживаться")
+	}
+
+	// Пересчет цепочки без ключа подписи
+	forged := NewChain(nil)
+	_, forgedHashes := buildChain(forged, 5)
+	if err := verifyAll(chain, records, forgedHashes); err == nil {
+		t.Error("Цепочка, пересчитанная без ключа, не должна проходить проверку")
+	}
+}
+
+func TestNormalizeTime(t *testing.T) {
+	chain := NewChain(nil)
+	moment := time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.FixedZone("MSK", 3*3600))
+
+	r := Record{Sequence: 1, Time: moment}
+	stored := r
+	stored.Time = NormalizeTime(moment).In(time.Local)
+
+	if chain.Hash(r) != chain.Hash(stored) {
+		t.Error("Хеш не должен зависеть от часового пояса и наносекунд")
+	}
+}
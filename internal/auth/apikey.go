@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// APIKeyPrefix префикс, по которому ключи API отличаются от access-токенов
+const APIKeyPrefix = "brk_"
+
+// apiKeyIDAlphabet символы открытой части ключа
+const apiKeyIDAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// apiKeyIDLength длина открытой части ключа, по которой ищется запись
+const apiKeyIDLength = 8
+
+// NewAPIKey генерирует ключ вида brk_<id>_<secret>. Возвращает ключ целиком
+// (показывается один раз) и его открытую часть для поиска в базе данных.
+func NewAPIKey() (key, id string, err error) {
+	b := make([]byte, apiKeyIDLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("ошибка генерации ключа: %w", err)
+	}
+	for i := range b {
+		b[i] = apiKeyIDAlphabet[int(b[i])%len(apiKeyIDAlphabet)]
+	}
+	id = string(b)
+
+	secret, err := RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	return APIKeyPrefix + id + "_" + secret, id, nil
+}
+
+// IsAPIKey проверяет, что строка похожа на ключ API
+func IsAPIKey(value string) bool {
+	return strings.HasPrefix(value, APIKeyPrefix)
+}
+
+// ParseAPIKey возвращает открытую часть ключа
+func ParseAPIKey(key string) (string, bool) {
+	if !IsAPIKey(key) {
+		return "", false
+	}
+
+	id, secret, found := strings.Cut(strings.TrimPrefix(key, APIKeyPrefix), "_")
+	if !found || len(id) != apiKeyIDLength || secret == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// IPAllowed проверяет адрес по списку разрешенных адресов и подсетей (CIDR).
+// Пустой список разрешает любой адрес.
+func IPAllowed(ip string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	for _, entry := range allowed {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(addr) {
+				return true
+			}
+			continue
+		}
+		if allowedIP := net.ParseIP(entry); allowedIP != nil && allowedIP.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidIPEntry проверяет, что элемент списка разрешенных адресов корректен
+func ValidIPEntry(entry string) bool {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		_, _, err := net.ParseCIDR(entry)
+		return err == nil
+	}
+	return net.ParseIP(entry) != nil
+}
@@ -0,0 +1,54 @@
+package auth
+
+import "testing"
+
+func TestNewAPIKey(t *testing.T) {
+	key, id, err := NewAPIKey()
+	if err != nil {
+		t.Fatalf("Ошибка генерации ключа: %v", err)
+	}
+
+	if !IsAPIKey(key) {
+		t.Errorf("Ключ должен начинаться с %s: %s", APIKeyPrefix, key)
+	}
+
+	parsed, ok := ParseAPIKey(key)
+	if !ok {
+		t.Fatal("Сгенерированный ключ не разобран")
+	}
+	if parsed != id {
+		t.Errorf("Ожидалась открытая часть %s, получена %s", id, parsed)
+	}
+
+	for _, invalid := range []string{"", "brk_", "brk_short_secret", "token", "brk_abcdefgh_"} {
+		if _, ok := ParseAPIKey(invalid); ok {
+			t.Errorf("Некорректный ключ '%s' не должен разбираться", invalid)
+		}
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	allowed := []string{"192.168.1.10", "10.0.0.0/8", "2001:db8::/32"}
+
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{"192.168.1.10", true},
+		{"192.168.1.11", false},
+		{"10.20.30.40", true},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		{"not-an-ip", false},
+	}
+
+	for _, tt := range tests {
+		if got := IPAllowed(tt.ip, allowed); got != tt.expected {
+			t.Errorf("IPAllowed(%s) = %v, ожидалось %v", tt.ip, got, tt.expected)
+		}
+	}
+
+	if !IPAllowed("203.0.113.5", nil) {
+		t.Error("Пустой список должен разрешать любой адрес")
+	}
+}
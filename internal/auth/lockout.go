@@ -0,0 +1,52 @@
+package auth
+
+import "time"
+
+// LockoutPolicy правила временной блокировки входа после неудачных попыток
+type LockoutPolicy struct {
+	// Threshold число неудачных попыток, после которого включается блокировка
+	Threshold int
+	// BaseDelay длительность первой блокировки; каждая следующая неудачная
+	// попытка удваивает ее
+	BaseDelay time.Duration
+	// MaxDelay верхняя граница длительности блокировки
+	MaxDelay time.Duration
+	// ResetAfter период без неудачных попыток, после которого счетчик обнуляется
+	ResetAfter time.Duration
+}
+
+// AccountLockoutPolicy блокировка учетной записи (подбор пароля к одному аккаунту)
+var AccountLockoutPolicy = LockoutPolicy{
+	Threshold:  5,
+	BaseDelay:  time.Minute,
+	MaxDelay:   time.Hour,
+	ResetAfter: 24 * time.Hour,
+}
+
+// IPLockoutPolicy блокировка адреса (перебор учетных данных по многим аккаунтам)
+var IPLockoutPolicy = LockoutPolicy{
+	Threshold:  20,
+	BaseDelay:  time.Minute,
+	MaxDelay:   time.Hour,
+	ResetAfter: 24 * time.Hour,
+}
+
+// LockDuration возвращает длительность блокировки после failures неудачных
+// попыток подряд (0, если порог еще не достигнут)
+func (p LockoutPolicy) LockDuration(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.Threshold; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutPolicy_LockDuration(t *testing.T) {
+	policy := LockoutPolicy{
+		Threshold: 3,
+		BaseDelay: time.Minute,
+		MaxDelay:  10 * time.Minute,
+	}
+
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := policy.LockDuration(tt.failures); got != tt.expected {
+			t.Errorf("Для %d попыток ожидалась блокировка %v, получена %v", tt.failures, tt.expected, got)
+		}
+	}
+}
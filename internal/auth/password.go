@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordPolicy требования к паролям пользователей
+type PasswordPolicy struct {
+	MinLength      int
+	MaxLength      int
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSpecial bool
+}
+
+// DefaultPasswordPolicy политика паролей по умолчанию
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:    10,
+	MaxLength:    72, // ограничение bcrypt
+	RequireUpper: true,
+	RequireLower: true,
+	RequireDigit: true,
+}
+
+// commonPasswords пароли, которые отклоняются независимо от сложности
+var commonPasswords = map[string]bool{
+	"password1!":  true,
+	"qwerty1234":  true,
+	"qwertyuiop":  true,
+	"1234567890":  true,
+	"p@ssw0rd123": true,
+	"password123": true,
+	"admin12345":  true,
+	"brokerum123": true,
+	"tenderhelp1": true,
+}
+
+// ValidatePassword проверяет пароль по политике по умолчанию
+func ValidatePassword(password, email string) error {
+	return DefaultPasswordPolicy.Validate(password, email)
+}
+
+// Validate проверяет пароль. Email пользователя нужен, чтобы не допустить
+// пароль, содержащий его имя почтового ящика.
+func (p PasswordPolicy) Validate(password, email string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return errors.New("пароль слишком короткий")
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		return errors.New("пароль слишком длинный")
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSpecial bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsSpace(r):
+			return errors.New("пароль не должен содержать пробелы")
+		default:
+			hasSpecial = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		return errors.New("пароль должен содержать заглавную букву")
+	}
+	if p.RequireLower && !hasLower {
+		return errors.New("пароль должен содержать строчную букву")
+	}
+	if p.RequireDigit && !hasDigit {
+		return errors.New("пароль должен содержать цифру")
+	}
+	if p.RequireSpecial && !hasSpecial {
+		return errors.New("пароль должен содержать специальный символ")
+	}
+
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return errors.New("пароль слишком распространен")
+	}
+	if local, _, found := strings.Cut(strings.ToLower(email), "@"); found && len(local) >= 3 && strings.Contains(lower, local) {
+		return errors.New("пароль не должен содержать email")
+	}
+
+	return nil
+}
+
+// HashPassword возвращает bcrypt-хеш пароля
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// UnusablePasswordHash возвращает хеш случайного пароля, который никому
+// не известен. Используется для учетных записей, ожидающих приглашения.
+func UnusablePasswordHash() (string, error) {
+	secret, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	return HashPassword(secret)
+}
+
+// IsPasswordHash проверяет, что значение является bcrypt-хешем, а не
+// паролем в открытом виде
+func IsPasswordHash(value string) bool {
+	_, err := bcrypt.Cost([]byte(value))
+	return err == nil
+}
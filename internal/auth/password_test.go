@@ -0,0 +1,49 @@
+package auth
+
+import "testing"
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		email    string
+		valid    bool
+	}{
+		{"корректный пароль", "Tender2024Help", "agent@example.com", true},
+		{"короткий пароль", "Ab1cdef", "agent@example.com", false},
+		{"без заглавных букв", "tender2024help", "agent@example.com", false},
+		{"без строчных букв", "TENDER2024HELP", "agent@example.com", false},
+		{"без цифр", "TenderHelpPass", "agent@example.com", false},
+		{"с пробелом", "Tender 2024 Help", "agent@example.com", false},
+		{"распространенный пароль", "Password123", "agent@example.com", false},
+		{"содержит email", "Ivanov2024Pass", "ivanov@example.com", false},
+		{"кириллица", "Тендер2024Помощь", "agent@example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePassword(tt.password, tt.email)
+			if tt.valid && err != nil {
+				t.Errorf("Ожидался корректный пароль, получена ошибка: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("Ожидалась ошибка проверки пароля")
+			}
+		})
+	}
+}
+
+func TestIsPasswordHash(t *testing.T) {
+	hash, err := HashPassword("Tender2024Help")
+	if err != nil {
+		t.Fatalf("Ошибка хеширования пароля: %v", err)
+	}
+
+	if !IsPasswordHash(hash) {
+		t.Error("bcrypt-хеш не распознан")
+	}
+
+	if IsPasswordHash("Tender2024Help") {
+		t.Error("Пароль в открытом виде распознан как хеш")
+	}
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// DefaultRefreshTokenTTL время жизни refresh-токена (и сессии) по умолчанию
+const DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+// NewRefreshToken генерирует непрозрачный refresh-токен
+func NewRefreshToken() (string, error) {
+	return RandomToken(32)
+}
+
+// RandomToken генерирует криптографически стойкую случайную строку из n байт
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("ошибка генерации токена: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken возвращает SHA-256 хеш токена для хранения в базе данных
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultAccessTokenTTL время жизни access-токена по умолчанию
+const DefaultAccessTokenTTL = 15 * time.Minute
+
+// tokenIssuer значение поля iss во всех выпускаемых токенах
+const tokenIssuer = "tenderhelp"
+
+// PurposePasswordChange назначение токена, выдаваемого при входе с
+// обязательной сменой пароля. Такой токен не дает доступа к API, кроме
+// смены пароля, и не привязан к сессии.
+const PurposePasswordChange = "password_change"
+
+// PurposeMFA назначение токена между проверкой пароля и вводом кода 2FA
+const PurposeMFA = "mfa"
+
+// PurposeMFAEnroll назначение токена для обязательного подключения 2FA.
+// С ним доступны только эндпоинты подключения.
+const PurposeMFAEnroll = "mfa_enroll"
+
+// PurposeAdminMFA назначение токена между паролем и кодом 2FA при входе
+// в админку. Второй шаг завершается cookie-сессией админки.
+const PurposeAdminMFA = "admin_mfa"
+
+// ErrInvalidToken возвращается для поддельных, просроченных и некорректных токенов
+var ErrInvalidToken = errors.New("недействительный токен")
+
+// Claims содержимое access-токена
+type Claims struct {
+	UserID      uint     `json:"uid"`
+	SessionID   uint     `json:"sid"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"perms,omitempty"`
+	Purpose     string   `json:"pur,omitempty"` // пусто для обычного access-токена
+	jwt.RegisteredClaims
+}
+
+// HasPermission проверяет наличие права в токене
+func (c *Claims) HasPermission(permission string) bool {
+	for _, p := range c.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenManager выпускает и проверяет подписанные токены (HMAC-SHA256)
+type TokenManager struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewTokenManager создает менеджер токенов
+func NewTokenManager(secret []byte, ttl time.Duration) *TokenManager {
+	if ttl <= 0 {
+		ttl = DefaultAccessTokenTTL
+	}
+	return &TokenManager{
+		secret: secret,
+		ttl:    ttl,
+	}
+}
+
+// Generate выпускает access-токен. Служебные поля (iss, sub, iat, exp)
+// заполняются менеджером.
+func (tm *TokenManager) Generate(claims Claims) (string, time.Time, error) {
+	return tm.GenerateWithTTL(claims, tm.ttl)
+}
+
+// GenerateWithTTL выпускает токен с заданным временем жизни
+// (для короткоживущих токенов специального назначения)
+func (tm *TokenManager) GenerateWithTTL(claims Claims, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    tokenIssuer,
+		Subject:   fmt.Sprintf("%d", claims.UserID),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(tm.secret)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("ошибка подписи токена: %w", err)
+	}
+
+	return token, expiresAt, nil
+}
+
+// Parse проверяет подпись и срок действия токена и возвращает его содержимое
+func (tm *TokenManager) Parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return tm.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	if claims.UserID == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// LoadSecret возвращает секрет подписи из переменной окружения JWT_SECRET.
+// Если переменная не задана, генерируется случайный секрет, и все токены
+// становятся недействительными после перезапуска сервера.
+func LoadSecret() []byte {
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		if len(secret) < 32 {
+			log.Println("ВНИМАНИЕ: JWT_SECRET короче 32 символов")
+		}
+		return []byte(secret)
+	}
+
+	log.Println("ВНИМАНИЕ: JWT_SECRET не задан, используется временный секрет")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("Не удалось сгенерировать секрет для токенов")
+	}
+	return secret
+}
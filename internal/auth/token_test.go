@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestTokenManager_GenerateAndParse(t *testing.T) {
+	manager := NewTokenManager([]byte("test-secret-test-secret-test-secret"), time.Minute)
+
+	token, expiresAt, err := manager.Generate(Claims{
+		UserID:      42,
+		SessionID:   7,
+		Role:        "agent",
+		Permissions: []string{"view_applications"},
+	})
+	if err != nil {
+		t.Fatalf("Ошибка генерации токена: %v", err)
+	}
+
+	if expiresAt.Before(time.Now()) {
+		t.Error("Срок действия токена уже истек")
+	}
+
+	claims, err := manager.Parse(token)
+	if err != nil {
+		t.Fatalf("Ошибка проверки токена: %v", err)
+	}
+
+	if claims.UserID != 42 {
+		t.Errorf("Ожидался UserID 42, получен %d", claims.UserID)
+	}
+
+	if claims.SessionID != 7 {
+		t.Errorf("Ожидался SessionID 7, получен %d", claims.SessionID)
+	}
+
+	if claims.Role != "agent" {
+		t.Errorf("Ожидалась роль 'agent', получена '%s'", claims.Role)
+	}
+
+	if !claims.HasPermission("view_applications") {
+		t.Error("Право view_applications должно присутствовать в токене")
+	}
+
+	if claims.HasPermission("manage_clients") {
+		t.Error("Право manage_clients не должно присутствовать в токене")
+	}
+}
+
+func TestTokenManager_RejectsForeignSignature(t *testing.T) {
+	issuer := NewTokenManager([]byte("secret-one-secret-one-secret-one"), time.Minute)
+	verifier := NewTokenManager([]byte("secret-two-secret-two-secret-two"), time.Minute)
+
+	token, _, err := issuer.Generate(Claims{UserID: 1, Role: "admin"})
+	if err != nil {
+		t.Fatalf("Ошибка генерации токена: %v", err)
+	}
+
+	if _, err := verifier.Parse(token); err == nil {
+		t.Error("Токен с чужой подписью не должен проходить проверку")
+	}
+}
+
+func TestTokenManager_GenerateWithTTL(t *testing.T) {
+	manager := NewTokenManager([]byte("test-secret-test-secret-test-secret"), time.Hour)
+
+	token, expiresAt, err := manager.GenerateWithTTL(Claims{UserID: 5, Purpose: PurposePasswordChange}, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("Ошибка генерации токена: %v", err)
+	}
+
+	if expiresAt.After(time.Now().Add(6 * time.Minute)) {
+		t.Errorf("Ожидался короткий срок действия, получен %v", expiresAt)
+	}
+
+	claims, err := manager.Parse(token)
+	if err != nil {
+		t.Fatalf("Ошибка проверки токена: %v", err)
+	}
+
+	if claims.Purpose != PurposePasswordChange {
+		t.Errorf("Ожидалось назначение '%s', получено '%s'", PurposePasswordChange, claims.Purpose)
+	}
+}
+
+func TestTokenManager_RejectsExpiredToken(t *testing.T) {
+	secret := []byte("test-secret-test-secret-test-secret")
+	manager := NewTokenManager(secret, time.Minute)
+
+	claims := Claims{
+		UserID: 1,
+		Role:   "admin",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		t.Fatalf("Ошибка подписи токена: %v", err)
+	}
+
+	if _, err := manager.Parse(token); err == nil {
+		t.Error("Просроченный токен не должен проходить проверку")
+	}
+}
+
+func TestTokenManager_RejectsUnsignedToken(t *testing.T) {
+	manager := NewTokenManager([]byte("test-secret-test-secret-test-secret"), time.Minute)
+
+	claims := Claims{
+		UserID: 1,
+		Role:   "admin",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("Ошибка формирования токена: %v", err)
+	}
+
+	if _, err := manager.Parse(token); err == nil {
+		t.Error("Неподписанный токен не должен проходить проверку")
+	}
+}
+
+func TestRandomToken_Unique(t *testing.T) {
+	first, err := NewRefreshToken()
+	if err != nil {
+		t.Fatalf("Ошибка генерации токена: %v", err)
+	}
+	second, err := NewRefreshToken()
+	if err != nil {
+		t.Fatalf("Ошибка генерации токена: %v", err)
+	}
+
+	if first == second {
+		t.Error("Refresh-токены не должны совпадать")
+	}
+
+	if HashToken(first) == HashToken(second) {
+		t.Error("Хеши разных токенов не должны совпадать")
+	}
+
+	if HashToken(first) != HashToken(first) {
+		t.Error("Хеш токена должен быть детерминированным")
+	}
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"image/png"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// totpIssuer название сервиса в приложении-аутентификаторе
+const totpIssuer = "Brokerum"
+
+// totpPeriod длительность шага TOTP в секундах
+const totpPeriod = 30
+
+// recoveryCodeAlphabet символы кодов восстановления (без похожих 0/O, 1/I)
+const recoveryCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// TOTPKey секрет TOTP и URI для приложения-аутентификатора
+type TOTPKey struct {
+	Secret string
+	URL    string
+}
+
+// NewTOTPKey генерирует новый секрет TOTP для учетной записи
+func NewTOTPKey(accountName string) (*TOTPKey, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: accountName,
+		Period:      totpPeriod,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка генерации секрета TOTP: %w", err)
+	}
+
+	return &TOTPKey{
+		Secret: key.Secret(),
+		URL:    key.URL(),
+	}, nil
+}
+
+// TOTPQRCode возвращает QR-код URI в виде data URL (PNG в base64)
+func TOTPQRCode(url string, size int) (string, error) {
+	key, err := otp.NewKeyFromURL(url)
+	if err != nil {
+		return "", err
+	}
+
+	img, err := key.Image(size, size)
+	if err != nil {
+		return "", fmt.Errorf("ошибка генерации QR-кода: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", fmt.Errorf("ошибка генерации QR-кода: %w", err)
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// ValidateTOTP проверяет код с допуском в один шаг в обе стороны и
+// возвращает номер совпавшего шага. Коды шагов не новее lastStep
+// отклоняются, чтобы один и тот же код нельзя было использовать повторно.
+func ValidateTOTP(secret, code string, lastStep int64, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != 6 {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for _, step := range []int64{current - 1, current, current + 1} {
+		if step <= lastStep {
+			continue
+		}
+
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// NewRecoveryCodes генерирует одноразовые коды восстановления формата XXXXX-XXXXX
+func NewRecoveryCodes(count int) ([]string, error) {
+	codes := make([]string, count)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("ошибка генерации кодов восстановления: %w", err)
+		}
+
+		var sb strings.Builder
+		for j, v := range b {
+			if j == 5 {
+				sb.WriteByte('-')
+			}
+			sb.WriteByte(recoveryCodeAlphabet[int(v)%len(recoveryCodeAlphabet)])
+		}
+		codes[i] = sb.String()
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode приводит введенный код восстановления к формату хранения
+func NormalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func TestValidateTOTP(t *testing.T) {
+	key, err := NewTOTPKey("agent@example.com")
+	if err != nil {
+		t.Fatalf("Ошибка генерации секрета: %v", err)
+	}
+
+	if !strings.HasPrefix(key.URL, "otpauth://totp/") {
+		t.Errorf("Некорректный URI: %s", key.URL)
+	}
+
+	now := time.Now()
+	code, err := totp.GenerateCode(key.Secret, now)
+	if err != nil {
+		t.Fatalf("Ошибка генерации кода: %v", err)
+	}
+
+	step, ok := ValidateTOTP(key.Secret, code, 0, now)
+	if !ok {
+		t.Fatal("Корректный код не прошел проверку")
+	}
+
+	if _, ok := ValidateTOTP(key.Secret, code, step, now); ok {
+		t.Error("Повторное использование кода должно отклоняться")
+	}
+
+	if _, ok := ValidateTOTP(key.Secret, code, 0, now.Add(5*time.Minute)); ok {
+		t.Error("Устаревший код не должен проходить проверку")
+	}
+
+	if _, ok := ValidateTOTP(key.Secret, "12345", 0, now); ok {
+		t.Error("Код неверной длины не должен проходить проверку")
+	}
+}
+
+func TestTOTPQRCode(t *testing.T) {
+	key, err := NewTOTPKey("agent@example.com")
+	if err != nil {
+		t.Fatalf("Ошибка генерации секрета: %v", err)
+	}
+
+	qr, err := TOTPQRCode(key.URL, 200)
+	if err != nil {
+		t.Fatalf("Ошибка генерации QR-кода: %v", err)
+	}
+
+	if !strings.HasPrefix(qr, "data:image/png;base64,") {
+		t.Error("QR-код должен возвращаться в виде data URL")
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("Ошибка генерации кодов: %v", err)
+	}
+
+	if len(codes) != 10 {
+		t.Fatalf("Ожидалось 10 кодов, получено %d", len(codes))
+	}
+
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("Некорректный формат кода: %s", code)
+		}
+		if seen[code] {
+			t.Errorf("Повторяющийся код: %s", code)
+		}
+		seen[code] = true
+	}
+}
@@ -0,0 +1,116 @@
+// Package calendar производственный календарь РФ: выходные, федеральные
+// праздники и переносы рабочих дней.
+package calendar
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Moscow часовой пояс календаря (без перехода на летнее время с 2014 года)
+var Moscow = time.FixedZone("MSK", 3*60*60)
+
+// dateLayout формат дат переносов
+const dateLayout = "2006-01-02"
+
+// maxDays ограничение перебора дней при расчетах
+const maxDays = 10 * 366
+
+// federalHolidays нерабочие праздничные дни (ст. 112 ТК РФ) в формате ММ-ДД
+var federalHolidays = map[string]bool{
+	"01-01": true, "01-02": true, "01-03": true, "01-04": true,
+	"01-05": true, "01-06": true, "01-07": true, "01-08": true,
+	"02-23": true, "03-08": true, "05-01": true, "05-09": true,
+	"06-12": true, "11-04": true,
+}
+
+// Calendar производственный календарь. Переносы выходных, которые ежегодно
+// устанавливает правительство, задаются явно: дополнительные выходные и
+// рабочие субботы.
+type Calendar struct {
+	holidays map[string]bool // дополнительные нерабочие дни
+	workdays map[string]bool // рабочие выходные дни
+}
+
+// Russian создает календарь с федеральными праздниками и переносами. Даты
+// переносов в формате ГГГГ-ММ-ДД.
+func Russian(holidays, workdays []string) (*Calendar, error) {
+	c := &Calendar{holidays: make(map[string]bool), workdays: make(map[string]bool)}
+	for _, set := range []struct {
+		dates  []string
+		target map[string]bool
+	}{{holidays, c.holidays}, {workdays, c.workdays}} {
+		for _, date := range set.dates {
+			date = strings.TrimSpace(date)
+			if date == "" {
+				continue
+			}
+			if _, err := time.Parse(dateLayout, date); err != nil {
+				return nil, fmt.Errorf("некорректная дата календаря: %s", date)
+			}
+			set.target[date] = true
+		}
+	}
+	return c, nil
+}
+
+// IsBusinessDay рабочий ли день (по московскому времени)
+func (c *Calendar) IsBusinessDay(t time.Time) bool {
+	t = t.In(Moscow)
+	date := t.Format(dateLayout)
+	if c.workdays[date] {
+		return true
+	}
+	if c.holidays[date] || federalHolidays[t.Format("01-02")] {
+		return false
+	}
+	return t.Weekday() != time.Saturday && t.Weekday() != time.Sunday
+}
+
+// startOfDay начало суток по московскому времени
+func startOfDay(t time.Time) time.Time {
+	t = t.In(Moscow)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, Moscow)
+}
+
+// BusinessTime время между from и to, приходящееся на рабочие дни
+func (c *Calendar) BusinessTime(from, to time.Time) time.Duration {
+	var total time.Duration
+	day := startOfDay(from)
+	for i := 0; day.Before(to) && i < maxDays; i++ {
+		next := day.AddDate(0, 0, 1)
+		if c.IsBusinessDay(day) {
+			start, end := day, next
+			if from.After(start) {
+				start = from
+			}
+			if to.Before(end) {
+				end = to
+			}
+			if end.After(start) {
+				total += end.Sub(start)
+			}
+		}
+		day = next
+	}
+	return total
+}
+
+// AddBusinessTime момент, когда от from пройдет d рабочего времени
+func (c *Calendar) AddBusinessTime(from time.Time, d time.Duration) time.Time {
+	current := from
+	day := startOfDay(from)
+	for i := 0; i < maxDays; i++ {
+		next := day.AddDate(0, 0, 1)
+		if c.IsBusinessDay(day) {
+			available := next.Sub(current)
+			if d <= available {
+				return current.Add(d)
+			}
+			d -= available
+		}
+		day, current = next, next
+	}
+	return current
+}
@@ -0,0 +1,78 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+)
+
+func date(value string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", value, Moscow)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestIsBusinessDay(t *testing.T) {
+	c, err := Russian([]string{"2025-05-02"}, []string{"2025-11-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"2025-03-07 12:00": true,  // пятница
+		"2025-03-08 12:00": false, // праздник и суббота
+		"2025-03-09 12:00": false, // воскресенье
+		"2025-01-06 12:00": false, // новогодние каникулы
+		"2025-06-12 12:00": false, // День России
+		"2025-05-02 12:00": false, // перенос выходного
+		"2025-11-01 12:00": true,  // рабочая суббота
+	}
+	for value, expected := range cases {
+		if got := c.IsBusinessDay(date(value)); got != expected {
+			t.Errorf("%s: ожидалось %v, получено %v", value, expected, got)
+		}
+	}
+
+	// 31.12 21:00 UTC - уже 1 января по Москве
+	if c.IsBusinessDay(time.Date(2024, 12, 31, 21, 0, 0, 0, time.UTC)) {
+		t.Error("День должен определяться по московскому времени")
+	}
+}
+
+func TestBusinessTime(t *testing.T) {
+	c, _ := Russian(nil, nil)
+
+	// С вечера пятницы до утра понедельника: 6 часов пятницы и 9 часов понедельника
+	if got := c.BusinessTime(date("2025-03-14 18:00"), date("2025-03-17 09:00")); got != 15*time.Hour {
+		t.Errorf("Ожидалось 15ч, получено %v", got)
+	}
+	// Праздничная неделя: 8 марта - суббота, 10 марта - рабочий понедельник
+	if got := c.BusinessTime(date("2025-03-07 00:00"), date("2025-03-11 00:00")); got != 48*time.Hour {
+		t.Errorf("Ожидалось 48ч, получено %v", got)
+	}
+	if got := c.BusinessTime(date("2025-03-11 00:00"), date("2025-03-10 00:00")); got != 0 {
+		t.Errorf("Для обратного интервала ожидалось 0, получено %v", got)
+	}
+}
+
+func TestAddBusinessTime(t *testing.T) {
+	c, _ := Russian(nil, nil)
+
+	// 24 рабочих часа от вечера пятницы: 6 часов пятницы и 18 часов понедельника
+	due := c.AddBusinessTime(date("2025-03-14 18:00"), 24*time.Hour)
+	if !due.Equal(date("2025-03-17 18:00")) {
+		t.Errorf("Неверный срок: %v", due)
+	}
+	// От выходного отсчет начинается с ближайшего рабочего дня
+	due = c.AddBusinessTime(date("2025-01-03 10:00"), 2*time.Hour)
+	if !due.Equal(date("2025-01-09 02:00")) {
+		t.Errorf("Неверный срок после каникул: %v", due)
+	}
+}
+
+func TestRussian_InvalidDate(t *testing.T) {
+	if _, err := Russian([]string{"02.05.2025"}, nil); err == nil {
+		t.Error("Ожидалась ошибка для некорректной даты")
+	}
+}
@@ -1,12 +1,48 @@
 package database
 
 import (
+	"database/sql"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+// driverName драйвер SQLite, в котором lower переводит в нижний регистр
+// любые буквы. Встроенная LOWER в SQLite меняет только латиницу, и поиск без
+// учета регистра (LOWER(name) LIKE ?) не находит русский текст.
+const driverName = "sqlite3_unicode"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("lower", unicodeLower, true)
+		},
+	})
+}
+
+// unicodeLower реализация lower для SQLite; NULL остается NULL
+func unicodeLower(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return strings.ToLower(v)
+	case []byte:
+		if v == nil {
+			return nil
+		}
+		return strings.ToLower(string(v))
+	}
+	return value
+}
+
+// Open открывает базу данных SQLite с lower, поддерживающей кириллицу
+func Open(dsn string, config *gorm.Config) (*gorm.DB, error) {
+	return gorm.Open(sqlite.Dialector{DriverName: driverName, DSN: dsn}, config)
+}
+
 func InitDB() *gorm.DB {
-	db, err := gorm.Open(sqlite.Open("tenderhelp.db"), &gorm.Config{})
+	db, err := Open("tenderhelp.db", &gorm.Config{})
 	if err != nil {
 		panic("Не удалось подключиться к базе данных")
 	}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestLowerCyrillic(t *testing.T) {
+	db, err := Open("file::memory:", &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lowered string
+	if err := db.Raw("SELECT LOWER(?)", "ООО КОНТУР Ltd").Scan(&lowered).Error; err != nil {
+		t.Fatal(err)
+	}
+	if lowered != "ооо контур ltd" {
+		t.Errorf("LOWER: получено %q", lowered)
+	}
+
+	var null *string
+	if err := db.Raw("SELECT LOWER(NULL)").Scan(&null).Error; err != nil {
+		t.Fatal(err)
+	}
+	if null != nil {
+		t.Errorf("LOWER(NULL): ожидался NULL, получено %q", *null)
+	}
+}
+
+func TestLikeCyrillic(t *testing.T) {
+	type client struct {
+		ID   uint
+		Name *string
+	}
+
+	db, err := Open("file::memory:", &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&client{}); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"ООО «Контур»", "ИП Иванов Иван", "АО Ромашка"}
+	for i := range names {
+		if err := db.Create(&client{Name: &names[i]}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Create(&client{})
+
+	cases := map[string]int{
+		"%контур%": 1,
+		"%иванов%": 1,
+		"%ИВАНОВ%": 0, // шаблон приводится к нижнему регистру до запроса
+		"%о%":      3,
+	}
+	for pattern, expected := range cases {
+		var count int64
+		if err := db.Model(&client{}).Where(`LOWER(name) LIKE ? ESCAPE '\'`, pattern).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		if int(count) != expected {
+			t.Errorf("%s: ожидалось %d, найдено %d", pattern, expected, count)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Package export записывает табличные выгрузки в CSV и XLSX построчно, не
+// накапливая строки в памяти.
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// ErrUnsupportedFormat формат выгрузки не CSV и не XLSX
+var ErrUnsupportedFormat = errors.New("поддерживаются форматы csv и xlsx")
+
+// errSingleSheet в CSV-файл можно записать только один лист
+var errSingleSheet = errors.New("CSV содержит только один лист")
+
+// Writer запись таблицы по листам. Строки записываются в последний
+// добавленный лист. Значения: строки, числа, bool, time.Time и nil.
+type Writer interface {
+	AddSheet(name string, header []string) error
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// New создает запись в формате "csv" или "xlsx". Для CSV с несколькими
+// листами (sheets > 1) каждый лист записывается отдельным файлом в ZIP-архив.
+func New(format string, w io.Writer, sheets int) (Writer, error) {
+	switch format {
+	case "csv":
+		if sheets > 1 {
+			return &csvArchive{archive: zip.NewWriter(w)}, nil
+		}
+		return &csvWriter{out: w}, nil
+	case "xlsx":
+		return newXLSX(w)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// ContentType тип содержимого выгрузки
+func ContentType(format string, sheets int) string {
+	switch {
+	case format == "xlsx":
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case sheets > 1:
+		return "application/zip"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Extension расширение файла выгрузки
+func Extension(format string, sheets int) string {
+	if format == "csv" && sheets > 1 {
+		return "zip"
+	}
+	return format
+}
+
+// csvWriter CSV для Excel: BOM, разделитель ";", десятичная запятая
+type csvWriter struct {
+	out    io.Writer
+	writer *csv.Writer
+}
+
+func (w *csvWriter) AddSheet(_ string, header []string) error {
+	if w.writer != nil {
+		return errSingleSheet
+	}
+	if _, err := io.WriteString(w.out, "\xef\xbb\xbf"); err != nil {
+		return err
+	}
+	w.writer = csv.NewWriter(w.out)
+	w.writer.Comma = ';'
+	return w.writer.Write(header)
+}
+
+func (w *csvWriter) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = FormatValue(value)
+		if _, ok := value.(string); ok {
+			record[i] = escapeFormula(record[i])
+		}
+	}
+	return w.writer.Write(record)
+}
+
+// escapeFormula экранирует строку, которую Excel при открытии CSV выполнил
+// бы как формулу (=, +, -, @, табуляция или возврат каретки в начале)
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func (w *csvWriter) Close() error {
+	if w.writer == nil {
+		return nil
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// csvArchive ZIP-архив с CSV-файлом на каждый лист
+type csvArchive struct {
+	archive *zip.Writer
+	sheet   *csvWriter
+}
+
+func (w *csvArchive) AddSheet(name string, header []string) error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	file, err := w.archive.Create(name + ".csv")
+	if err != nil {
+		return err
+	}
+	w.sheet = &csvWriter{out: file}
+	return w.sheet.AddSheet(name, header)
+}
+
+func (w *csvArchive) WriteRow(values []interface{}) error {
+	return w.sheet.WriteRow(values)
+}
+
+func (w *csvArchive) flush() error {
+	if w.sheet == nil {
+		return nil
+	}
+	return w.sheet.Close()
+}
+
+func (w *csvArchive) Close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.archive.Close()
+}
+
+// xlsxWriter книга Excel. Строки пишутся потоково: excelize переносит
+// большие листы во временные файлы. Книга целиком отдается в Close.
+type xlsxWriter struct {
+	out       io.Writer
+	book      *excelize.File
+	sheets    []*excelize.StreamWriter
+	row       int
+	dateStyle int
+}
+
+func newXLSX(w io.Writer) (*xlsxWriter, error) {
+	book := excelize.NewFile()
+	dateFormat := "dd.mm.yyyy hh:mm"
+	dateStyle, err := book.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat})
+	if err != nil {
+		book.Close()
+		return nil, err
+	}
+	return &xlsxWriter{out: w, book: book, dateStyle: dateStyle}, nil
+}
+
+func (w *xlsxWriter) AddSheet(name string, header []string) error {
+	if len(w.sheets) == 0 {
+		// Первый лист книги уже создан, он переименовывается
+		if err := w.book.SetSheetName(w.book.GetSheetName(0), name); err != nil {
+			return err
+		}
+	} else if _, err := w.book.NewSheet(name); err != nil {
+		return err
+	}
+
+	sheet, err := w.book.NewStreamWriter(name)
+	if err != nil {
+		return err
+	}
+	w.sheets = append(w.sheets, sheet)
+	w.row = 1
+
+	cells := make([]interface{}, len(header))
+	for i, title := range header {
+		cells[i] = title
+	}
+	return sheet.SetRow("A1", cells)
+}
+
+func (w *xlsxWriter) WriteRow(values []interface{}) error {
+	w.row++
+	cells := make([]interface{}, len(values))
+	for i, value := range values {
+		if t, ok := value.(time.Time); ok {
+			cells[i] = excelize.Cell{StyleID: w.dateStyle, Value: t}
+		} else {
+			cells[i] = value
+		}
+	}
+	cell, err := excelize.CoordinatesToCellName(1, w.row)
+	if err != nil {
+		return err
+	}
+	return w.sheets[len(w.sheets)-1].SetRow(cell, cells)
+}
+
+func (w *xlsxWriter) Close() error {
+	defer w.book.Close()
+	for _, sheet := range w.sheets {
+		if err := sheet.Flush(); err != nil {
+			return err
+		}
+	}
+	return w.book.Write(w.out)
+}
+
+// FormatValue значение ячейки CSV в русской записи: даты ДД.ММ.ГГГГ ЧЧ:ММ,
+// десятичная запятая, да/нет
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format("02.01.2006 15:04")
+	case float64:
+		return strings.Replace(strconv.FormatFloat(v, 'f', -1, 64), ".", ",", 1)
+	case bool:
+		if v {
+			return "да"
+		}
+		return "нет"
+	}
+	return fmt.Sprint(value)
+}
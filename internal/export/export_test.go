@@ -0,0 +1,123 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := New("csv", &buf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.AddSheet("applications", []string{"ID", "Сумма", "Создана", "Подписана"})
+	w.WriteRow([]interface{}{uint(1), 1500000.5, time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC), true})
+	if err := w.AddSheet("history", nil); err == nil {
+		t.Error("Второй лист в CSV должен возвращать ошибку")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "\xef\xbb\xbfID;Сумма;Создана;Подписана\n1;1500000,5;15.03.2024 10:30;да\n"
+	if buf.String() != expected {
+		t.Errorf("Неверный CSV:\n%q", buf.String())
+	}
+}
+
+func TestCSV_EscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := New("csv", &buf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.AddSheet("applications", []string{"Значение"})
+	for _, value := range []interface{}{"=HYPERLINK(\"http://x\")", "+7 900", "-1+2", "@SUM(A1)", "\tTAB", "\rCR", "ООО Ромашка", -1500.5} {
+		w.WriteRow([]interface{}{value})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "\xef\xbb\xbfЗначение\n" +
+		"\"'=HYPERLINK(\"\"http://x\"\")\"\n" +
+		"'+7 900\n" +
+		"'-1+2\n" +
+		"'@SUM(A1)\n" +
+		"'\tTAB\n" +
+		"\"'\rCR\"\n" +
+		"ООО Ромашка\n" +
+		"-1500,5\n" // числа не экранируются
+	if buf.String() != expected {
+		t.Errorf("Неверный CSV:\n%q", buf.String())
+	}
+}
+
+func TestCSVArchive(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := New("csv", &buf, 2)
+	w.AddSheet("applications", []string{"ID"})
+	w.WriteRow([]interface{}{1})
+	w.AddSheet("status_history", []string{"ID заявки", "Статус"})
+	w.WriteRow([]interface{}{1, "draft"})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Некорректный архив: %v", err)
+	}
+	if len(archive.File) != 2 || archive.File[1].Name != "status_history.csv" {
+		t.Fatalf("Неверные файлы архива: %v", archive.File)
+	}
+	file, _ := archive.File[1].Open()
+	data, _ := io.ReadAll(file)
+	if string(data) != "\xef\xbb\xbfID заявки;Статус\n1;draft\n" {
+		t.Errorf("Неверный лист: %q", data)
+	}
+}
+
+func TestXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := New("xlsx", &buf, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.AddSheet("Заявки", []string{"ID", "Сумма"})
+	for i := 1; i <= 3; i++ {
+		w.WriteRow([]interface{}{i, float64(i) * 1000})
+	}
+	w.AddSheet("История", []string{"ID заявки", "Дата"})
+	w.WriteRow([]interface{}{1, time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	book, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("Некорректная книга: %v", err)
+	}
+	if sheets := book.GetSheetList(); len(sheets) != 2 || sheets[0] != "Заявки" {
+		t.Fatalf("Неверные листы: %v", sheets)
+	}
+	rows, _ := book.GetRows("Заявки")
+	if len(rows) != 4 || rows[3][1] != "3000" {
+		t.Errorf("Неверные строки: %v", rows)
+	}
+	if date, _ := book.GetCellValue("История", "B2"); date != "15.03.2024 00:00" {
+		t.Errorf("Неверная дата: %s", date)
+	}
+}
+
+func TestNew_UnsupportedFormat(t *testing.T) {
+	if _, err := New("pdf", io.Discard, 1); err != ErrUnsupportedFormat {
+		t.Errorf("Ожидалась ошибка формата, получено %v", err)
+	}
+}
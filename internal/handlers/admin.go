@@ -4,7 +4,6 @@ import (
 	"io"
 	"net/http"
 	"tenderhelp/internal/database"
-	"tenderhelp/internal/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -121,10 +120,10 @@ func GetAdminAnalytics(c *gin.Context) {
 	var rejectedRequests int64
 	var inProgressRequests int64
 
-	db.Model(&models.Request{}).Count(&totalRequests)
-	db.Model(&models.Request{}).Where("status = ?", "Одобрено").Count(&approvedRequests)
-	db.Model(&models.Request{}).Where("status = ?", "Отклонено").Count(&rejectedRequests)
-	db.Model(&models.Request{}).Where("status = ?", "В работе").Count(&inProgressRequests)
+	db.Model(&Application{}).Count(&totalRequests)
+	db.Model(&Application{}).Where("status IN ?", legacyStatusFilter("Одобрено")).Count(&approvedRequests)
+	db.Model(&Application{}).Where("status IN ?", legacyStatusFilter("Отклонено")).Count(&rejectedRequests)
+	db.Model(&Application{}).Where("status IN ?", legacyStatusFilter("В работе")).Count(&inProgressRequests)
 
 	analytics := gin.H{
 		"total_requests": totalRequests,
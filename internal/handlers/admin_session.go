@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+	"tenderhelp/internal/auth"
+	"tenderhelp/internal/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Параметры cookie-сессий админки
+const (
+	adminSessionCookie = "admin_session"
+	adminCSRFHeader    = "X-CSRF-Token"
+	sessionKindAdmin   = "admin"
+	roleAdmin          = "admin"
+
+	// adminSessionTTL максимальная длительность сессии админки
+	adminSessionTTL = 8 * time.Hour
+	// adminSessionIdleTTL сессия завершается после периода бездействия
+	adminSessionIdleTTL = 30 * time.Minute
+	// adminSessionTouchInterval как часто обновляется время последней активности
+	adminSessionTouchInterval = time.Minute
+)
+
+// adminSessionResponse ответ на вход и запрос текущей сессии админки
+type adminSessionResponse struct {
+	User      models.User `json:"user"`
+	CSRFToken string      `json:"csrf_token"`
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+// adminCookieSecure определяет флаг Secure cookie. Отключается только для
+// локальной разработки без HTTPS (ADMIN_COOKIE_SECURE=false).
+func adminCookieSecure() bool {
+	return os.Getenv("ADMIN_COOKIE_SECURE") != "false"
+}
+
+// adminCSRFToken CSRF-токен сессии. Выводится из секрета cookie, поэтому
+// не хранится отдельно, а сторонний сайт не может его получить: cookie
+// недоступна ни скриптам (HttpOnly), ни чужим источникам.
+func adminCSRFToken(sessionToken string) string {
+	return auth.HashToken("csrf:" + sessionToken)
+}
+
+// setAdminSessionCookie устанавливает или удаляет (maxAge < 0) cookie сессии
+func setAdminSessionCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     adminSessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   adminCookieSecure(),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// startAdminSession создает cookie-сессию админки
+func startAdminSession(c *gin.Context, user *models.User) {
+	token, err := auth.NewRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания сессии"})
+		return
+	}
+
+	now := time.Now()
+	session := Session{
+		UserID:           user.ID,
+		Kind:             sessionKindAdmin,
+		RefreshTokenHash: auth.HashToken(token),
+		UserAgent:        c.GetHeader("User-Agent"),
+		IP:               c.ClientIP(),
+		CreatedAt:        now,
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(adminSessionTTL),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания сессии"})
+		return
+	}
+
+	setAdminSessionCookie(c, token, int(adminSessionTTL.Seconds()))
+	c.JSON(http.StatusOK, adminSessionResponse{
+		User:      *user,
+		CSRFToken: adminCSRFToken(token),
+		ExpiresAt: session.ExpiresAt,
+	})
+}
+
+// AdminLogin вход в админку по паролю. Доступен только администраторам;
+// при подключенной 2FA возвращает токен для второго шага.
+func AdminLogin(c *gin.Context) {
+	var req loginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+
+	if !checkLoginThrottle(c, req.Email) {
+		return
+	}
+
+	var user models.User
+	if err := db.Where("email = ?", strings.ToLower(req.Email)).First(&user).Error; err != nil {
+		registerLoginFailure(c, req.Email)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверные учетные данные"})
+		return
+	}
+
+	if !user.IsActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Аккаунт заблокирован"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		registerLoginFailure(c, req.Email)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверные учетные данные"})
+		return
+	}
+
+	if user.Role != roleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Доступ только для администраторов"})
+		return
+	}
+
+	// Смена пароля и подключение 2FA выполняются в основном приложении
+	if mustChangePassword(user.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Требуется смена пароля. Войдите в основное приложение"})
+		return
+	}
+
+	security := loadUserSecurity(user.ID)
+	switch {
+	case security.TOTPEnabled:
+		respondMFAChallenge(c, &user, auth.PurposeAdminMFA, mfaTokenTTL)
+	case rolePermissions.requiresMFA(user.Role):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Подключите двухфакторную аутентификацию в основном приложении"})
+	default:
+		resetLoginThrottle(user.Email)
+		startAdminSession(c, &user)
+	}
+}
+
+// AdminLoginMFA второй шаг входа в админку
+func AdminLoginMFA(c *gin.Context) {
+	var req LoginMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+
+	user, ok := mfaTokenUser(c, req.MFAToken, auth.PurposeAdminMFA)
+	if !ok {
+		return
+	}
+	if user.Role != roleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Доступ только для администраторов"})
+		return
+	}
+	if !checkSecondFactor(c, user, req) {
+		return
+	}
+
+	startAdminSession(c, user)
+}
+
+// AdminLogout завершает сессию админки
+func AdminLogout(c *gin.Context) {
+	claims, _ := currentClaims(c)
+	if err := revokeSession(claims.SessionID, revokeReasonLogout); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения сессии"})
+		return
+	}
+
+	setAdminSessionCookie(c, "", -1)
+	c.JSON(http.StatusOK, gin.H{"message": "Выход выполнен успешно"})
+}
+
+// GetAdminSession возвращает пользователя текущей сессии и CSRF-токен
+func GetAdminSession(c *gin.Context) {
+	claims, _ := currentClaims(c)
+
+	var user models.User
+	if err := db.First(&user, claims.UserID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	var session Session
+	db.Select("id", "expires_at").First(&session, claims.SessionID)
+
+	c.JSON(http.StatusOK, adminSessionResponse{
+		User:      user,
+		CSRFToken: c.GetString("csrf_token"),
+		ExpiresAt: session.ExpiresAt,
+	})
+}
+
+// authenticateAdminSession проверяет cookie сессии админки и заполняет
+// контекст так же, как RequireAuth
+func authenticateAdminSession(c *gin.Context) bool {
+	token, err := c.Cookie(adminSessionCookie)
+	if err != nil || token == "" {
+		return false
+	}
+
+	var session Session
+	if err := db.Where("refresh_token_hash = ? AND kind = ?", auth.HashToken(token), sessionKindAdmin).
+		First(&session).Error; err != nil {
+		return false
+	}
+	if !session.IsActive() {
+		return false
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastUsedAt) > adminSessionIdleTTL {
+		revokeSession(session.ID, revokeReasonIdle)
+		return false
+	}
+
+	var user models.User
+	if err := db.First(&user, session.UserID).Error; err != nil || !user.IsActive {
+		revokeSession(session.ID, revokeReasonAdmin)
+		return false
+	}
+
+	if now.Sub(session.LastUsedAt) > adminSessionTouchInterval {
+		db.Model(&Session{}).Where("id = ?", session.ID).
+			Updates(map[string]interface{}{"last_used_at": now, "ip": c.ClientIP()})
+	}
+
+	claims := &auth.Claims{
+		UserID:      user.ID,
+		SessionID:   session.ID,
+		Role:        user.Role,
+		Permissions: userPermissions(&user),
+	}
+	c.Set("user_id", claims.UserID)
+	c.Set("user_role", claims.Role)
+	c.Set("permissions", claims.Permissions)
+	c.Set("claims", claims)
+	c.Set("csrf_token", adminCSRFToken(token))
+	return true
+}
+
+// isSafeMethod методы, не изменяющие данные (не требуют CSRF-токена)
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// RequireAdminSession middleware проверяет cookie-сессию админки, а для
+// изменяющих запросов - CSRF-токен в заголовке X-CSRF-Token
+func RequireAdminSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authenticateAdminSession(c) {
+			setAdminSessionCookie(c, "", -1)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Не авторизован"})
+			c.Abort()
+			return
+		}
+
+		if !isSafeMethod(c.Request.Method) {
+			expected := c.GetString("csrf_token")
+			provided := c.GetHeader(adminCSRFHeader)
+			if provided == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Недействительный CSRF-токен"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// AdminPage отдает страницу админки администратору с активной сессией,
+// остальных перенаправляет на страницу входа
+func AdminPage(template, loginPath string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authenticateAdminSession(c) || c.GetString("user_role") != roleAdmin {
+			c.Redirect(http.StatusFound, loginPath)
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.HTML(http.StatusOK, template, gin.H{"CSRFToken": c.GetString("csrf_token")})
+	}
+}
@@ -18,10 +18,11 @@ func GetAnalytics(c *gin.Context) {
 	var inProgressRequests int64
 	var approvedRequests int64
 
-	db.Model(&models.Request{}).Count(&totalRequests)
+	// Заявки старого образца учитываются после переноса в заявки
+	db.Model(&Application{}).Count(&totalRequests)
 	db.Model(&models.Client{}).Where("created_at > ?", time.Now().AddDate(0, 0, -7)).Count(&newClients)
-	db.Model(&models.Request{}).Where("status = ?", "В работе").Count(&inProgressRequests)
-	db.Model(&models.Request{}).Where("status = ?", "Одобрено").Count(&approvedRequests)
+	db.Model(&Application{}).Where("status IN ?", legacyStatusFilter("В работе")).Count(&inProgressRequests)
+	db.Model(&Application{}).Where("status IN ?", legacyStatusFilter("Одобрено")).Count(&approvedRequests)
 
 	// Расчет процентов
 	var approvalRate float64
@@ -42,7 +43,7 @@ func GetAnalytics(c *gin.Context) {
 		endOfMonth := startOfMonth.AddDate(0, 1, 0)
 
 		var count int64
-		db.Model(&models.Request{}).Where("created_at BETWEEN ? AND ?", startOfMonth, endOfMonth).Count(&count)
+		db.Model(&Application{}).Where("created_at BETWEEN ? AND ?", startOfMonth, endOfMonth).Count(&count)
 
 		monthlyData = append([]gin.H{
 			gin.H{
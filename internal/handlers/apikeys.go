@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"tenderhelp/internal/auth"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// APIKey ключ для машинного доступа банка-партнера
+type APIKey struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Name        string     `json:"name"`
+	BankID      string     `json:"bank_id" gorm:"index"`
+	Prefix      string     `json:"prefix" gorm:"uniqueIndex"` // открытая часть ключа
+	KeyHash     string     `json:"-"`
+	Scopes      []string   `json:"scopes" gorm:"serializer:json"`
+	AllowedIPs  []string   `json:"allowed_ips" gorm:"serializer:json"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP  string     `json:"last_used_ip"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	CreatedByID uint       `json:"created_by_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// CreateAPIKeyRequest запрос на выпуск ключа
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	BankID        string   `json:"bank_id" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	AllowedIPs    []string `json:"allowed_ips"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// BankDecisionRequest решение банка по заявке
+type BankDecisionRequest struct {
+	Decision       string  `json:"decision" binding:"required,oneof=approved rejected need_info"`
+	ApprovedAmount float64 `json:"approved_amount"`
+	Rate           float64 `json:"rate"`
+	Comment        string  `json:"comment"`
+}
+
+// apiKeyScopes области действия ключей и соответствующие им права
+var apiKeyScopes = map[string][]string{
+	"applications:read": {"view_applications"},
+	"applications:pii":  {"view_applications", "view_pii"},
+	"decisions:write":   {"view_applications", "submit_bank_decisions"},
+}
+
+// Ограничения на выпуск ключей
+const (
+	defaultAPIKeyTTLDays = 365
+	maxAPIKeyTTLDays     = 730
+)
+
+// apiKeyTouchInterval как часто обновлять время последнего использования
+// (чтобы не писать в БД на каждый запрос)
+const apiKeyTouchInterval = time.Minute
+
+// Типы событий безопасности для ключей API
+const (
+	securityEventAPIKeyCreated  = "api_key_created"
+	securityEventAPIKeyRevoked  = "api_key_revoked"
+	securityEventAPIKeyDeniedIP = "api_key_denied_ip"
+)
+
+// apiKeyPermissions возвращает права, которые дают области действия ключа
+func apiKeyPermissions(scopes []string) []string {
+	seen := make(map[string]bool)
+	var permissions []string
+	for _, scope := range scopes {
+		for _, permission := range apiKeyScopes[scope] {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	return permissions
+}
+
+// extractAPIKey возвращает ключ API из заголовка X-API-Key или Authorization
+func extractAPIKey(c *gin.Context) (string, bool) {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key, true
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if auth.IsAPIKey(token) {
+		return token, true
+	}
+	return "", false
+}
+
+// authenticateAPIKey проверяет ключ API и заполняет контекст запроса так же,
+// как для пользователя банка-партнера. Права ключа ограничены его областями
+// действия, а доступ к заявкам - его банком.
+func authenticateAPIKey(c *gin.Context, key string) bool {
+	prefix, ok := auth.ParseAPIKey(key)
+	if !ok {
+		return false
+	}
+
+	var apiKey APIKey
+	if err := db.Where("prefix = ?", prefix).First(&apiKey).Error; err != nil {
+		return false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(auth.HashToken(key))) != 1 {
+		return false
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && !now.Before(*apiKey.ExpiresAt)) {
+		return false
+	}
+
+	// Адрес из X-Forwarded-For учитывается только от прокси из TRUSTED_PROXIES,
+	// иначе банк мог бы подставить адрес из списка разрешенных
+	ip := c.ClientIP()
+	if !auth.IPAllowed(ip, apiKey.AllowedIPs) {
+		recordSecurityEvent(SecurityEvent{
+			Type:    securityEventAPIKeyDeniedIP,
+			IP:      ip,
+			Details: fmt.Sprintf("Ключ %s (%s) использован с неразрешенного адреса", apiKey.Prefix, apiKey.BankID),
+		})
+		return false
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyTouchInterval || apiKey.LastUsedIP != ip {
+		db.Model(&apiKey).Updates(map[string]interface{}{"last_used_at": now, "last_used_ip": ip})
+	}
+
+	claims := &auth.Claims{
+		Role:        rolePartnerBank,
+		Permissions: apiKeyPermissions(apiKey.Scopes),
+	}
+	c.Set("user_id", uint(0))
+	c.Set("user_role", claims.Role)
+	c.Set("permissions", claims.Permissions)
+	c.Set("claims", claims)
+	c.Set("api_key_id", apiKey.ID)
+	c.Set("bank_id", apiKey.BankID)
+	return true
+}
+
+// isAPIKeyRequest проверяет, что запрос выполнен по ключу API
+func isAPIKeyRequest(c *gin.Context) bool {
+	_, exists := c.Get("api_key_id")
+	return exists
+}
+
+// CreateAPIKey выпускает ключ API для банка. Ключ возвращается только в
+// этом ответе, в базе данных хранится его хеш.
+func CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.BankID = strings.TrimSpace(req.BankID)
+	if _, err := adapterManager.GetAdapter(req.BankID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Банк не найден"})
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if _, ok := apiKeyScopes[scope]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неизвестная область действия: " + scope})
+			return
+		}
+	}
+
+	for i, entry := range req.AllowedIPs {
+		if !auth.ValidIPEntry(entry) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный адрес: " + entry})
+			return
+		}
+		req.AllowedIPs[i] = strings.TrimSpace(entry)
+	}
+
+	days := req.ExpiresInDays
+	if days <= 0 {
+		days = defaultAPIKeyTTLDays
+	}
+	if days > maxAPIKeyTTLDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Срок действия ключа не может превышать %d дней", maxAPIKeyTTLDays)})
+		return
+	}
+	expiresAt := time.Now().AddDate(0, 0, days)
+
+	key, prefix, err := auth.NewAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания ключа"})
+		return
+	}
+
+	actorID := currentActor(c).UserID
+	apiKey := APIKey{
+		Name:        strings.TrimSpace(req.Name),
+		BankID:      req.BankID,
+		Prefix:      prefix,
+		KeyHash:     auth.HashToken(key),
+		Scopes:      req.Scopes,
+		AllowedIPs:  req.AllowedIPs,
+		ExpiresAt:   &expiresAt,
+		CreatedByID: actorID,
+	}
+	if err := db.Create(&apiKey).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания ключа"})
+		return
+	}
+
+	recordSecurityEvent(SecurityEvent{
+		Type:    securityEventAPIKeyCreated,
+		ActorID: actorID,
+		IP:      c.ClientIP(),
+		Details: fmt.Sprintf("Выпущен ключ %s для банка %s", apiKey.Prefix, apiKey.BankID),
+	})
+
+	recordAudit(c, "api_key.create", "api_key", apiKey.ID, nil, apiKey)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Ключ создан. Сохраните его: повторно он показан не будет",
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+// GetAPIKeys возвращает выпущенные ключи (без секретов)
+func GetAPIKeys(c *gin.Context) {
+	query := db.Order("created_at DESC")
+	if bankID := c.Query("bank_id"); bankID != "" {
+		query = query.Where("bank_id = ?", bankID)
+	}
+
+	var keys []APIKey
+	if err := query.Find(&keys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения ключей"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": keys,
+		"total":    len(keys),
+	})
+}
+
+// RevokeAPIKey отзывает ключ API
+func RevokeAPIKey(c *gin.Context) {
+	var apiKey APIKey
+	if err := db.First(&apiKey, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Ключ не найден"})
+		return
+	}
+
+	if apiKey.RevokedAt != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Ключ уже отозван"})
+		return
+	}
+
+	if err := db.Model(&apiKey).Update("revoked_at", time.Now()).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отзыва ключа"})
+		return
+	}
+
+	recordSecurityEvent(SecurityEvent{
+		Type:    securityEventAPIKeyRevoked,
+		ActorID: currentActor(c).UserID,
+		IP:      c.ClientIP(),
+		Details: fmt.Sprintf("Отозван ключ %s банка %s", apiKey.Prefix, apiKey.BankID),
+	})
+
+	recordAudit(c, "api_key.revoke", "api_key", apiKey.ID, gin.H{"revoked": false}, gin.H{"revoked": true})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ключ отозван"})
+}
+
+// SubmitBankDecision принимает решение банка по направленной ему заявке
+func SubmitBankDecision(c *gin.Context) {
+	applicationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID заявки"})
+		return
+	}
+
+	var req BankDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	bankID, ok := actorBankID(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Пользователь не привязан к банку"})
+		return
+	}
+
+	var route ApplicationRoute
+	if err := db.Where("application_id = ? AND bank_id = ?", applicationID, bankID).First(&route).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Заявка не найдена"})
+		return
+	}
+
+	before := route
+	now := time.Now()
+	comment := fmt.Sprintf("Банк %s: %s", bankID, req.Decision)
+	if req.Comment != "" {
+		comment += ". " + req.Comment
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&route).Updates(map[string]interface{}{
+			"status":          req.Decision,
+			"message":         req.Comment,
+			"approved_amount": req.ApprovedAmount,
+			"rate":            req.Rate,
+			"decided_at":      now,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&StatusHistory{
+			ApplicationID: route.ApplicationID,
+			Status:        "bank_response",
+			ActorID:       currentActor(c).UserID,
+			Timestamp:     now,
+			Comment:       comment,
+		}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения решения"})
+		return
+	}
+
+	db.First(&route, route.ID)
+	recordAudit(c, "application.bank_decision", "application_route", route.ID, before, route)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Решение банка сохранено",
+		"route":   route,
+	})
+}
+
+// actorBankID возвращает банк текущего запроса: банк ключа API или банк,
+// к которому привязан пользователь
+func actorBankID(c *gin.Context) (string, bool) {
+	if bankID := c.GetString("bank_id"); bankID != "" {
+		return bankID, true
+	}
+
+	var membership BankMembership
+	if err := db.Where("user_id = ?", currentActor(c).UserID).First(&membership).Error; err != nil {
+		return "", false
+	}
+	return membership.BankID, true
+}
@@ -2,10 +2,15 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
-	"strconv"
+	"net/url"
 	"tenderhelp/internal/database"
+	"tenderhelp/internal/jsonschema"
+	"tenderhelp/internal/questionnaire"
 	"tenderhelp/internal/scoring"
 	"time"
 
@@ -16,14 +21,36 @@ import (
 // Application представляет заявку
 type Application struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
-	ClientID  uint      `json:"client_id"`
-	Type      string    `json:"type"` // credit, guarantee
+	ClientID  uint      `json:"client_id" gorm:"index"`
+	AgentID   uint      `json:"agent_id" gorm:"index"` // пользователь, создавший заявку
+	Type      string    `json:"type"`                  // credit, guarantee
 	Amount    float64   `json:"amount"`
 	Status    string    `json:"status"`
 	Bank      string    `json:"bank"`
+	ManagerID uint      `json:"manager_id" gorm:"index"`           // ответственный менеджер
+	RiskClass string    `json:"risk_class,omitempty" gorm:"index"` // класс риска последнего скоринга
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 
+	// Версия для проверки If-Match: увеличивается при изменении анкеты,
+	// типа и статуса заявки
+	Version int `json:"version" gorm:"not null;default:1"`
+
+	// Заявка, копией которой создана эта заявка
+	SourceApplicationID *uint `json:"source_application_id,omitempty" gorm:"index"`
+
+	// Данные закупки
+	TenderDetails `gorm:"embedded"`
+
+	// Заявка старого образца (/api/requests), перенесенная в эту заявку
+	LegacyRequestID *uint `json:"legacy_request_id,omitempty" gorm:"uniqueIndex"`
+
+	// Удалить можно только черновик (через /api/requests)
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Срок нахождения в текущем статусе (рассчитывается при чтении)
+	SLA *SLAStatus `json:"sla,omitempty" gorm:"-"`
+
 	// Данные анкеты
 	PersonalData     json.RawMessage `json:"personal_data" gorm:"type:jsonb"`
 	ContactData      json.RawMessage `json:"contact_data" gorm:"type:jsonb"`
@@ -32,15 +59,34 @@ type Application struct {
 	FamilyData       json.RawMessage `json:"family_data" gorm:"type:jsonb"`
 	AdditionalData   json.RawMessage `json:"additional_data" gorm:"type:jsonb"`
 
+	// Шифрование персональных данных: идентификатор мастер-ключа и
+	// зашифрованный им ключ данных заявки
+	PIIKeyID   string `json:"-" gorm:"column:pii_key_id;index"`
+	PIIDataKey string `json:"-" gorm:"column:pii_data_key"`
+
 	// История статусов
 	StatusHistory []StatusHistory `json:"status_history" gorm:"foreignKey:ApplicationID"`
 }
 
+// TenderDetails данные закупки, под которую оформляется гарантия или кредит
+type TenderDetails struct {
+	Number       string     `json:"number,omitempty" gorm:"index"` // номер заявки у брокера
+	NoticeNumber string     `json:"notice_number,omitempty"`       // номер извещения о закупке
+	LawNumber    string     `json:"law_number,omitempty"`          // 44-ФЗ, 223-ФЗ
+	Term         int        `json:"term,omitempty"`                // срок в днях
+	StartDate    *time.Time `json:"start_date,omitempty"`
+	EndDate      *time.Time `json:"end_date,omitempty"`
+	Signature    string     `json:"signature,omitempty"`
+}
+
 // StatusHistory представляет историю статусов
 type StatusHistory struct {
 	ID            uint      `json:"id" gorm:"primaryKey"`
 	ApplicationID uint      `json:"application_id"`
+	FromStatus    string    `json:"from_status"`
 	Status        string    `json:"status"`
+	ActorID       uint      `json:"actor_id"`        // пользователь, выполнивший переход (0 - система)
+	Stage         string    `json:"stage,omitempty"` // этап автоматической обработки
 	Timestamp     time.Time `json:"timestamp"`
 	Comment       string    `json:"comment"`
 }
@@ -49,12 +95,19 @@ type StatusHistory struct {
 type CreateApplicationRequest struct {
 	Type             string          `json:"type" binding:"required"`
 	Amount           float64         `json:"amount"`
+	ClientID         uint            `json:"clientId"`
 	PersonalData     json.RawMessage `json:"personalData" binding:"required"`
 	ContactData      json.RawMessage `json:"contactData" binding:"required"`
 	ProfessionalData json.RawMessage `json:"professionalData" binding:"required"`
 	FinancialData    json.RawMessage `json:"financialData" binding:"required"`
 	FamilyData       json.RawMessage `json:"familyData" binding:"required"`
 	AdditionalData   json.RawMessage `json:"additionalData" binding:"required"`
+
+	// Данные закупки
+	Tender TenderDetails `json:"tender"`
+
+	// Причина подачи, если найдены похожие заявки клиента
+	DuplicateReason string `json:"duplicateReason"`
 }
 
 // UpdateApplicationRequest запрос на обновление заявки
@@ -67,8 +120,9 @@ type UpdateApplicationRequest struct {
 type GetApplicationsResponse struct {
 	Applications []Application `json:"applications"`
 	Total        int64         `json:"total"`
-	Page         int           `json:"page"`
+	Page         int           `json:"page,omitempty"`
 	Limit        int           `json:"limit"`
+	NextCursor   string        `json:"next_cursor,omitempty"` // курсор следующей страницы
 }
 
 // CreateApplication создает новую заявку
@@ -79,96 +133,135 @@ func CreateApplication(c *gin.Context) {
 		return
 	}
 
-	// Валидация данных анкеты
-	if err := validateApplicationData(req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// Валидация данных анкеты: черновик может быть заполнен не полностью
+	if err := validateApplicationData(req, jsonschema.Partial); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	// Клиент заявки: указанный в запросе или компания пользователя-клиента
+	clientID, ok := resolveApplicationClient(c, req.ClientID)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Нет доступа к клиенту"})
 		return
 	}
 
-	// Создание заявки
-	application := Application{
-		ClientID:         1, // TODO: получить из JWT токена
+	// Создание заявки с проверкой на дубликаты
+	application := newDraft(req, clientID, currentActor(c).UserID)
+	override, ok := checkDuplicates(c, application, req.DuplicateReason, duplicateStageCreate)
+	if !ok {
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := createDraft(tx, &application, "Заявка создана"); err != nil {
+			return err
+		}
+		if override != nil {
+			return saveDuplicateOverride(tx, override, application.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания заявки"})
+		return
+	}
+
+	recordAudit(c, "application.create", "application", application.ID, nil, application.maskedPII())
+	if override != nil {
+		recordAudit(c, "application.duplicate_override", "application", application.ID, nil, override)
+	}
+
+	setApplicationETag(c, &application)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":     "Заявка успешно создана",
+		"application": presentApplication(c, application),
+	})
+}
+
+// newDraft создает черновик заявки из запроса
+func newDraft(req CreateApplicationRequest, clientID, agentID uint) Application {
+	now := time.Now()
+	return Application{
+		ClientID:         clientID,
+		AgentID:          agentID,
 		Type:             req.Type,
 		Amount:           req.Amount,
-		Status:           "draft",
+		Status:           string(statusDraft),
+		Version:          1,
+		TenderDetails:    req.Tender,
 		PersonalData:     req.PersonalData,
 		ContactData:      req.ContactData,
 		ProfessionalData: req.ProfessionalData,
 		FinancialData:    req.FinancialData,
 		FamilyData:       req.FamilyData,
 		AdditionalData:   req.AdditionalData,
-		CreatedAt:        time.Now(),
-		UpdatedAt:        time.Now(),
+		CreatedAt:        now,
+		UpdatedAt:        now,
 	}
+}
 
-	// Сохранение в базу данных
-	if err := db.Create(&application).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания заявки"})
-		return
+// createDraft сохраняет черновик вместе с первыми ревизиями шагов и
+// записью в истории статусов
+func createDraft(tx *gorm.DB, application *Application, comment string) error {
+	if err := tx.Create(application).Error; err != nil {
+		return err
 	}
-
-	// Добавление записи в историю статусов
-	statusHistory := StatusHistory{
-		ApplicationID: application.ID,
-		Status:        "draft",
-		Timestamp:     time.Now(),
-		Comment:       "Заявка создана",
+	if err := recordInitialRevisions(tx, application, application.AgentID); err != nil {
+		return err
 	}
-	db.Create(&statusHistory)
-
-	c.JSON(http.StatusCreated, gin.H{
-		"message":     "Заявка успешно создана",
-		"application": application,
-	})
+	return tx.Create(&StatusHistory{
+		ApplicationID: application.ID,
+		Status:        string(statusDraft),
+		ActorID:       application.AgentID,
+		Timestamp:     application.CreatedAt,
+		Comment:       comment,
+	}).Error
 }
 
-// GetApplications возвращает список заявок с фильтрацией
+// GetApplications возвращает список заявок с фильтрацией, сортировкой и
+// пагинацией (параметры описаны в parseApplicationQuery)
 func GetApplications(c *gin.Context) {
-	// Параметры пагинации
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	offset := (page - 1) * limit
+	values, err := applicationListParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	respondApplicationList(c, values)
+}
 
-	// Фильтры
-	status := c.Query("status")
-	dateFrom := c.Query("dateFrom")
-	dateTo := c.Query("dateTo")
+// respondApplicationList отвечает страницей списка заявок по параметрам values
+func respondApplicationList(c *gin.Context, values url.Values) {
+	params, err := parseApplicationQuery(values, currentActor(c).UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Построение запроса
-	query := db.Model(&Application{})
-
-	// Применение фильтров
-	if status != "" && status != "all" {
-		query = query.Where("status = ?", status)
-	}
-	if dateFrom != "" {
-		query = query.Where("created_at >= ?", dateFrom)
-	}
-	if dateTo != "" {
-		query = query.Where("created_at <= ?", dateTo)
-	}
+	query := db.Model(&Application{}).Scopes(scopeApplications(c), params.scope)
 
 	// Подсчет общего количества
 	var total int64
 	query.Count(&total)
 
-	// Получение заявок с пагинацией
+	// Получение заявок
 	var applications []Application
 	if err := query.
+		Scopes(params.paginate).
 		Preload("StatusHistory").
-		Order("created_at DESC").
-		Limit(limit).
-		Offset(offset).
 		Find(&applications).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения заявок"})
 		return
 	}
+	attachSLA(applications)
 
 	response := GetApplicationsResponse{
-		Applications: applications,
+		Applications: presentApplications(c, applications),
 		Total:        total,
-		Page:         page,
-		Limit:        limit,
+		Page:         params.page,
+		Limit:        params.limit,
+		NextCursor:   params.nextCursor(applications),
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -179,19 +272,17 @@ func GetApplication(c *gin.Context) {
 	id := c.Param("id")
 
 	var application Application
-	if err := db.Preload("StatusHistory").First(&application, id).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Заявка не найдена"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения заявки"})
+	if !findScopedApplication(c, id, &application, "StatusHistory") {
 		return
 	}
+	applications := []Application{application}
+	attachSLA(applications)
 
-	c.JSON(http.StatusOK, application)
+	setApplicationETag(c, &application)
+	c.JSON(http.StatusOK, presentApplication(c, applications[0]))
 }
 
-// UpdateApplication обновляет заявку
+// UpdateApplication обновляет шаг анкеты с проверкой версии заявки
 func UpdateApplication(c *gin.Context) {
 	id := c.Param("id")
 
@@ -201,141 +292,137 @@ func UpdateApplication(c *gin.Context) {
 		return
 	}
 
-	// Получение заявки
+	// Получение заявки и проверка версии из If-Match
 	var application Application
-	if err := db.First(&application, id).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Заявка не найдена"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения заявки"})
+	if !findScopedApplication(c, id, &application) {
+		return
+	}
+	if !checkIfMatch(c, &application) || !checkApplicationEditable(c, &application) {
 		return
 	}
 
 	// Валидация данных шага
 	if err := validateStepData(req.Step, req.Data); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
-	// Обновление данных в зависимости от шага
-	switch req.Step {
-	case "personal":
-		application.PersonalData = req.Data
-	case "contact":
-		application.ContactData = req.Data
-	case "professional":
-		application.ProfessionalData = req.Data
-	case "financial":
-		application.FinancialData = req.Data
-	case "family":
-		application.FamilyData = req.Data
-	case "additional":
-		application.AdditionalData = req.Data
-	default:
+	if _, ok := application.stepData(req.Step); !ok {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Неизвестный шаг"})
 		return
 	}
 
-	application.UpdatedAt = time.Now()
+	before := application
 
-	// Сохранение изменений
-	if err := db.Save(&application).Error; err != nil {
+	// Сохранение изменений с новой ревизией шага
+	revision, err := updateStepData(&application, req.Step, req.Data, ApplicationRevision{
+		Source:  revisionUpdate,
+		ActorID: currentActor(c).UserID,
+	})
+	if errors.Is(err, errVersionConflict) {
+		respondVersionConflict(c, &application)
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления заявки"})
 		return
 	}
 
+	recordAudit(c, "application.update", "application", application.ID, before.maskedPII(), application.maskedPII())
+
+	setApplicationETag(c, &application)
 	c.JSON(http.StatusOK, gin.H{
 		"message":     "Заявка успешно обновлена",
-		"application": application,
+		"application": presentApplication(c, application),
+		"revision_id": revision.ID,
 	})
 }
 
+// applicationDataFields поля анкеты вместе с ключом шифрования персональных
+// данных, который задается при первом сохранении
+var applicationDataFields = []string{
+	"PersonalData", "ContactData", "ProfessionalData", "FinancialData", "FamilyData", "AdditionalData",
+	"PIIKeyID", "PIIDataKey", "UpdatedAt",
+}
+
+// stepData возвращает раздел анкеты, который заполняется на шаге
+func (a *Application) stepData(step string) (*json.RawMessage, bool) {
+	switch step {
+	case "personal":
+		return &a.PersonalData, true
+	case "contact":
+		return &a.ContactData, true
+	case "professional":
+		return &a.ProfessionalData, true
+	case "financial":
+		return &a.FinancialData, true
+	case "family":
+		return &a.FamilyData, true
+	case "additional":
+		return &a.AdditionalData, true
+	}
+	return nil, false
+}
+
 // SubmitApplication отправляет заявку на рассмотрение
 func SubmitApplication(c *gin.Context) {
 	id := c.Param("id")
 
+	// Тело запроса необязательно: причина нужна только при найденных дубликатах
+	var req SubmitApplicationRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Получение заявки
 	var application Application
-	if err := db.First(&application, id).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Заявка не найдена"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения заявки"})
+	if !findScopedApplication(c, id, &application) {
 		return
 	}
-
-	// Проверка, что заявка в статусе "draft"
-	if application.Status != "draft" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Заявка уже отправлена или не может быть изменена"})
+	if !checkIfMatch(c, &application) {
 		return
 	}
 
-	// Валидация всех данных
-	if err := validateApplicationData(CreateApplicationRequest{
-		PersonalData:     application.PersonalData,
-		ContactData:      application.ContactData,
-		ProfessionalData: application.ProfessionalData,
-		FinancialData:    application.FinancialData,
-		FamilyData:       application.FamilyData,
-		AdditionalData:   application.AdditionalData,
-	}); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Не все обязательные поля заполнены: " + err.Error()})
+	// Проверка на дубликаты, кроме подтвержденных при создании
+	override, ok := checkDuplicates(c, application, req.DuplicateReason, duplicateStageSubmit)
+	if !ok {
 		return
 	}
 
-	// Обновление статуса
-	application.Status = "submitted"
-	application.UpdatedAt = time.Now()
-
-	if err := db.Save(&application).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления заявки"})
+	// Перевод в статус "submitted" с проверкой заполненности анкеты
+	before := application
+	if !transitionApplication(c, &application, eventSubmit, "Заявка отправлена на рассмотрение") {
 		return
 	}
 
-	// Добавление записи в историю статусов
-	statusHistory := StatusHistory{
-		ApplicationID: application.ID,
-		Status:        "submitted",
-		Timestamp:     time.Now(),
-		Comment:       "Заявка отправлена на рассмотрение",
+	recordAudit(c, "application.submit", "application", application.ID, before.maskedPII(), application.maskedPII())
+	if override != nil {
+		if err := saveDuplicateOverride(db, override, application.ID); err != nil {
+			log.Printf("Ошибка сохранения подтверждения дубликата заявки %d: %v", application.ID, err)
+		}
+		recordAudit(c, "application.duplicate_override", "application", application.ID, nil, override)
 	}
-	db.Create(&statusHistory)
-
-	// TODO: Запуск скоринга и отправка в банки
-	go processApplication(application.ID)
 
+	setApplicationETag(c, &application)
 	c.JSON(http.StatusOK, gin.H{
 		"message":     "Заявка успешно отправлена",
-		"application": application,
+		"application": presentApplication(c, application),
 	})
 }
 
-// validateApplicationData валидирует данные анкеты
-func validateApplicationData(req CreateApplicationRequest) error {
-	// Проверка обязательных полей
-	if len(req.PersonalData) == 0 {
-		return fmt.Errorf("личные данные не заполнены")
-	}
-	if len(req.ContactData) == 0 {
-		return fmt.Errorf("контактные данные не заполнены")
-	}
-	if len(req.ProfessionalData) == 0 {
-		return fmt.Errorf("профессиональные данные не заполнены")
-	}
-	if len(req.FinancialData) == 0 {
-		return fmt.Errorf("финансовые данные не заполнены")
-	}
-	if len(req.FamilyData) == 0 {
-		return fmt.Errorf("семейные данные не заполнены")
-	}
-	if len(req.AdditionalData) == 0 {
-		return fmt.Errorf("дополнительные данные не заполнены")
-	}
-
-	// TODO: Добавить детальную валидацию каждого шага
-	return nil
+// validateApplicationData валидирует данные анкеты по схемам шагов
+// (internal/questionnaire/schema.json). В режиме Partial проверяются только
+// заполненные поля, в режиме Complete - еще и обязательность.
+func validateApplicationData(req CreateApplicationRequest, mode jsonschema.Mode) error {
+	return questionnaire.Validate(map[string]json.RawMessage{
+		"personal":     req.PersonalData,
+		"contact":      req.ContactData,
+		"professional": req.ProfessionalData,
+		"financial":    req.FinancialData,
+		"family":       req.FamilyData,
+		"additional":   req.AdditionalData,
+	}, mode)
 }
 
 // validateStepData валидирует данные конкретного шага
@@ -344,16 +431,30 @@ func validateStepData(step string, data json.RawMessage) error {
 		return fmt.Errorf("данные шага не заполнены")
 	}
 
-	// TODO: Добавить детальную валидацию для каждого шага
-	return nil
+	definition, ok := questionnaire.FindStep(step)
+	if !ok {
+		return fmt.Errorf("Неизвестный шаг")
+	}
+	return questionnaire.ValidateStep(definition, data, jsonschema.Partial)
+}
+
+// respondValidationError отвечает 400 с ошибками по полям анкеты
+func respondValidationError(c *gin.Context, err error) {
+	var validationErr *questionnaire.ValidationError
+	if errors.As(err, &validationErr) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  "Анкета заполнена с ошибками",
+			"fields": validationErr.Errors,
+		})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 }
 
-// processApplication обрабатывает заявку (скоринг, отправка в банки)
-func processApplication(applicationID uint) {
-	// TODO: Реализовать скоринг
-	// TODO: Отправить в банки
-	// TODO: Обновить статус заявки
-	fmt.Printf("Обработка заявки %d\n", applicationID)
+// GetApplicationSchema возвращает схемы шагов анкеты, по которым сервер
+// проверяет данные, чтобы фронтенд применял те же правила
+func GetApplicationSchema(c *gin.Context) {
+	c.JSON(http.StatusOK, questionnaire.Get())
 }
 
 // SetScoringEngine устанавливает движок скоринга
@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"tenderhelp/internal/assignment"
+	"tenderhelp/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Способы назначения менеджера
+const (
+	assignmentManual = "manual"
+	assignmentAuto   = "auto"
+)
+
+// managerLoadStatuses статусы заявок, которые считаются в работе у
+// менеджера: до решения и отправки в банки
+var managerLoadStatuses = []string{string(statusSubmitted), string(statusInReview), string(statusApproved)}
+
+// errNoManagerAvailable нет менеджера с подходящим продуктом и свободным лимитом
+var errNoManagerAvailable = errors.New("нет свободного менеджера для заявки")
+
+// ManagerProfile настройки распределения заявок на менеджера: продукты,
+// которые он ведет (кредиты, банковские гарантии), и лимит заявок в работе
+type ManagerProfile struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	UserID         uint       `json:"user_id" gorm:"uniqueIndex"`
+	Credit         bool       `json:"credit"`        // кредитный менеджер
+	Guarantee      bool       `json:"guarantee"`     // менеджер по банковским гарантиям
+	Capacity       int        `json:"capacity"`      // лимит заявок в работе, 0 - без ограничения
+	SupervisorID   uint       `json:"supervisor_id"` // руководитель, получающий эскалации по срокам
+	Active         bool       `json:"active"`
+	LastAssignedAt *time.Time `json:"last_assigned_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ManagerProfileRequest запрос на настройку менеджера
+type ManagerProfileRequest struct {
+	Credit       bool  `json:"credit"`
+	Guarantee    bool  `json:"guarantee"`
+	Capacity     int   `json:"capacity" binding:"min=0"`
+	SupervisorID uint  `json:"supervisorId"`
+	Active       *bool `json:"active"`
+}
+
+// ApplicationAssignment запись истории назначений менеджера на заявку
+type ApplicationAssignment struct {
+	ID                uint      `json:"id" gorm:"primaryKey"`
+	ApplicationID     uint      `json:"application_id" gorm:"index"`
+	ManagerID         uint      `json:"manager_id"`
+	PreviousManagerID uint      `json:"previous_manager_id"`
+	Method            string    `json:"method"`             // manual, auto
+	Strategy          string    `json:"strategy,omitempty"` // стратегия автоматического назначения
+	Reason            string    `json:"reason,omitempty"`
+	ActorID           uint      `json:"actor_id"` // 0 - система
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// AssignManagerRequest запрос на назначение менеджера
+type AssignManagerRequest struct {
+	ManagerID uint   `json:"managerId" binding:"required"`
+	Reason    string `json:"reason" binding:"max=500"`
+}
+
+// ManagerWorkload менеджер с текущей нагрузкой
+type ManagerWorkload struct {
+	ManagerProfile
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Load  int    `json:"load"`
+}
+
+// assignmentStrategy стратегия автоматического назначения из ASSIGNMENT_STRATEGY
+func assignmentStrategy() assignment.Strategy {
+	strategy, err := assignment.ParseStrategy(os.Getenv("ASSIGNMENT_STRATEGY"))
+	if err != nil {
+		return assignment.LeastLoaded
+	}
+	return strategy
+}
+
+// managesProduct ведет ли менеджер продукт заявки
+func (p ManagerProfile) managesProduct(applicationType string) bool {
+	switch applicationType {
+	case "credit":
+		return p.Credit
+	case "guarantee":
+		return p.Guarantee
+	}
+	return p.Credit || p.Guarantee
+}
+
+// managerLoads количество заявок в работе у менеджеров
+func managerLoads(tx *gorm.DB, managerIDs []uint) (map[uint]int, error) {
+	var rows []struct {
+		ManagerID uint
+		Count     int
+	}
+	if err := tx.Model(&Application{}).
+		Select("manager_id, COUNT(*) AS count").
+		Where("manager_id IN ? AND status IN ?", managerIDs, managerLoadStatuses).
+		Group("manager_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	loads := make(map[uint]int, len(rows))
+	for _, row := range rows {
+		loads[row.ManagerID] = row.Count
+	}
+	return loads, nil
+}
+
+// assignManager назначает менеджера и записывает назначение в историю
+func assignManager(tx *gorm.DB, application *Application, record ApplicationAssignment) error {
+	record.ApplicationID = application.ID
+	record.PreviousManagerID = application.ManagerID
+	record.CreatedAt = time.Now()
+
+	result := tx.Model(&Application{}).
+		Where("id = ? AND manager_id = ?", application.ID, application.ManagerID).
+		UpdateColumn("manager_id", record.ManagerID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("менеджер заявки изменился, обновите данные")
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&ManagerProfile{}).Where("user_id = ?", record.ManagerID).
+		Update("last_assigned_at", record.CreatedAt).Error; err != nil {
+		return err
+	}
+
+	application.ManagerID = record.ManagerID
+	return nil
+}
+
+// autoAssignManager назначает заявке менеджера по стратегии распределения
+// среди активных менеджеров ее продукта со свободным лимитом
+func autoAssignManager(application *Application) error {
+	strategy := assignmentStrategy()
+	return db.Transaction(func(tx *gorm.DB) error {
+		var profiles []ManagerProfile
+		if err := tx.Where("active = ?", true).Find(&profiles).Error; err != nil {
+			return err
+		}
+
+		var ids []uint
+		for _, profile := range profiles {
+			if profile.managesProduct(application.Type) {
+				ids = append(ids, profile.UserID)
+			}
+		}
+		loads, err := managerLoads(tx, ids)
+		if err != nil {
+			return err
+		}
+
+		var candidates []assignment.Candidate
+		for _, profile := range profiles {
+			if !profile.managesProduct(application.Type) {
+				continue
+			}
+			candidate := assignment.Candidate{ID: profile.UserID, Load: loads[profile.UserID], Capacity: profile.Capacity}
+			if profile.LastAssignedAt != nil {
+				candidate.LastAssignedAt = *profile.LastAssignedAt
+			}
+			candidates = append(candidates, candidate)
+		}
+
+		managerID, ok := assignment.Pick(strategy, candidates)
+		if !ok {
+			return errNoManagerAvailable
+		}
+		return assignManager(tx, application, ApplicationAssignment{
+			ManagerID: managerID,
+			Method:    assignmentAuto,
+			Strategy:  string(strategy),
+		})
+	})
+}
+
+// GetManagers возвращает менеджеров с настройками распределения и нагрузкой
+func GetManagers(c *gin.Context) {
+	var profiles []ManagerProfile
+	if err := db.Order("user_id").Find(&profiles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения менеджеров"})
+		return
+	}
+
+	ids := make([]uint, len(profiles))
+	for i, profile := range profiles {
+		ids[i] = profile.UserID
+	}
+	loads, err := managerLoads(db, ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения нагрузки менеджеров"})
+		return
+	}
+	var users []models.User
+	db.Where("id IN ?", ids).Find(&users)
+	byID := make(map[uint]models.User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+
+	managers := make([]ManagerWorkload, len(profiles))
+	for i, profile := range profiles {
+		user := byID[profile.UserID]
+		managers[i] = ManagerWorkload{ManagerProfile: profile, Name: user.Name, Email: user.Email, Load: loads[profile.UserID]}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"managers": managers, "strategy": assignmentStrategy()})
+}
+
+// UpdateManagerProfile создает или изменяет настройки распределения менеджера.
+// Менеджером может быть пользователь с доступом ко всем заявкам.
+func UpdateManagerProfile(c *gin.Context) {
+	var req ManagerProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, c.Param("userId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+	if !rolePermissions.hasPermission(user.Role, "view_all_applications") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Роль пользователя не дает доступа ко всем заявкам"})
+		return
+	}
+
+	if req.SupervisorID != 0 {
+		var supervisor models.User
+		if req.SupervisorID == user.ID || db.First(&supervisor, req.SupervisorID).Error != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Руководитель не найден"})
+			return
+		}
+	}
+
+	var profile ManagerProfile
+	err := db.Where("user_id = ?", user.ID).First(&profile).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения менеджера"})
+		return
+	}
+	before := profile
+	profile.UserID = user.ID
+	profile.Credit = req.Credit
+	profile.Guarantee = req.Guarantee
+	profile.Capacity = req.Capacity
+	profile.SupervisorID = req.SupervisorID
+	if req.Active != nil {
+		profile.Active = *req.Active
+	} else if profile.ID == 0 {
+		profile.Active = true
+	}
+
+	if err := db.Save(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения менеджера"})
+		return
+	}
+
+	if before.ID == 0 {
+		recordAudit(c, "manager.create", "manager", user.ID, nil, profile)
+	} else {
+		recordAudit(c, "manager.update", "manager", user.ID, before, profile)
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// AssignApplicationManager назначает или меняет ответственного менеджера заявки
+func AssignApplicationManager(c *gin.Context) {
+	var req AssignManagerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+
+	var profile ManagerProfile
+	if err := db.Where("user_id = ? AND active = ?", req.ManagerID, true).First(&profile).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Менеджер не найден или не активен"})
+		return
+	}
+	if !profile.managesProduct(application.Type) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Менеджер не ведет продукт заявки"})
+		return
+	}
+	if application.ManagerID == req.ManagerID {
+		c.JSON(http.StatusOK, gin.H{"message": "Менеджер уже назначен", "application": presentApplication(c, application)})
+		return
+	}
+
+	// Ручное назначение не ограничено лимитом менеджера
+	before := application.ManagerID
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return assignManager(tx, &application, ApplicationAssignment{
+			ManagerID: req.ManagerID,
+			Method:    assignmentManual,
+			Reason:    req.Reason,
+			ActorID:   currentActor(c).UserID,
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка назначения менеджера"})
+		return
+	}
+
+	recordAudit(c, "application.assign_manager", "application", application.ID,
+		gin.H{"manager_id": before}, gin.H{"manager_id": application.ManagerID, "reason": req.Reason})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Менеджер назначен", "application": presentApplication(c, application)})
+}
+
+// AutoAssignApplicationManager назначает менеджера по стратегии распределения,
+// например если при отправке заявки свободных менеджеров не было
+func AutoAssignApplicationManager(c *gin.Context) {
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+	if application.ManagerID != 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Менеджер уже назначен"})
+		return
+	}
+
+	if err := autoAssignManager(&application); err != nil {
+		if errors.Is(err, errNoManagerAvailable) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка назначения менеджера"})
+		return
+	}
+
+	recordAudit(c, "application.assign_manager", "application", application.ID,
+		gin.H{"manager_id": 0}, gin.H{"manager_id": application.ManagerID})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Менеджер назначен", "application": presentApplication(c, application)})
+}
+
+// GetApplicationAssignments возвращает историю назначений менеджеров заявки
+func GetApplicationAssignments(c *gin.Context) {
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+
+	var assignments []ApplicationAssignment
+	if err := db.Where("application_id = ?", application.ID).Order("created_at, id").Find(&assignments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения истории назначений"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"manager_id": application.ManagerID, "assignments": assignments})
+}
+
+// GetManagerQueue возвращает заявки менеджера ("me" - текущего пользователя)
+// с фильтрами и пагинацией списка заявок. Очередь другого менеджера доступна
+// с правом assign_managers.
+func GetManagerQueue(c *gin.Context) {
+	a := currentActor(c)
+	managerID := a.UserID
+	if param := c.Param("userId"); param != "me" {
+		id, err := strconv.ParseUint(param, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID менеджера"})
+			return
+		}
+		managerID = uint(id)
+	}
+	if managerID != a.UserID && !a.can("assign_managers") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
+		return
+	}
+
+	values, err := applicationListParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	values.Set("managerId", strconv.FormatUint(uint64(managerID), 10))
+	respondApplicationList(c, values)
+}
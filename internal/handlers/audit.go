@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"tenderhelp/internal/audit"
+	"tenderhelp/internal/auth"
+	"tenderhelp/internal/jsondiff"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AuditEntry запись журнала аудита. Записи связаны в цепочку: каждая
+// содержит хеш предыдущей, поэтому изменение или удаление записи
+// обнаруживается проверкой журнала.
+type AuditEntry struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	Sequence   uint64    `json:"sequence" gorm:"uniqueIndex"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+	ActorID    uint      `json:"actor_id" gorm:"index"`
+	ActorRole  string    `json:"actor_role"`
+	APIKeyID   uint      `json:"api_key_id,omitempty"`
+	Action     string    `json:"action" gorm:"index"`
+	EntityType string    `json:"entity_type" gorm:"index:idx_audit_entity"`
+	EntityID   string    `json:"entity_id" gorm:"index:idx_audit_entity"`
+	Changes    string    `json:"changes" gorm:"type:text"` // JSON-массив изменений; хешируется как есть, поэтому не jsonb
+	IP         string    `json:"ip"`
+	RequestID  string    `json:"request_id" gorm:"index"`
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+}
+
+// MarshalJSON отдает изменения как JSON, а не строкой
+func (e AuditEntry) MarshalJSON() ([]byte, error) {
+	type entry AuditEntry
+	changes := json.RawMessage(e.Changes)
+	if !json.Valid(changes) {
+		changes, _ = json.Marshal(e.Changes)
+	}
+	return json.Marshal(struct {
+		entry
+		Changes json.RawMessage `json:"changes"`
+	}{entry(e), changes})
+}
+
+// record возвращает содержимое записи, покрываемое хешем
+func (e AuditEntry) record() audit.Record {
+	return audit.Record{
+		Sequence:   e.Sequence,
+		PrevHash:   e.PrevHash,
+		Time:       e.CreatedAt,
+		ActorID:    e.ActorID,
+		ActorRole:  e.ActorRole,
+		APIKeyID:   e.APIKeyID,
+		Action:     e.Action,
+		EntityType: e.EntityType,
+		EntityID:   e.EntityID,
+		Changes:    e.Changes,
+		IP:         e.IP,
+		RequestID:  e.RequestID,
+	}
+}
+
+// requestIDHeader заголовок с идентификатором запроса
+const requestIDHeader = "X-Request-ID"
+
+// requestIDPattern допустимый идентификатор, переданный клиентом или прокси
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// auditIgnoredFields поля, изменение которых не попадает в diff
+var auditIgnoredFields = []string{"updated_at"}
+
+// auditWriteAttempts число попыток записи при одновременной записи
+// с другого экземпляра сервиса (конфликт номера записи)
+const auditWriteAttempts = 3
+
+var (
+	auditChain = audit.ChainFromEnv()
+	// auditMu упорядочивает запись в цепочку внутри процесса
+	auditMu sync.Mutex
+)
+
+// RequestID присваивает запросу идентификатор (или принимает переданный
+// в X-Request-ID) и возвращает его в ответе
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			generated, err := auth.RandomToken(16)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Ошибка генерации идентификатора запроса"})
+				return
+			}
+			requestID = generated
+		}
+
+		c.Set("request_id", requestID)
+		c.Header(requestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// recordAudit записывает действие пользователя в журнал аудита. before и
+// after - состояние сущности до и после изменения (nil при создании и
+// удалении); в журнал сохраняется только diff. Изменение без отличий не
+// записывается. Ошибка записи не прерывает запрос, но попадает в лог.
+func recordAudit(c *gin.Context, action, entityType string, entityID interface{}, before, after interface{}) {
+	changes, err := jsondiff.Diff(before, after, auditIgnoredFields...)
+	if err != nil {
+		log.Printf("Ошибка аудита %s: %v", action, err)
+		return
+	}
+	if before != nil && after != nil && len(changes) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		log.Printf("Ошибка аудита %s: %v", action, err)
+		return
+	}
+
+	a := currentActor(c)
+	entry := AuditEntry{
+		ActorID:    a.UserID,
+		ActorRole:  a.Role,
+		APIKeyID:   c.GetUint("api_key_id"),
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   fmt.Sprint(entityID),
+		Changes:    string(data),
+		IP:         c.ClientIP(),
+		RequestID:  c.GetString("request_id"),
+	}
+
+	if err := appendAuditEntry(&entry); err != nil {
+		log.Printf("Ошибка записи аудита %s %s/%s: %v", action, entityType, entry.EntityID, err)
+	}
+}
+
+// appendAuditEntry добавляет запись в конец цепочки
+func appendAuditEntry(entry *AuditEntry) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < auditWriteAttempts; attempt++ {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var last AuditEntry
+			if err := tx.Order("sequence DESC").Limit(1).Find(&last).Error; err != nil {
+				return err
+			}
+
+			entry.ID = 0
+			entry.Sequence = last.Sequence + 1
+			entry.PrevHash = last.Hash
+			entry.CreatedAt = audit.NormalizeTime(time.Now())
+			entry.Hash = auditChain.Hash(entry.record())
+			return tx.Create(entry).Error
+		})
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// auditQuery применяет фильтры запроса к журналу аудита
+func auditQuery(c *gin.Context) (*gorm.DB, error) {
+	query := db.Model(&AuditEntry{})
+	for param, column := range map[string]string{
+		"actor_id":    "actor_id",
+		"action":      "action",
+		"entity_type": "entity_type",
+		"entity_id":   "entity_id",
+		"request_id":  "request_id",
+		"ip":          "ip",
+	} {
+		if value := c.Query(param); value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := parseAuditTime(from)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseAuditTime(to)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("created_at < ?", t)
+	}
+	return query, nil
+}
+
+// parseAuditTime разбирает время в формате RFC 3339 или дату YYYY-MM-DD
+func parseAuditTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New("Некорректная дата: " + value)
+}
+
+// GetAuditLog возвращает записи журнала аудита с фильтрацией
+func GetAuditLog(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	query, err := auditQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var entries []AuditEntry
+	if err := query.Order("sequence DESC").Limit(limit).Offset((page - 1) * limit).Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения журнала аудита"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+	})
+}
+
+// ExportAuditLog выгружает журнал аудита в CSV или JSON Lines (format=jsonl).
+// Записи выгружаются вместе с хешами, чтобы цепочку можно было проверить
+// вне системы.
+func ExportAuditLog(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "jsonl" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Поддерживаются форматы csv и jsonl"})
+		return
+	}
+
+	query, err := auditQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Сама выгрузка тоже фиксируется в журнале
+	recordAudit(c, "audit.export", "audit", "", nil, gin.H{"format": format, "filters": c.Request.URL.RawQuery})
+
+	filename := fmt.Sprintf("audit_%s.%s", time.Now().Format("20060102_150405"), format)
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+
+	var writeBatch func([]AuditEntry) error
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"sequence", "created_at", "actor_id", "actor_role", "api_key_id", "action",
+			"entity_type", "entity_id", "changes", "ip", "request_id", "prev_hash", "hash"})
+		writeBatch = func(entries []AuditEntry) error {
+			for _, e := range entries {
+				w.Write([]string{
+					strconv.FormatUint(e.Sequence, 10),
+					e.CreatedAt.UTC().Format(time.RFC3339Nano),
+					strconv.FormatUint(uint64(e.ActorID), 10),
+					e.ActorRole,
+					strconv.FormatUint(uint64(e.APIKeyID), 10),
+					e.Action,
+					e.EntityType,
+					e.EntityID,
+					e.Changes,
+					e.IP,
+					e.RequestID,
+					e.PrevHash,
+					e.Hash,
+				})
+			}
+			w.Flush()
+			return w.Error()
+		}
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(c.Writer)
+		writeBatch = func(entries []AuditEntry) error {
+			for _, e := range entries {
+				if err := encoder.Encode(e); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	var batch []AuditEntry
+	result := query.Order("sequence ASC").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		return writeBatch(batch)
+	})
+	if result.Error != nil {
+		// Заголовки уже отправлены, поэтому ошибка только логируется
+		log.Printf("Ошибка выгрузки журнала аудита: %v", result.Error)
+	}
+}
+
+// VerifyAuditLog проверяет целостность цепочки журнала аудита
+func VerifyAuditLog(c *gin.Context) {
+	verifier := auditChain.NewVerifier()
+
+	var batch []AuditEntry
+	var broken error
+	result := db.Order("sequence ASC").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for _, e := range batch {
+			if err := verifier.Next(e.record(), e.Hash); err != nil {
+				broken = err
+				return err
+			}
+		}
+		return nil
+	})
+	if result.Error != nil && broken == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки журнала аудита"})
+		return
+	}
+
+	response := gin.H{
+		"valid":     broken == nil,
+		"checked":   verifier.Checked(),
+		"last_hash": verifier.LastHash(),
+	}
+	if broken != nil {
+		response["error"] = broken.Error()
+		recordSecurityEvent(SecurityEvent{
+			Type:    securityEventAuditBroken,
+			ActorID: currentActor(c).UserID,
+			IP:      c.ClientIP(),
+			Details: broken.Error(),
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -1,11 +1,11 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"encoding/hex"
 	"net/http"
 	"strings"
+	"tenderhelp/internal/auth"
 	"tenderhelp/internal/models"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -17,16 +17,21 @@ type loginRequest struct {
 }
 
 type loginResponse struct {
-	Token string      `json:"token"`
-	User  models.User `json:"user"`
+	tokenPair
+	User models.User `json:"user"`
+}
+
+type profileResponse struct {
+	models.User
+	Sessions []Session `json:"sessions"`
 }
 
 type registerRequest struct {
 	Name     string `json:"name" binding:"required"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
 	Phone    string `json:"phone"`
-	Role     string `json:"role" binding:"required,oneof=admin director partner-bank user client"`
+	Role     string `json:"role" binding:"required"`
 }
 
 // Login handles user authentication
@@ -37,9 +42,15 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	// Temporary lockout after repeated failures (per account and per IP)
+	if !checkLoginThrottle(c, req.Email) {
+		return
+	}
+
 	// Find user by email
 	var user models.User
 	if err := db.Where("email = ?", strings.ToLower(req.Email)).First(&user).Error; err != nil {
+		registerLoginFailure(c, req.Email)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверные учетные данные"})
 		return
 	}
@@ -52,17 +63,25 @@ func Login(c *gin.Context) {
 
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		registerLoginFailure(c, req.Email)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверные учетные данные"})
 		return
 	}
 
-	// Generate JWT token (simplified - in production use proper JWT library)
-	token := generateToken(user.ID, user.Role)
+	// Users with a forced password change get a token that only allows
+	// changing the password, not a full session
+	if mustChangePassword(user.ID) {
+		response, err := startPasswordChange(&user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания токена"})
+			return
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
 
-	c.JSON(http.StatusOK, loginResponse{
-		Token: token,
-		User:  user,
-	})
+	// Second factor or a device session with signed tokens
+	completeLogin(c, &user, http.StatusOK)
 }
 
 // Register handles user registration
@@ -73,6 +92,20 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	// Roles are configured in the database
+	if !rolePermissions.roleExists(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неизвестная роль"})
+		return
+	}
+
+	// Public sign-up is limited to self-service roles; any other role
+	// is assigned by a user with the manage_users permission
+	createdByStaff := currentHasPermission(c, "manage_users")
+	if !createdByStaff && !rolePermissions.selfRegister(req.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Регистрация с этой ролью недоступна"})
+		return
+	}
+
 	// Check if user already exists
 	var existingUser models.User
 	if err := db.Where("email = ?", strings.ToLower(req.Email)).First(&existingUser).Error; err == nil {
@@ -80,8 +113,14 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	// Enforce password policy
+	if err := auth.ValidatePassword(req.Password, req.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := auth.HashPassword(req.Password)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания пользователя"})
 		return
@@ -90,7 +129,7 @@ func Register(c *gin.Context) {
 	// Create user
 	user := models.User{
 		Email:    strings.ToLower(req.Email),
-		Password: string(hashedPassword),
+		Password: hashedPassword,
 		Name:     req.Name,
 		Phone:    req.Phone,
 		Role:     req.Role,
@@ -102,18 +141,31 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	// Generate token
-	token := generateToken(user.ID, user.Role)
+	// Staff registering someone else does not sign in as that user
+	if createdByStaff {
+		recordAudit(c, "user.create", "user", user.ID, nil, gin.H{"email": user.Email, "role": user.Role})
+		c.JSON(http.StatusCreated, gin.H{"message": "Пользователь создан", "user": user})
+		return
+	}
 
-	c.JSON(http.StatusCreated, loginResponse{
-		Token: token,
-		User:  user,
-	})
+	// Start session (roles with mandatory 2FA enrol first)
+	completeLogin(c, &user, http.StatusCreated)
 }
 
-// Logout handles user logout
+// Logout revokes the current session, so neither its access token
+// nor its refresh token can be used again
 func Logout(c *gin.Context) {
-	// In a real implementation, you would invalidate the token
+	claims, exists := currentClaims(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Не авторизован"})
+		return
+	}
+
+	if err := revokeSession(claims.SessionID, revokeReasonLogout); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения сессии"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Выход выполнен успешно"})
 }
 
@@ -131,20 +183,95 @@ func GetProfile(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	// Active sessions per device
+	sessions, err := activeSessions(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сессий"})
+		return
+	}
+	if claims, ok := currentClaims(c); ok {
+		markCurrentSession(sessions, claims.SessionID)
+	}
+
+	c.JSON(http.StatusOK, profileResponse{
+		User:     user,
+		Sessions: sessions,
+	})
+}
+
+// tokenManager signs and verifies access tokens
+var tokenManager *auth.TokenManager
+
+// SetTokenManager sets the access token manager
+func SetTokenManager(manager *auth.TokenManager) {
+	tokenManager = manager
+}
+
+// userPermissions returns the permissions granted to the user's role
+func userPermissions(user *models.User) []string {
+	return rolePermissions.permissions(user.Role)
+}
+
+// generateToken issues a signed access token bound to the session,
+// carrying the user's ID, role and permissions
+func generateToken(user *models.User, sessionID uint) (string, time.Time, error) {
+	return tokenManager.Generate(auth.Claims{
+		UserID:      user.ID,
+		SessionID:   sessionID,
+		Role:        user.Role,
+		Permissions: userPermissions(user),
+	})
 }
 
-// generateToken creates a simple token (in production use proper JWT)
-func generateToken(userID uint, role string) string {
-	// Generate random token
-	bytes := make([]byte, 32)
-	rand.Read(bytes)
-	return hex.EncodeToString(bytes)
+// currentClaims returns the token claims placed into the context by RequireAuth
+func currentClaims(c *gin.Context) (*auth.Claims, bool) {
+	value, exists := c.Get("claims")
+	if !exists {
+		return nil, false
+	}
+	claims, ok := value.(*auth.Claims)
+	return claims, ok
 }
 
 // RequireAuth middleware checks if user is authenticated
 func RequireAuth() gin.HandlerFunc {
+	return requireAuth("")
+}
+
+// OptionalAuth middleware authenticates the request when credentials are
+// present and lets anonymous requests through (e.g. public registration)
+func OptionalAuth() gin.HandlerFunc {
+	authenticate := requireAuth("")
 	return func(c *gin.Context) {
+		if _, ok := extractAPIKey(c); !ok && c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
+		}
+		authenticate(c)
+	}
+}
+
+// RequireAuthFor middleware accepts either a regular access token or
+// a short-lived token issued for the given purpose (e.g. password change)
+func RequireAuthFor(purpose string) gin.HandlerFunc {
+	return requireAuth(purpose)
+}
+
+// requireAuth verifies the token; purpose tokens are accepted only
+// when the route allows them explicitly
+func requireAuth(allowedPurpose string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Machine access for partner banks
+		if key, ok := extractAPIKey(c); ok {
+			if !authenticateAPIKey(c, key) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Недействительный ключ API"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
 		token := c.GetHeader("Authorization")
 		if token == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Токен не предоставлен"})
@@ -157,35 +284,48 @@ func RequireAuth() gin.HandlerFunc {
 			token = token[7:]
 		}
 
-		// In a real implementation, you would validate the JWT token
-		// For now, we'll use a simple approach
-		userID := uint(1)   // This should be extracted from token
-		userRole := "admin" // This should be extracted from token
+		// Verify signature and expiry
+		claims, err := tokenManager.Parse(token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Недействительный токен"})
+			c.Abort()
+			return
+		}
+
+		if claims.Purpose != "" && claims.Purpose != allowedPurpose {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Недействительный токен"})
+			c.Abort()
+			return
+		}
+
+		// Revoked sessions invalidate their access tokens immediately.
+		// Purpose tokens are not bound to a session.
+		if claims.Purpose == "" && !isSessionActive(claims.SessionID) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Сессия завершена"})
+			c.Abort()
+			return
+		}
 
-		c.Set("user_id", userID)
-		c.Set("user_role", userRole)
+		c.Set("user_id", claims.UserID)
+		c.Set("user_role", claims.Role)
+		c.Set("permissions", claims.Permissions)
+		c.Set("claims", claims)
 		c.Next()
 	}
 }
 
-// RequirePermission middleware checks if user has required permission
+// RequirePermission middleware checks if user's role has required permission.
+// Role permissions are read from the database through rolePermissions cache,
+// so changes made in the admin endpoints apply without reissuing tokens.
 func RequirePermission(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID, exists := c.Get("user_id")
-		if !exists {
+		if _, exists := currentClaims(c); !exists {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Не авторизован"})
 			c.Abort()
 			return
 		}
 
-		var user models.User
-		if err := db.First(&user, userID).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
-			c.Abort()
-			return
-		}
-
-		if !user.HasPermission(permission) {
+		if !currentHasPermission(c, permission) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Недостаточно прав"})
 			c.Abort()
 			return
@@ -195,6 +335,19 @@ func RequirePermission(permission string) gin.HandlerFunc {
 	}
 }
 
+// currentHasPermission checks the permission of the authenticated caller.
+// API keys are limited to the permissions of their scopes.
+func currentHasPermission(c *gin.Context, permission string) bool {
+	claims, exists := currentClaims(c)
+	if !exists {
+		return false
+	}
+	if isAPIKeyRequest(c) {
+		return claims.HasPermission(permission)
+	}
+	return rolePermissions.hasPermission(claims.Role, permission)
+}
+
 // RequireRole middleware checks if user has required role
 func RequireRole(roles ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -5,8 +5,10 @@ import (
 	"strconv"
 	"tenderhelp/internal/database"
 	"tenderhelp/internal/models"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func GetClients(c *gin.Context) {
@@ -16,7 +18,7 @@ func GetClients(c *gin.Context) {
 	// Получаем параметры поиска
 	search := c.Query("search")
 
-	query := db
+	query := db.Scopes(scopeClients(c))
 
 	if search != "" {
 		query = query.Where("name LIKE ? OR inn LIKE ? OR email LIKE ?",
@@ -40,26 +42,57 @@ func CreateClient(c *gin.Context) {
 		return
 	}
 
-	db.Create(&client)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&client).Error; err != nil {
+			return err
+		}
+		// Создатель клиента получает к нему доступ
+		return grantClientAccess(tx, client.ID, currentActor(c).UserID, "agent")
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания клиента"})
+		return
+	}
+
+	recordAudit(c, "client.create", "client", client.ID, nil, client)
 	c.JSON(http.StatusCreated, client)
 }
 
+// clientUpdateFields поля клиента, которые меняются при обновлении
+var clientUpdateFields = []string{
+	"Name", "INN", "OGRN", "Phone", "Email", "City", "Address",
+	"BankName", "AccountNumber", "BIK", "CorrAccount", "CreditManager", "BGManager",
+	"RegistrationDate", "UpdatedAt",
+}
+
 func UpdateClient(c *gin.Context) {
 	db := database.InitDB()
 	id, _ := strconv.Atoi(c.Param("id"))
 
 	var client models.Client
-	if err := db.First(&client, id).Error; err != nil {
+	if err := db.Scopes(scopeClients(c)).First(&client, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Клиент не найден"})
 		return
 	}
 
+	before := client
 	if err := c.ShouldBindJSON(&client); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	db.Save(&client)
+	// Идентификатор и служебные поля из тела запроса не принимаются, иначе
+	// можно перезаписать клиента, недоступного пользователю
+	client.ID = before.ID
+	client.CreatedAt = before.CreatedAt
+	client.DeletedAt = before.DeletedAt
+	client.UpdatedAt = time.Now()
+	if err := db.Model(&client).Select(clientUpdateFields).Updates(&client).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления клиента"})
+		return
+	}
+
+	recordAudit(c, "client.update", "client", client.ID, before, client)
 	c.JSON(http.StatusOK, client)
 }
 
@@ -67,6 +100,18 @@ func DeleteClient(c *gin.Context) {
 	db := database.InitDB()
 	id, _ := strconv.Atoi(c.Param("id"))
 
-	db.Delete(&models.Client{}, id)
+	var client models.Client
+	if err := db.Scopes(scopeClients(c)).First(&client, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Клиент не найден"})
+		return
+	}
+
+	if err := db.Delete(&client).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка удаления клиента"})
+		return
+	}
+
+	db.Where("client_id = ?", id).Delete(&ClientAccess{})
+	recordAudit(c, "client.delete", "client", client.ID, client, nil)
 	c.JSON(http.StatusOK, gin.H{"message": "Клиент удален"})
 }
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxLineageDepth ограничение глубины цепочки копий при построении родословной
+const maxLineageDepth = 100
+
+// CloneApplicationRequest запрос на копирование заявки. Незаданные тип и
+// сумма берутся из исходной заявки.
+type CloneApplicationRequest struct {
+	Type            string   `json:"type"`
+	Amount          *float64 `json:"amount"`
+	Bank            string   `json:"bank"`
+	IncludeFiles    bool     `json:"includeFiles"`    // ссылки на загруженные файлы
+	IncludeProducts bool     `json:"includeProducts"` // данные ПОС-кредита и гарантии
+	DuplicateReason string   `json:"duplicateReason"` // причина подачи при найденных дубликатах
+}
+
+// LineageNode заявка в родословной копий. Для заявок, недоступных
+// пользователю, возвращаются только идентификаторы.
+type LineageNode struct {
+	ID                  uint       `json:"id"`
+	SourceApplicationID *uint      `json:"source_application_id"`
+	Type                string     `json:"type,omitempty"`
+	Amount              float64    `json:"amount,omitempty"`
+	Status              string     `json:"status,omitempty"`
+	Bank                string     `json:"bank,omitempty"`
+	CreatedAt           *time.Time `json:"created_at,omitempty"`
+	Hidden              bool       `json:"hidden,omitempty"`
+}
+
+// newClone собирает черновик-копию заявки с учетом измененных в запросе полей
+func newClone(source *Application, req CloneApplicationRequest, agentID uint) Application {
+	clone := newDraft(CreateApplicationRequest{
+		Type:             source.Type,
+		Amount:           source.Amount,
+		PersonalData:     source.PersonalData,
+		ContactData:      source.ContactData,
+		ProfessionalData: source.ProfessionalData,
+		FinancialData:    source.FinancialData,
+		FamilyData:       source.FamilyData,
+		AdditionalData:   source.AdditionalData,
+		Tender:           source.TenderDetails,
+	}, source.ClientID, agentID)
+	clone.SourceApplicationID = &source.ID
+	clone.Bank = req.Bank
+	if req.Type != "" {
+		clone.Type = req.Type
+	}
+	if req.Amount != nil {
+		clone.Amount = *req.Amount
+	}
+	return clone
+}
+
+// cloneApplication создает черновик-копию заявки с анкетой и, по запросу,
+// файлами и данными продуктов
+func cloneApplication(tx *gorm.DB, source *Application, req CloneApplicationRequest, agentID uint) (Application, int, error) {
+	clone := newClone(source, req, agentID)
+	if err := createDraft(tx, &clone, fmt.Sprintf("Копия заявки #%d", source.ID)); err != nil {
+		return clone, 0, err
+	}
+
+	files := 0
+	if req.IncludeFiles {
+		// Копируются записи о файлах: физический файл общий для обеих заявок
+		var sourceFiles []File
+		if err := tx.Where("application_id = ? AND is_deleted = ?", source.ID, false).Find(&sourceFiles).Error; err != nil {
+			return clone, 0, err
+		}
+		for _, file := range sourceFiles {
+			file.ID = 0
+			file.ApplicationID = clone.ID
+			if err := tx.Create(&file).Error; err != nil {
+				return clone, 0, err
+			}
+		}
+		files = len(sourceFiles)
+	}
+
+	if req.IncludeProducts {
+		var pos []POSApplication
+		if err := tx.Where("application_id = ?", source.ID).Find(&pos).Error; err != nil {
+			return clone, 0, err
+		}
+		for _, record := range pos {
+			record.ID, record.ApplicationID = 0, clone.ID
+			record.CreatedAt, record.UpdatedAt = time.Time{}, time.Time{}
+			if err := tx.Create(&record).Error; err != nil {
+				return clone, 0, err
+			}
+		}
+
+		var guarantees []GuaranteeApplication
+		if err := tx.Where("application_id = ?", source.ID).Find(&guarantees).Error; err != nil {
+			return clone, 0, err
+		}
+		for _, record := range guarantees {
+			record.ID, record.ApplicationID = 0, clone.ID
+			record.CreatedAt, record.UpdatedAt = time.Time{}, time.Time{}
+			if err := tx.Create(&record).Error; err != nil {
+				return clone, 0, err
+			}
+		}
+	}
+
+	return clone, files, nil
+}
+
+// CloneApplication создает новый черновик на основе заявки, например для
+// повторной подачи в другой банк или на другой продукт после отказа
+func CloneApplication(c *gin.Context) {
+	var req CloneApplicationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var source Application
+	if !findScopedApplication(c, c.Param("id"), &source) {
+		return
+	}
+
+	agentID := currentActor(c).UserID
+	override, ok := checkDuplicates(c, newClone(&source, req, agentID), req.DuplicateReason, duplicateStageCreate)
+	if !ok {
+		return
+	}
+
+	var clone Application
+	var files int
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		clone, files, err = cloneApplication(tx, &source, req, agentID)
+		if err != nil || override == nil {
+			return err
+		}
+		return saveDuplicateOverride(tx, override, clone.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка копирования заявки"})
+		return
+	}
+
+	recordAudit(c, "application.clone", "application", clone.ID, nil, clone.maskedPII())
+	if override != nil {
+		recordAudit(c, "application.duplicate_override", "application", clone.ID, nil, override)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "Копия заявки создана",
+		"application":  presentApplication(c, clone),
+		"files_copied": files,
+	})
+}
+
+// GetApplicationLineage возвращает родословную заявки: цепочку исходных
+// заявок до первой и все копии, сделанные от любой заявки цепочки
+func GetApplicationLineage(c *gin.Context) {
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+
+	// Подъем к первой заявке цепочки
+	rootID := application.ID
+	source := application.SourceApplicationID
+	for depth := 0; source != nil && depth < maxLineageDepth; depth++ {
+		var parent Application
+		if err := db.Select("id", "source_application_id").First(&parent, *source).Error; err != nil {
+			break
+		}
+		rootID, source = parent.ID, parent.SourceApplicationID
+	}
+
+	// Обход копий в ширину от первой заявки
+	ids := []uint{rootID}
+	level := []uint{rootID}
+	for depth := 0; len(level) > 0 && depth < maxLineageDepth; depth++ {
+		var children []uint
+		if err := db.Model(&Application{}).Where("source_application_id IN ?", level).Pluck("id", &children).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения копий заявки"})
+			return
+		}
+		ids = append(ids, children...)
+		level = children
+	}
+
+	var all, visible []Application
+	if err := db.Select("id", "source_application_id").Where("id IN ?", ids).Order("id").Find(&all).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения копий заявки"})
+		return
+	}
+	db.Scopes(scopeApplications(c)).
+		Select("id", "source_application_id", "type", "amount", "status", "bank", "created_at").
+		Where("id IN ?", ids).Find(&visible)
+	details := make(map[uint]Application, len(visible))
+	for _, item := range visible {
+		details[item.ID] = item
+	}
+
+	nodes := make([]LineageNode, len(all))
+	for i, item := range all {
+		node := LineageNode{ID: item.ID, SourceApplicationID: item.SourceApplicationID, Hidden: true}
+		if detail, ok := details[item.ID]; ok {
+			createdAt := detail.CreatedAt
+			node.Type, node.Amount, node.Status, node.Bank = detail.Type, detail.Amount, detail.Status, detail.Bank
+			node.CreatedAt, node.Hidden = &createdAt, false
+		}
+		nodes[i] = node
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"application_id": application.ID,
+		"root_id":        rootID,
+		"applications":   nodes,
+	})
+}
@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"tenderhelp/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Видимость комментария
+const (
+	commentInternal = "internal" // только сотрудникам брокера
+	commentClient   = "client"   // также клиенту и банку
+)
+
+// ApplicationComment комментарий к заявке. Ответ ссылается на комментарий
+// через ParentID; дерево обсуждения строится на клиенте.
+type ApplicationComment struct {
+	ID            uint             `json:"id" gorm:"primaryKey"`
+	ApplicationID uint             `json:"application_id" gorm:"index"`
+	ParentID      *uint            `json:"parent_id" gorm:"index"`
+	AuthorID      uint             `json:"author_id"`
+	Body          string           `json:"body"`
+	Visibility    string           `json:"visibility"`
+	EditedAt      *time.Time       `json:"edited_at"`
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+	Mentions      []CommentMention `json:"mentions" gorm:"foreignKey:CommentID"`
+	Files         []File           `json:"files" gorm:"many2many:comment_files"`
+}
+
+// CommentMention упоминание пользователя в комментарии
+type CommentMention struct {
+	ID        uint   `json:"-" gorm:"primaryKey"`
+	CommentID uint   `json:"-" gorm:"uniqueIndex:idx_comment_mention"`
+	UserID    uint   `json:"user_id" gorm:"uniqueIndex:idx_comment_mention"`
+	Email     string `json:"email"`
+}
+
+// CommentRevision предыдущая версия отредактированного комментария
+type CommentRevision struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CommentID uint      `json:"comment_id" gorm:"index"`
+	Body      string    `json:"body"`
+	EditorID  uint      `json:"editor_id"`
+	CreatedAt time.Time `json:"created_at"` // время правки, заменившей эту версию
+}
+
+// CommentRequest запрос на создание комментария
+type CommentRequest struct {
+	Body       string `json:"body" binding:"required,max=5000"`
+	Visibility string `json:"visibility"` // internal (по умолчанию) или client
+	ParentID   *uint  `json:"parentId"`
+	FileIDs    []uint `json:"fileIds"`
+}
+
+// UpdateCommentRequest запрос на изменение текста комментария
+type UpdateCommentRequest struct {
+	Body string `json:"body" binding:"required,max=5000"`
+}
+
+// mentionPattern упоминание пользователя по email: "@ivanov@broker.ru"
+var mentionPattern = regexp.MustCompile(`(?:^|[\s(])@([^\s@()]+@[^\s@()]+\.[^\s@(),;:!?]+)`)
+
+// parseMentions возвращает email упомянутых пользователей без повторов
+func parseMentions(body string) []string {
+	var emails []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		email := strings.ToLower(strings.TrimRight(match[1], "."))
+		if !seen[email] {
+			seen[email] = true
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
+// canSeeInternalComments внутренние комментарии видны сотрудникам брокера,
+// но не клиентам, банкам и ключам API
+func canSeeInternalComments(a actor) bool {
+	return a.BankID == "" && a.Role != roleClient && a.Role != rolePartnerBank
+}
+
+// scopeComments ограничивает комментарии видимыми пользователю
+func scopeComments(a actor) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if canSeeInternalComments(a) {
+			return tx
+		}
+		return tx.Where("visibility = ?", commentClient)
+	}
+}
+
+// resolveMentions находит упомянутых пользователей, которым доступны заявка и
+// комментарий. Остальные упоминания остаются в тексте без уведомления.
+func resolveMentions(tx *gorm.DB, comment *ApplicationComment, known map[uint]bool) ([]CommentMention, error) {
+	emails := parseMentions(comment.Body)
+	if len(emails) == 0 {
+		return nil, nil
+	}
+
+	var users []models.User
+	if err := tx.Where("LOWER(email) IN ? AND is_active = ?", emails, true).Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	var mentions []CommentMention
+	for _, user := range users {
+		if known[user.ID] {
+			continue
+		}
+		a := actor{UserID: user.ID, Role: user.Role}
+		if !a.can("view_applications") || (comment.Visibility == commentInternal && !canSeeInternalComments(a)) {
+			continue
+		}
+		var count int64
+		if err := tx.Model(&Application{}).Scopes(applicationScope(a)).
+			Where("applications.id = ?", comment.ApplicationID).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			mentions = append(mentions, CommentMention{CommentID: comment.ID, UserID: user.ID, Email: user.Email})
+		}
+	}
+	return mentions, nil
+}
+
+// saveMentions сохраняет новые упоминания и уведомляет упомянутых пользователей
+func saveMentions(tx *gorm.DB, comment *ApplicationComment, authorID uint) error {
+	known := map[uint]bool{authorID: true}
+	for _, mention := range comment.Mentions {
+		known[mention.UserID] = true
+	}
+
+	mentions, err := resolveMentions(tx, comment, known)
+	if err != nil || len(mentions) == 0 {
+		return err
+	}
+	if err := tx.Create(&mentions).Error; err != nil {
+		return err
+	}
+	comment.Mentions = append(comment.Mentions, mentions...)
+
+	userIDs := make([]uint, len(mentions))
+	for i, mention := range mentions {
+		userIDs[i] = mention.UserID
+	}
+	return notify(tx, userIDs, Notification{
+		Type:          notificationMention,
+		ApplicationID: comment.ApplicationID,
+		CommentID:     comment.ID,
+		ActorID:       authorID,
+		Message:       fmt.Sprintf("Вас упомянули в комментарии к заявке #%d", comment.ApplicationID),
+	})
+}
+
+// findComment загружает комментарий заявки, видимый пользователю
+func findComment(c *gin.Context, application *Application, comment *ApplicationComment) bool {
+	err := db.Scopes(scopeComments(currentActor(c))).
+		Preload("Mentions").Preload("Files").
+		Where("application_id = ?", application.ID).
+		First(comment, c.Param("commentId")).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Комментарий не найден"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения комментария"})
+		return false
+	}
+	return true
+}
+
+// GetApplicationComments возвращает комментарии заявки в порядке создания
+func GetApplicationComments(c *gin.Context) {
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+
+	var comments []ApplicationComment
+	if err := db.Scopes(scopeComments(currentActor(c))).
+		Preload("Mentions").Preload("Files").
+		Where("application_id = ?", application.ID).
+		Order("created_at, id").
+		Find(&comments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения комментариев"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"comments": comments})
+}
+
+// CreateApplicationComment добавляет комментарий или ответ на комментарий.
+// Упомянутые через @email пользователи получают уведомление.
+func CreateApplicationComment(c *gin.Context) {
+	var req CommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+
+	a := currentActor(c)
+	comment := ApplicationComment{
+		ApplicationID: application.ID,
+		ParentID:      req.ParentID,
+		AuthorID:      a.UserID,
+		Body:          strings.TrimSpace(req.Body),
+		Visibility:    req.Visibility,
+	}
+	switch comment.Visibility {
+	case "":
+		comment.Visibility = commentInternal
+		if !canSeeInternalComments(a) {
+			comment.Visibility = commentClient
+		}
+	case commentClient:
+	case commentInternal:
+		if !canSeeInternalComments(a) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Нет прав на внутренние комментарии"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Видимость комментария: internal или client"})
+		return
+	}
+
+	if req.ParentID != nil {
+		var parent ApplicationComment
+		if err := db.Scopes(scopeComments(a)).
+			Where("application_id = ?", application.ID).
+			First(&parent, *req.ParentID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Комментарий для ответа не найден"})
+			return
+		}
+		// Ответ на внутренний комментарий не может быть виден клиенту
+		if parent.Visibility == commentInternal {
+			comment.Visibility = commentInternal
+		}
+	}
+
+	if len(req.FileIDs) > 0 {
+		if err := db.Where("id IN ? AND application_id = ? AND is_deleted = ?", req.FileIDs, application.ID, false).
+			Find(&comment.Files).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения файлов"})
+			return
+		}
+		if len(comment.Files) != len(req.FileIDs) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Файлы должны быть загружены в эту заявку"})
+			return
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Files.*").Create(&comment).Error; err != nil {
+			return err
+		}
+		return saveMentions(tx, &comment, a.UserID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения комментария"})
+		return
+	}
+
+	recordAudit(c, "comment.create", "application", application.ID, nil, gin.H{
+		"comment_id": comment.ID,
+		"visibility": comment.Visibility,
+	})
+
+	c.JSON(http.StatusCreated, comment)
+}
+
+// UpdateApplicationComment изменяет текст комментария. Изменять может только
+// автор; предыдущая версия сохраняется в истории правок.
+func UpdateApplicationComment(c *gin.Context) {
+	var req UpdateCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+	var comment ApplicationComment
+	if !findComment(c, &application, &comment) {
+		return
+	}
+
+	a := currentActor(c)
+	if comment.AuthorID != a.UserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Изменять комментарий может только автор"})
+		return
+	}
+
+	body := strings.TrimSpace(req.Body)
+	if body == comment.Body {
+		c.JSON(http.StatusOK, comment)
+		return
+	}
+
+	before := comment.Body
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&CommentRevision{CommentID: comment.ID, Body: comment.Body, EditorID: a.UserID, CreatedAt: now}).Error; err != nil {
+			return err
+		}
+		comment.Body, comment.EditedAt = body, &now
+		if err := tx.Model(&comment).Updates(map[string]interface{}{"body": body, "edited_at": now}).Error; err != nil {
+			return err
+		}
+		// Уведомления получают только пользователи, упомянутые впервые
+		return saveMentions(tx, &comment, a.UserID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка изменения комментария"})
+		return
+	}
+
+	recordAudit(c, "comment.update", "application", application.ID,
+		gin.H{"comment_id": comment.ID, "body": before},
+		gin.H{"comment_id": comment.ID, "body": comment.Body})
+
+	c.JSON(http.StatusOK, comment)
+}
+
+// GetCommentHistory возвращает предыдущие версии комментария
+func GetCommentHistory(c *gin.Context) {
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+	var comment ApplicationComment
+	if !findComment(c, &application, &comment) {
+		return
+	}
+
+	var revisions []CommentRevision
+	if err := db.Where("comment_id = ?", comment.ID).Order("created_at, id").Find(&revisions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения истории комментария"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"comment": comment, "revisions": revisions})
+}
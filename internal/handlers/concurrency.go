@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// errVersionConflict заявку изменили после того, как ее прочитал пользователь
+var errVersionConflict = errors.New("заявка изменена другим пользователем, обновите данные")
+
+// applicationETag ETag заявки по ее версии
+func applicationETag(application *Application) string {
+	return fmt.Sprintf(`"%d"`, application.Version)
+}
+
+// setApplicationETag отдает версию заявки в заголовке ETag
+func setApplicationETag(c *gin.Context, application *Application) {
+	c.Header("ETag", applicationETag(application))
+}
+
+// checkIfMatch сверяет заголовок If-Match с текущей версией заявки. Без
+// заголовка изменение разрешено; при несовпадении отвечает 412 с текущей
+// версией.
+func checkIfMatch(c *gin.Context, application *Application) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+	etag := applicationETag(application)
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+		if value == "*" || value == etag {
+			return true
+		}
+	}
+	respondVersionConflict(c, application)
+	return false
+}
+
+// respondVersionConflict отвечает 412 с текущей версией заявки
+func respondVersionConflict(c *gin.Context, application *Application) {
+	var current Application
+	if err := db.Select("id", "version").First(&current, application.ID).Error; err == nil {
+		application = &current
+	}
+	setApplicationETag(c, application)
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"error":   "Заявка изменена другим пользователем, обновите данные",
+		"version": application.Version,
+	})
+}
+
+// saveApplication сохраняет поля заявки, если ее версия не изменилась с
+// момента чтения, и увеличивает версию. Иначе возвращает errVersionConflict.
+func saveApplication(tx *gorm.DB, application *Application, fields ...string) error {
+	version := application.Version
+	application.Version++
+	result := tx.Model(application).
+		Where("version = ?", version).
+		Select(append([]string{"Version"}, fields...)).
+		Updates(application)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = errVersionConflict
+	}
+	if result.Error != nil {
+		application.Version = version
+	}
+	return result.Error
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"tenderhelp/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Режимы обработки найденных дубликатов
+const (
+	duplicateBlock    = "block"    // заявку нельзя создать или отправить
+	duplicateOverride = "override" // можно, указав причину
+)
+
+// Этапы проверки на дубликаты
+const (
+	duplicateStageCreate = "create"
+	duplicateStageSubmit = "submit"
+)
+
+// notificationDuplicate тип уведомления о подаче похожей заявки
+const notificationDuplicate = "application.duplicate"
+
+// maxDuplicateMatches сколько похожих заявок возвращается в ответе
+const maxDuplicateMatches = 20
+
+// DuplicateRule правило поиска дубликатов: заявки клиентов с тем же ИНН на
+// тот же продукт, созданные за последние WindowDays дней, с суммой в
+// пределах AmountTolerance (доля: 0.1 - плюс-минус 10%)
+type DuplicateRule struct {
+	WindowDays      int
+	AmountTolerance float64
+	Mode            string
+}
+
+// DuplicateMatch похожая заявка
+type DuplicateMatch struct {
+	ID        uint      `json:"id"`
+	ClientID  uint      `json:"client_id"`
+	AgentID   uint      `json:"agent_id"`
+	ManagerID uint      `json:"manager_id"`
+	Type      string    `json:"type"`
+	Amount    float64   `json:"amount"`
+	Status    string    `json:"status"`
+	Bank      string    `json:"bank,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DuplicateOverride подтверждение заявки, несмотря на найденные дубликаты.
+// Подтвержденные дубликаты не проверяются повторно при отправке.
+type DuplicateOverride struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	ApplicationID uint      `json:"application_id" gorm:"index"`
+	Stage         string    `json:"stage"` // create, submit
+	DuplicateIDs  []uint    `json:"duplicate_ids" gorm:"serializer:json"`
+	Reason        string    `json:"reason"`
+	ActorID       uint      `json:"actor_id"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// SubmitApplicationRequest запрос на отправку заявки (тело необязательно)
+type SubmitApplicationRequest struct {
+	DuplicateReason string `json:"duplicateReason"`
+}
+
+// duplicateSettings правило поиска дубликатов из окружения:
+// DUPLICATE_WINDOW_DAYS (по умолчанию 30), DUPLICATE_AMOUNT_TOLERANCE в
+// процентах (по умолчанию 10) и DUPLICATE_MODE: override или block
+func duplicateSettings() DuplicateRule {
+	rule := DuplicateRule{WindowDays: 30, AmountTolerance: 0.1, Mode: duplicateOverride}
+	if days, err := strconv.Atoi(os.Getenv("DUPLICATE_WINDOW_DAYS")); err == nil && days > 0 {
+		rule.WindowDays = days
+	}
+	if percent, err := strconv.ParseFloat(os.Getenv("DUPLICATE_AMOUNT_TOLERANCE"), 64); err == nil && percent >= 0 && percent < 100 {
+		rule.AmountTolerance = percent / 100
+	}
+	if os.Getenv("DUPLICATE_MODE") == duplicateBlock {
+		rule.Mode = duplicateBlock
+	}
+	return rule
+}
+
+// similar совпадают ли у заявок продукт, сумма в пределах допуска и банк
+// (заявка без банка совпадает с любым банком)
+func (r DuplicateRule) similar(a, b Application) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	if a.Bank != "" && b.Bank != "" && a.Bank != b.Bank {
+		return false
+	}
+	if a.Amount > 0 && math.Abs(a.Amount-b.Amount) > a.Amount*r.AmountTolerance {
+		return false
+	}
+	return true
+}
+
+// clientINN возвращает ИНН клиента
+func clientINN(tx *gorm.DB, clientID uint) (string, error) {
+	var client models.Client
+	if err := tx.Select("id", "inn").First(&client, clientID).Error; err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(client.INN), nil
+}
+
+// findDuplicates ищет похожие заявки по правилу среди всех заявок, а не
+// только доступных пользователю: один клиент может вести несколько агентов.
+// Отклоненные заявки и пары, подтвержденные любой из двух заявок, не
+// учитываются.
+func findDuplicates(tx *gorm.DB, application Application, rule DuplicateRule) ([]DuplicateMatch, error) {
+	if application.ClientID == 0 {
+		return nil, nil
+	}
+	inn, err := clientINN(tx, application.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := tx.Model(&models.Client{}).Select("id").Where("id = ?", application.ClientID)
+	if inn != "" {
+		clients = tx.Model(&models.Client{}).Select("id").Where("inn = ?", inn)
+	}
+	query := tx.Model(&Application{}).
+		Where("client_id IN (?)", clients).
+		Where("type = ?", application.Type).
+		Where("status <> ?", string(statusRejected)).
+		Where("created_at >= ?", time.Now().AddDate(0, 0, -rule.WindowDays))
+	if application.Amount > 0 {
+		delta := application.Amount * rule.AmountTolerance
+		query = query.Where("amount BETWEEN ? AND ?", application.Amount-delta, application.Amount+delta)
+	}
+	if application.Bank != "" {
+		query = query.Where("(bank = '' OR bank = ?)", application.Bank)
+	}
+	if application.ID != 0 {
+		query = query.Where("id <> ?", application.ID)
+
+		// Дубликаты, подтвержденные этой заявкой
+		var own []DuplicateOverride
+		if err := tx.Where("application_id = ?", application.ID).Find(&own).Error; err != nil {
+			return nil, err
+		}
+		var excluded []uint
+		for _, override := range own {
+			excluded = append(excluded, override.DuplicateIDs...)
+		}
+		if len(excluded) > 0 {
+			query = query.Where("id NOT IN ?", excluded)
+		}
+	}
+
+	var matches []DuplicateMatch
+	if err := query.Order("created_at DESC").Limit(maxDuplicateMatches).Find(&matches).Error; err != nil {
+		return nil, err
+	}
+	if application.ID == 0 || len(matches) == 0 {
+		return matches, nil
+	}
+
+	// Похожие заявки, которые сами подтвердили дубликат с этой заявкой
+	ids := make([]uint, len(matches))
+	for i, match := range matches {
+		ids[i] = match.ID
+	}
+	var reverse []DuplicateOverride
+	if err := tx.Where("application_id IN ?", ids).Find(&reverse).Error; err != nil {
+		return nil, err
+	}
+	confirmed := map[uint]bool{}
+	for _, override := range reverse {
+		for _, id := range override.DuplicateIDs {
+			if id == application.ID {
+				confirmed[override.ApplicationID] = true
+			}
+		}
+	}
+	unconfirmed := matches[:0]
+	for _, match := range matches {
+		if !confirmed[match.ID] {
+			unconfirmed = append(unconfirmed, match)
+		}
+	}
+	return unconfirmed, nil
+}
+
+// checkDuplicates проверяет заявку перед созданием или отправкой. Если
+// найдены дубликаты, а причина не указана или режим не допускает
+// подтверждения, отвечает 409 со списком похожих заявок. Возвращает
+// подтверждение для сохранения вместе с заявкой (nil, если дубликатов нет).
+func checkDuplicates(c *gin.Context, application Application, reason, stage string) (*DuplicateOverride, bool) {
+	// Без клиента дубликаты не найти, поэтому такая заявка проверку не проходит
+	if application.ClientID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите клиента заявки (clientId): без него нельзя проверить дубликаты"})
+		return nil, false
+	}
+
+	rule := duplicateSettings()
+	matches, err := findDuplicates(db, application, rule)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки дубликатов"})
+		return nil, false
+	}
+	if len(matches) == 0 {
+		return nil, true
+	}
+
+	reason = strings.TrimSpace(reason)
+	if rule.Mode == duplicateBlock || reason == "" {
+		message := "Найдены похожие заявки клиента: укажите причину повторной подачи в duplicateReason"
+		if rule.Mode == duplicateBlock {
+			message = "Найдены похожие заявки клиента, повторная подача запрещена"
+		}
+		visible, hidden := visibleDuplicates(c, matches)
+		c.JSON(http.StatusConflict, gin.H{
+			"error":             message,
+			"duplicates":        visible,
+			"hidden_duplicates": hidden,
+			"override_allowed":  rule.Mode == duplicateOverride,
+		})
+		return nil, false
+	}
+
+	ids := make([]uint, len(matches))
+	for i, match := range matches {
+		ids[i] = match.ID
+	}
+	return &DuplicateOverride{
+		Stage:        stage,
+		DuplicateIDs: ids,
+		Reason:       reason,
+		ActorID:      currentActor(c).UserID,
+	}, true
+}
+
+// visibleDuplicates оставляет подробности только о похожих заявках, доступных
+// пользователю; о заявках других агентов сообщается только их количество,
+// как в родословной заявки
+func visibleDuplicates(c *gin.Context, matches []DuplicateMatch) ([]DuplicateMatch, int) {
+	if len(matches) == 0 {
+		return matches, 0
+	}
+	ids := make([]uint, len(matches))
+	for i, match := range matches {
+		ids[i] = match.ID
+	}
+	var visibleIDs []uint
+	db.Model(&Application{}).Scopes(scopeApplications(c)).
+		Where("applications.id IN ?", ids).Pluck("applications.id", &visibleIDs)
+	allowed := make(map[uint]bool, len(visibleIDs))
+	for _, id := range visibleIDs {
+		allowed[id] = true
+	}
+
+	visible := make([]DuplicateMatch, 0, len(matches))
+	for _, match := range matches {
+		if allowed[match.ID] {
+			visible = append(visible, match)
+		}
+	}
+	return visible, len(matches) - len(visible)
+}
+
+// saveDuplicateOverride сохраняет подтверждение и уведомляет агентов и
+// менеджеров похожих заявок
+func saveDuplicateOverride(tx *gorm.DB, override *DuplicateOverride, applicationID uint) error {
+	override.ApplicationID = applicationID
+	if err := tx.Create(override).Error; err != nil {
+		return err
+	}
+
+	var related []Application
+	if err := tx.Select("id", "agent_id", "manager_id").Where("id IN ?", override.DuplicateIDs).Find(&related).Error; err != nil {
+		return err
+	}
+	var recipients []uint
+	for _, application := range related {
+		for _, userID := range []uint{application.AgentID, application.ManagerID} {
+			if userID != 0 && userID != override.ActorID {
+				recipients = append(recipients, userID)
+			}
+		}
+	}
+	return notify(tx, uniqueIDs(recipients), Notification{
+		Type:          notificationDuplicate,
+		ApplicationID: applicationID,
+		ActorID:       override.ActorID,
+		Message:       fmt.Sprintf("Заявка #%d подана повторно для того же клиента: %s", applicationID, override.Reason),
+	})
+}
+
+// importDuplicate проверяет строку импорта на дубликаты в базе и среди уже
+// разобранных строк файла (drafts, ИНН их клиентов inns и номера строк
+// lines). Подтвердить дубликат при импорте нельзя, поэтому найденный
+// дубликат - ошибка строки (номер заявки - только если она доступна
+// пользователю). Возвращает ИНН клиента и текст ошибки.
+func importDuplicate(c *gin.Context, draft Application, rule DuplicateRule, drafts []Application, inns []string, lines []int) (string, string) {
+	if draft.ClientID == 0 {
+		return "", "не указан клиент: укажите ИНН или ID клиента"
+	}
+	matches, err := findDuplicates(db, draft, rule)
+	if err != nil {
+		return "", "ошибка проверки дубликатов"
+	}
+	if len(matches) > 0 {
+		if visible, _ := visibleDuplicates(c, matches); len(visible) > 0 {
+			return "", fmt.Sprintf("похожа на заявку #%d того же клиента, создайте ее отдельно с указанием причины", visible[0].ID)
+		}
+		return "", "похожа на заявку того же клиента другого агента, создайте ее отдельно с указанием причины"
+	}
+
+	inn, err := clientINN(db, draft.ClientID)
+	if err != nil {
+		return "", "ошибка проверки дубликатов"
+	}
+	for i, other := range drafts {
+		sameClient := other.ClientID == draft.ClientID || (inn != "" && inns[i] == inn)
+		if sameClient && rule.similar(draft, other) {
+			return inn, fmt.Sprintf("повторяет строку %d того же клиента", lines[i])
+		}
+	}
+	return inn, ""
+}
+
+// GetApplicationDuplicates возвращает похожие заявки и подтверждения
+// повторной подачи
+func GetApplicationDuplicates(c *gin.Context) {
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+
+	rule := duplicateSettings()
+	matches, err := findDuplicates(db, application, rule)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки дубликатов"})
+		return
+	}
+	var overrides []DuplicateOverride
+	if err := db.Where("application_id = ?", application.ID).Order("created_at").Find(&overrides).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения подтверждений"})
+		return
+	}
+
+	visible, hidden := visibleDuplicates(c, matches)
+	c.JSON(http.StatusOK, gin.H{
+		"duplicates":        visible,
+		"hidden_duplicates": hidden,
+		"overrides":         overrides,
+		"override_allowed":  rule.Mode == duplicateOverride,
+	})
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"tenderhelp/internal/export"
+	"tenderhelp/internal/models"
+	"tenderhelp/internal/questionnaire"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportBatchSize количество заявок, читаемых из базы за один запрос выгрузки
+const exportBatchSize = 500
+
+// exportColumn столбец анкеты в выгрузке
+type exportColumn struct {
+	title   string
+	section string // имя шага анкеты
+	path    []string
+}
+
+// exportApplicationHeader основные столбцы листа заявок
+var exportApplicationHeader = []string{
+	"ID", "Статус", "Тип", "Сумма", "Банк", "Клиент", "ИНН клиента",
+	"Класс риска", "ID менеджера", "ID агента", "Создана", "Изменена",
+}
+
+// exportHistoryHeader столбцы листа истории статусов
+var exportHistoryHeader = []string{"ID заявки", "Дата", "Из статуса", "Статус", "ID пользователя", "Этап", "Комментарий"}
+
+// exportColumns разбирает параметр fields: "all" - все поля анкеты, иначе
+// пути полей через запятую ("personalData.lastName,financialData.monthlyIncome")
+func exportColumns(value string) ([]exportColumn, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	sections := make(map[string]string)
+	for _, step := range questionnaire.Get().Steps {
+		sections[step.Field] = step.Name
+	}
+	fields := make(map[string]questionnaire.Field)
+	titles := make(map[string]int)
+	for _, field := range questionnaire.Fields() {
+		fields[field.Path] = field
+		titles[field.Title]++
+	}
+
+	var selected []questionnaire.Field
+	if value == "all" {
+		selected = questionnaire.Fields()
+	} else {
+		for _, path := range splitList(value) {
+			field, ok := fields[path]
+			if !ok {
+				return nil, fmt.Errorf("неизвестное поле анкеты: %s", path)
+			}
+			selected = append(selected, field)
+		}
+	}
+
+	columns := make([]exportColumn, len(selected))
+	for i, field := range selected {
+		segments := strings.Split(field.Path, ".")
+		columns[i] = exportColumn{title: field.Title, section: sections[segments[0]], path: segments[1:]}
+		// Одинаковые названия полей разных разделов различаются по пути
+		if field.Title == "" || titles[field.Title] > 1 {
+			columns[i].title = field.Path
+		}
+	}
+	return columns, nil
+}
+
+// exportQuestionnaireValues значения полей анкеты заявки. Списки выводятся
+// через запятую.
+func exportQuestionnaireValues(application *Application, columns []exportColumn) []interface{} {
+	documents := make(map[string]map[string]interface{})
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		document, ok := documents[column.section]
+		if !ok {
+			if data, found := application.stepData(column.section); found && len(*data) > 0 {
+				json.Unmarshal(*data, &document)
+			}
+			documents[column.section] = document
+		}
+
+		var value interface{} = document
+		for _, segment := range column.path {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				value = nil
+				break
+			}
+			value = object[segment]
+		}
+
+		if list, ok := value.([]interface{}); ok {
+			items := make([]string, len(list))
+			for j, item := range list {
+				items[j] = export.FormatValue(item)
+			}
+			value = strings.Join(items, ", ")
+		}
+		values[i] = value
+	}
+	return values
+}
+
+// ExportApplications выгружает заявки в CSV или XLSX с учетом фильтров и
+// сортировки списка заявок. Заявки читаются из базы пачками, поэтому
+// выгрузка не ограничена по количеству строк.
+func ExportApplications(c *gin.Context) {
+	values, err := applicationListParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	params, err := parseApplicationQuery(values, currentActor(c).UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Выгрузка идет по курсору от начала списка
+	params.paged, params.cursor, params.limit = false, nil, exportBatchSize
+
+	format := c.DefaultQuery("format", "xlsx")
+	columns, err := exportColumns(c.Query("fields"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	withHistory := c.Query("history") == "true"
+	sheets := 1
+	if withHistory {
+		sheets = 2
+	}
+
+	writer, err := export.New(format, c.Writer, sheets)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fileName := fmt.Sprintf("applications_%s.%s", time.Now().Format("20060102_150405"), export.Extension(format, sheets))
+	c.Header("Content-Type", export.ContentType(format, sheets))
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+
+	count, err := writeApplicationsExport(c, writer, params, columns, withHistory)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		log.Printf("Ошибка выгрузки заявок: %v", err)
+		// После начала передачи файла ответ изменить уже нельзя
+		if !c.Writer.Written() {
+			c.Header("Content-Disposition", "")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка выгрузки заявок"})
+		}
+		return
+	}
+
+	recordAudit(c, "application.export", "application", "", nil, gin.H{
+		"format":  format,
+		"filters": values.Encode(),
+		"fields":  c.Query("fields"),
+		"history": withHistory,
+		"count":   count,
+	})
+}
+
+// writeApplicationsExport записывает листы заявок и истории статусов
+func writeApplicationsExport(c *gin.Context, writer export.Writer, params *applicationQuery, columns []exportColumn, withHistory bool) (int, error) {
+	header := append([]string{}, exportApplicationHeader...)
+	for _, column := range columns {
+		header = append(header, column.title)
+	}
+	if err := writer.AddSheet("applications", header); err != nil {
+		return 0, err
+	}
+
+	showPII := canViewPII(c)
+	count := 0
+	for {
+		var batch []Application
+		if err := db.Model(&Application{}).
+			Scopes(scopeApplications(c), params.scope, params.paginate).
+			Find(&batch).Error; err != nil {
+			return count, err
+		}
+
+		clients := make(map[uint]models.Client)
+		if len(batch) > 0 {
+			ids := make([]uint, len(batch))
+			for i := range batch {
+				ids[i] = batch[i].ClientID
+			}
+			var found []models.Client
+			if err := db.Where("id IN ?", ids).Find(&found).Error; err != nil {
+				return count, err
+			}
+			for _, client := range found {
+				clients[client.ID] = client
+			}
+		}
+
+		for i := range batch {
+			application := &batch[i]
+			if !showPII {
+				masked := application.maskedPII()
+				application = &masked
+			}
+			client := clients[application.ClientID]
+			row := []interface{}{
+				application.ID, application.Status, application.Type, application.Amount, application.Bank,
+				client.Name, client.INN, application.RiskClass, application.ManagerID, application.AgentID,
+				application.CreatedAt, application.UpdatedAt,
+			}
+			row = append(row, exportQuestionnaireValues(application, columns)...)
+			if err := writer.WriteRow(row); err != nil {
+				return count, err
+			}
+		}
+		count += len(batch)
+
+		if len(batch) < params.limit {
+			break
+		}
+		params.cursor = params.cursorValues(&batch[len(batch)-1])
+	}
+
+	if withHistory {
+		if err := writeHistoryExport(c, writer, params); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// writeHistoryExport записывает историю статусов выгружаемых заявок
+func writeHistoryExport(c *gin.Context, writer export.Writer, params *applicationQuery) error {
+	if err := writer.AddSheet("status_history", exportHistoryHeader); err != nil {
+		return err
+	}
+
+	rows, err := db.Model(&StatusHistory{}).
+		Where("application_id IN (?)", db.Model(&Application{}).Select("applications.id").
+			Scopes(scopeApplications(c), params.scope)).
+		Order("application_id, timestamp, id").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry StatusHistory
+		if err := db.ScanRows(rows, &entry); err != nil {
+			return err
+		}
+		if err := writer.WriteRow([]interface{}{
+			entry.ApplicationID, entry.Timestamp, entry.FromStatus, entry.Status, entry.ActorID, entry.Stage, entry.Comment,
+		}); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -82,6 +82,12 @@ func UploadFile(c *gin.Context) {
 		return
 	}
 
+	// Проверка доступа к заявке
+	var application Application
+	if !findScopedApplication(c, applicationID, &application) {
+		return
+	}
+
 	// Валидация файла
 	if err := validateFile(header); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -153,6 +159,8 @@ func UploadFile(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, "file.upload", "file", fileRecord.ID, nil, fileAuditState(fileRecord))
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Файл успешно загружен",
 		"file":    fileRecord,
@@ -179,6 +187,12 @@ func GetPresignedUploadURL(c *gin.Context) {
 		return
 	}
 
+	// Проверка доступа к заявке
+	var application Application
+	if !findScopedApplication(c, req.ApplicationID, &application) {
+		return
+	}
+
 	// Создание записи о файле в БД
 	fileRecord := File{
 		ApplicationID: req.ApplicationID,
@@ -212,10 +226,16 @@ func GetPresignedUploadURL(c *gin.Context) {
 
 // GetFiles возвращает список файлов заявки
 func GetFiles(c *gin.Context) {
-	applicationID := c.Param("applicationId")
+	applicationID := c.Param("id")
+
+	// Заявка должна быть доступна пользователю
+	var application Application
+	if !findScopedApplication(c, applicationID, &application) {
+		return
+	}
 
 	var files []File
-	query := db.Where("application_id = ? AND is_deleted = ?", applicationID, false)
+	query := db.Where("application_id = ? AND is_deleted = ?", application.ID, false)
 
 	if err := query.Find(&files).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения файлов"})
@@ -235,7 +255,7 @@ func DeleteFile(c *gin.Context) {
 	fileID := c.Param("fileId")
 
 	var file File
-	if err := db.First(&file, fileID).Error; err != nil {
+	if err := db.Scopes(scopeFiles(c)).First(&file, fileID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Файл не найден"})
 			return
@@ -245,15 +265,21 @@ func DeleteFile(c *gin.Context) {
 	}
 
 	// Мягкое удаление
+	before := fileAuditState(file)
 	file.IsDeleted = true
 	if err := db.Save(&file).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка удаления файла"})
 		return
 	}
+	recordAudit(c, "file.delete", "file", file.ID, before, fileAuditState(file))
 
-	// Удаление физического файла
+	// Удаление физического файла, если на него не ссылаются копии заявки
 	if file.FilePath != "" {
-		os.Remove(file.FilePath)
+		var shared int64
+		db.Model(&File{}).Where("file_path = ? AND is_deleted = ? AND id <> ?", file.FilePath, false, file.ID).Count(&shared)
+		if shared == 0 {
+			os.Remove(file.FilePath)
+		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Файл успешно удален"})
@@ -264,7 +290,7 @@ func DownloadFile(c *gin.Context) {
 	fileID := c.Param("fileId")
 
 	var file File
-	if err := db.First(&file, fileID).Error; err != nil {
+	if err := db.Scopes(scopeFiles(c)).First(&file, fileID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Файл не найден"})
 			return
@@ -342,6 +368,19 @@ func isAllowedFileType(mimeType string) bool {
 	return false
 }
 
+// fileAuditState состояние файла для журнала аудита. Метаданные не
+// включаются: в них могут быть произвольные данные формы.
+func fileAuditState(file File) gin.H {
+	return gin.H{
+		"application_id": file.ApplicationID,
+		"original_name":  file.OriginalName,
+		"file_size":      file.FileSize,
+		"mime_type":      file.MimeType,
+		"file_hash":      file.FileHash,
+		"is_deleted":     file.IsDeleted,
+	}
+}
+
 // calculateFileHash вычисляет хеш файла
 func calculateFileHash(filePath string) (string, error) {
 	// TODO: Реализовать вычисление SHA-256 хеша
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"tenderhelp/internal/pii"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Параметры ключей идемпотентности
+const (
+	idempotencyTTL          = 24 * time.Hour
+	maxIdempotencyKeyLength = 255
+	// Запрос читается целиком для хеша: файл до 10 МБ и поля формы
+	maxIdempotentBodySize = 11 << 20
+)
+
+// idempotencyBodyPath дополнительные данные при шифровании сохраненного ответа
+const idempotencyBodyPath = "idempotency.body"
+
+// IdempotencyKey ключ идемпотентности пользователя (или ключа API) и
+// сохраненный ответ на запрос с этим ключом. Пока запрос выполняется,
+// Completed = false. Ответ может содержать расшифрованные персональные данные,
+// поэтому тело хранится зашифрованным ключом данных записи.
+type IdempotencyKey struct {
+	ID          uint      `gorm:"primaryKey"`
+	UserID      uint      `gorm:"uniqueIndex:idx_idempotency_key"`
+	APIKeyID    uint      `gorm:"column:api_key_id;uniqueIndex:idx_idempotency_key"`
+	Key         string    `gorm:"column:idempotency_key;size:255;uniqueIndex:idx_idempotency_key"`
+	Method      string    `gorm:"size:10"`
+	Path        string    `gorm:"size:255"`
+	RequestHash string    `gorm:"size:64"`
+	Completed   bool      `gorm:"default:false"`
+	StatusCode  int       `gorm:"default:0"`
+	ContentType string    `gorm:"size:100"`
+	ETag        string    `gorm:"size:100"`
+	Body        []byte    `gorm:"type:bytea"`
+	BodyKeyID   string    `gorm:"size:50"`
+	BodyDataKey string    `gorm:"type:text"`
+	CreatedAt   time.Time `gorm:"index"`
+	ExpiresAt   time.Time `gorm:"index"`
+}
+
+// sealBody сохраняет тело ответа, зашифровав его новым ключом данных
+func (k *IdempotencyKey) sealBody(body []byte) error {
+	if piiKeyring == nil {
+		k.Body = body
+		return nil
+	}
+
+	dataKey, wrapped, keyID, err := piiKeyring.NewDataKey()
+	if err != nil {
+		return err
+	}
+	c, err := pii.NewCipher(dataKey)
+	if err != nil {
+		return err
+	}
+	sealed, err := c.EncryptValue(idempotencyBodyPath, string(body))
+	if err != nil {
+		return err
+	}
+	k.Body = []byte(sealed.(string))
+	k.BodyDataKey, k.BodyKeyID = wrapped, keyID
+	return nil
+}
+
+// openBody возвращает расшифрованное тело сохраненного ответа
+func (k *IdempotencyKey) openBody() ([]byte, error) {
+	if k.BodyDataKey == "" {
+		return k.Body, nil
+	}
+	if piiKeyring == nil {
+		return nil, errPIIKeysMissing
+	}
+
+	dataKey, err := piiKeyring.UnwrapDataKey(k.BodyKeyID, k.BodyDataKey)
+	if err != nil {
+		return nil, err
+	}
+	c, err := pii.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	value, err := c.DecryptValue(idempotencyBodyPath, string(k.Body))
+	if err != nil {
+		return nil, err
+	}
+	body, _ := value.(string)
+	return []byte(body), nil
+}
+
+// purgeIdempotentResponses удаляет сохраненные ответы, зашифрованные не
+// активным мастер-ключом или сохраненные без шифрования, чтобы после ротации
+// старый ключ можно было удалить. Выполняющиеся запросы не затрагиваются.
+func purgeIdempotentResponses() error {
+	return db.Where("completed = ? AND (body_key_id IS NULL OR body_key_id <> ?)", true, piiKeyring.ActiveKeyID()).
+		Delete(&IdempotencyKey{}).Error
+}
+
+// recordingWriter копирует тело ответа для сохранения
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotent middleware для создающих запросов: повтор запроса с тем же
+// заголовком Idempotency-Key возвращает сохраненный ответ (с заголовком
+// Idempotent-Replayed) вместо повторного выполнения. Ключ привязан к
+// пользователю и действует сутки; тот же ключ с другим запросом
+// отклоняется. Ответы 5xx не сохраняются, такой запрос можно повторить.
+func Idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimSpace(c.GetHeader("Idempotency-Key"))
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Ключ идемпотентности длиннее 255 символов"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Размер запроса превышает 11 МБ"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Ошибка чтения запроса"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		io.WriteString(hash, c.Request.Method+" "+c.Request.URL.RequestURI()+"\n")
+		hash.Write(body)
+
+		now := time.Now()
+		db.Where("expires_at < ?", now).Delete(&IdempotencyKey{})
+
+		record := IdempotencyKey{
+			UserID:      currentActor(c).UserID,
+			APIKeyID:    c.GetUint("api_key_id"),
+			Key:         key,
+			Method:      c.Request.Method,
+			Path:        c.Request.URL.Path,
+			RequestHash: hex.EncodeToString(hash.Sum(nil)),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(idempotencyTTL),
+		}
+		if err := db.Create(&record).Error; err != nil {
+			replayIdempotentResponse(c, record)
+			c.Abort()
+			return
+		}
+
+		// Ключ освобождается, если ответ не сохранен (ошибка сервера, паника)
+		completed := false
+		defer func() {
+			if !completed {
+				db.Delete(&record)
+			}
+		}()
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		status := writer.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		response := IdempotencyKey{
+			Completed:   true,
+			StatusCode:  status,
+			ContentType: writer.Header().Get("Content-Type"),
+			ETag:        writer.Header().Get("ETag"),
+		}
+		if err := response.sealBody(writer.body.Bytes()); err != nil {
+			return
+		}
+		completed = db.Model(&record).Updates(response).Error == nil
+	}
+}
+
+// replayIdempotentResponse отвечает на повтор запроса с уже использованным ключом
+func replayIdempotentResponse(c *gin.Context, record IdempotencyKey) {
+	var existing IdempotencyKey
+	err := db.Where("user_id = ? AND api_key_id = ? AND idempotency_key = ?", record.UserID, record.APIKeyID, record.Key).
+		First(&existing).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки ключа идемпотентности"})
+		return
+	}
+
+	switch {
+	case existing.RequestHash != record.RequestHash:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Ключ идемпотентности уже использован для другого запроса"})
+	case !existing.Completed:
+		c.JSON(http.StatusConflict, gin.H{"error": "Запрос с этим ключом идемпотентности еще выполняется"})
+	default:
+		body, err := existing.openBody()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка чтения сохраненного ответа"})
+			return
+		}
+		if existing.ETag != "" {
+			c.Header("ETag", existing.ETag)
+		}
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(existing.StatusCode, existing.ContentType, body)
+	}
+}
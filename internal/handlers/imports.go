@@ -0,0 +1,532 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"tenderhelp/internal/export"
+	"tenderhelp/internal/importer"
+	"tenderhelp/internal/jsonschema"
+	"tenderhelp/internal/questionnaire"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ImportJob импорт заявок из таблицы
+type ImportJob struct {
+	ID           uint          `json:"id" gorm:"primaryKey"`
+	UserID       uint          `json:"user_id" gorm:"index"`
+	FileName     string        `json:"file_name"`
+	Format       string        `json:"format"` // csv, xlsx
+	Mode         string        `json:"mode"`   // atomic, partial
+	Status       string        `json:"status"` // completed, partial, rejected
+	TotalRows    int           `json:"total_rows"`
+	CreatedCount int           `json:"created_count"`
+	ErrorCount   int           `json:"error_count"`
+	CreatedAt    time.Time     `json:"created_at"`
+	Errors       []ImportError `json:"errors,omitempty" gorm:"foreignKey:JobID"`
+}
+
+// ImportError ошибка в строке импортируемой таблицы. Значения ячеек в отчет
+// не попадают: в них могут быть персональные данные.
+type ImportError struct {
+	ID      uint   `json:"-" gorm:"primaryKey"`
+	JobID   uint   `json:"-" gorm:"index"`
+	Line    int    `json:"line"`             // номер строки в файле
+	Column  string `json:"column,omitempty"` // заголовок столбца
+	Field   string `json:"field,omitempty"`  // поле заявки
+	Message string `json:"message"`
+}
+
+// Режимы импорта
+const (
+	// importAtomic все строки создаются в одной транзакции; при любой ошибке
+	// не создается ни одной заявки
+	importAtomic = "atomic"
+	// importPartial создаются корректные строки, ошибочные попадают в отчет
+	importPartial = "partial"
+)
+
+// Состояния импорта
+const (
+	importCompleted = "completed"
+	importPartially = "partial"
+	importRejected  = "rejected"
+)
+
+// maxImportFileSize максимальный размер импортируемого файла
+const maxImportFileSize = 10 << 20
+
+// Поля заявки вне анкеты, которые можно импортировать
+const (
+	importFieldType      = "type"
+	importFieldAmount    = "amount"
+	importFieldClientID  = "clientId"
+	importFieldClientINN = "clientInn"
+)
+
+// importFieldTitles заголовки столбцов для полей заявки вне анкеты
+var importFieldTitles = map[string]string{
+	"тип заявки":  importFieldType,
+	"тип":         importFieldType,
+	"сумма":       importFieldAmount,
+	"id клиента":  importFieldClientID,
+	"инн клиента": importFieldClientINN,
+	"инн":         importFieldClientINN,
+}
+
+// importTargets поля, в которые можно загрузить столбец: поля заявки и
+// поля анкеты (по пути) с их схемами
+func importTargets() map[string]*jsonschema.Schema {
+	targets := map[string]*jsonschema.Schema{
+		importFieldType: nil, importFieldAmount: nil, importFieldClientID: nil, importFieldClientINN: nil,
+	}
+	for _, field := range questionnaire.Fields() {
+		targets[field.Path] = field.Schema
+	}
+	return targets
+}
+
+// defaultImportMapping сопоставление столбцов по умолчанию: путь поля или
+// название поля анкеты без учета регистра. Повторяющиеся названия не
+// сопоставляются, для них столбец нужно назвать путем поля.
+func defaultImportMapping() map[string]string {
+	mapping := make(map[string]string)
+	duplicates := make(map[string]bool)
+	for title, target := range importFieldTitles {
+		mapping[title] = target
+	}
+	for _, field := range questionnaire.Fields() {
+		title := strings.ToLower(field.Title)
+		if _, exists := mapping[title]; exists {
+			duplicates[title] = true
+		}
+		mapping[title] = field.Path
+	}
+	for title := range duplicates {
+		delete(mapping, title)
+	}
+	return mapping
+}
+
+// importColumn столбец таблицы и поле заявки, в которое он загружается
+type importColumn struct {
+	index  int
+	header string
+	target string
+	schema *jsonschema.Schema
+}
+
+// resolveImportColumns сопоставляет столбцы таблицы полям заявки. mapping
+// (заголовок - поле) дополняет сопоставление по умолчанию; пустое поле
+// означает, что столбец не загружается.
+func resolveImportColumns(header []string, mapping map[string]string) ([]importColumn, []string, error) {
+	targets := importTargets()
+	for column, target := range mapping {
+		if _, ok := targets[target]; target != "" && !ok {
+			return nil, nil, fmt.Errorf("столбец '%s': неизвестное поле '%s'", column, target)
+		}
+	}
+	defaults := defaultImportMapping()
+
+	var columns []importColumn
+	var ignored []string
+	used := make(map[string]string)
+	for i, name := range header {
+		target, ok := mapping[name]
+		if !ok {
+			if _, isPath := targets[name]; isPath {
+				target = name
+			} else {
+				target = defaults[strings.ToLower(name)]
+			}
+		}
+		if target == "" {
+			if name != "" {
+				ignored = append(ignored, name)
+			}
+			continue
+		}
+		if previous, ok := used[target]; ok {
+			return nil, nil, fmt.Errorf("столбцы '%s' и '%s' загружаются в одно поле %s", previous, name, target)
+		}
+		used[target] = name
+		columns = append(columns, importColumn{index: i, header: name, target: target, schema: targets[target]})
+	}
+
+	if len(columns) == 0 {
+		return nil, nil, errors.New("ни один столбец не сопоставлен полям заявки")
+	}
+	return columns, ignored, nil
+}
+
+// convertImportValue приводит значение ячейки к типу поля анкеты
+func convertImportValue(schema *jsonschema.Schema, value string) (interface{}, error) {
+	switch schema.Type {
+	case "number", "integer":
+		number, err := importer.ParseNumber(value)
+		if err != nil {
+			return nil, err
+		}
+		return number, nil
+	case "boolean":
+		return importer.ParseBool(value)
+	case "array":
+		return importer.ParseList(value), nil
+	}
+	if schema.Format == "date" {
+		return importer.ParseDate(value)
+	}
+	return value, nil
+}
+
+// importRow строка таблицы, разобранная в запрос на создание заявки
+type importRow struct {
+	line         int
+	req          CreateApplicationRequest
+	clientINN    string
+	clientColumn string // столбец с ИНН или ID клиента
+	errors       []ImportError
+}
+
+func (r *importRow) fail(column importColumn, message string) {
+	r.errors = append(r.errors, ImportError{Line: r.line, Column: column.header, Field: column.target, Message: message})
+}
+
+// parseImportRow собирает запрос на создание заявки из строки таблицы и
+// проверяет его так же, как CreateApplication
+func parseImportRow(row importer.Row, columns []importColumn, defaults CreateApplicationRequest) *importRow {
+	result := &importRow{line: row.Line, req: defaults}
+	sections := make(map[string]map[string]interface{})
+	byPath := make(map[string]importColumn, len(columns))
+
+	for _, column := range columns {
+		byPath[column.target] = column
+		value := row.Value(column.index)
+		if value == "" {
+			continue
+		}
+
+		switch column.target {
+		case importFieldType:
+			result.req.Type = value
+		case importFieldAmount:
+			amount, err := importer.ParseNumber(value)
+			if err != nil {
+				result.fail(column, err.Error())
+			}
+			result.req.Amount = amount
+		case importFieldClientID:
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				result.fail(column, "ожидается число")
+			}
+			result.req.ClientID = uint(id)
+			result.clientColumn = column.header
+		case importFieldClientINN:
+			result.clientINN = value
+			result.clientColumn = column.header
+		default:
+			converted, err := convertImportValue(column.schema, value)
+			if err != nil {
+				result.fail(column, err.Error())
+				continue
+			}
+			section, path, _ := strings.Cut(column.target, ".")
+			if sections[section] == nil {
+				sections[section] = map[string]interface{}{}
+			}
+			importer.Set(sections[section], path, converted)
+		}
+	}
+
+	if result.req.Type == "" {
+		result.errors = append(result.errors, ImportError{Line: row.Line, Field: importFieldType, Message: "обязательное поле"})
+	}
+
+	for field, data := range map[string]*json.RawMessage{
+		"personalData":     &result.req.PersonalData,
+		"contactData":      &result.req.ContactData,
+		"professionalData": &result.req.ProfessionalData,
+		"financialData":    &result.req.FinancialData,
+		"familyData":       &result.req.FamilyData,
+		"additionalData":   &result.req.AdditionalData,
+	} {
+		section := sections[field]
+		if section == nil {
+			section = map[string]interface{}{}
+		}
+		*data, _ = json.Marshal(section)
+	}
+
+	var validationErr *questionnaire.ValidationError
+	if err := validateApplicationData(result.req, jsonschema.Partial); errors.As(err, &validationErr) {
+		for _, fieldError := range validationErr.Errors {
+			importError := ImportError{Line: row.Line, Field: fieldError.Path, Message: fieldError.Message}
+			if column, ok := byPath[fieldError.Path]; ok {
+				importError.Column = column.header
+			}
+			result.errors = append(result.errors, importError)
+		}
+	}
+	return result
+}
+
+// resolveImportClient определяет клиента заявки по ИНН или ID с учетом
+// доступа пользователя
+func resolveImportClient(c *gin.Context, row *importRow) (uint, bool) {
+	if row.clientINN == "" {
+		return resolveApplicationClient(c, row.req.ClientID)
+	}
+
+	var ids []uint
+	db.Table("clients").Scopes(scopeClients(c)).Where("inn = ?", row.clientINN).Limit(1).Pluck("id", &ids)
+	if len(ids) == 0 {
+		return 0, false
+	}
+	return ids[0], true
+}
+
+// ImportApplications создает черновики заявок из файла CSV или XLSX.
+// Параметры формы: file, mode (atomic или partial), mapping (JSON-объект
+// "заголовок столбца": "поле заявки"), type и clientId - значения для строк
+// без соответствующих столбцов.
+func ImportApplications(c *gin.Context) {
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Файл не найден"})
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxImportFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Файл слишком большой"})
+		return
+	}
+	format, err := importer.Format(header.Filename)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mode := c.DefaultPostForm("mode", importAtomic)
+	if mode != importAtomic && mode != importPartial {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Режим импорта должен быть atomic или partial"})
+		return
+	}
+
+	mapping := map[string]string{}
+	if raw := c.PostForm("mapping"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректное сопоставление столбцов"})
+			return
+		}
+	}
+
+	defaults := CreateApplicationRequest{Type: c.PostForm("type")}
+	if clientID := c.PostForm("clientId"); clientID != "" {
+		id, err := strconv.ParseUint(clientID, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID клиента"})
+			return
+		}
+		defaults.ClientID = uint(id)
+	}
+
+	table, err := importer.Read(header.Filename, file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	columns, ignored, err := resolveImportColumns(table.Header, mapping)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	actorID := currentActor(c).UserID
+	job := ImportJob{
+		UserID:    actorID,
+		FileName:  header.Filename,
+		Format:    format,
+		Mode:      mode,
+		TotalRows: len(table.Rows),
+	}
+
+	// Разбор и проверка всех строк до записи в базу данных
+	rule := duplicateSettings()
+	var drafts []Application
+	var inns []string
+	var lines []int
+	for _, row := range table.Rows {
+		parsed := parseImportRow(row, columns, defaults)
+		clientID, ok := resolveImportClient(c, parsed)
+		if !ok {
+			parsed.errors = append(parsed.errors, ImportError{Line: row.Line, Column: parsed.clientColumn, Field: "client", Message: "клиент не найден или нет доступа"})
+		} else if clientID == 0 {
+			parsed.errors = append(parsed.errors, ImportError{Line: row.Line, Field: "client", Message: "не указан клиент: укажите ИНН или ID клиента"})
+		}
+		if len(parsed.errors) > 0 {
+			job.Errors = append(job.Errors, parsed.errors...)
+			continue
+		}
+
+		draft := newDraft(parsed.req, clientID, actorID)
+		inn, duplicate := importDuplicate(c, draft, rule, drafts, inns, lines)
+		if duplicate != "" {
+			job.Errors = append(job.Errors, ImportError{Line: row.Line, Field: importFieldType, Message: duplicate})
+			continue
+		}
+		drafts = append(drafts, draft)
+		inns = append(inns, inn)
+		lines = append(lines, row.Line)
+	}
+
+	comment := "Заявка импортирована из файла " + header.Filename
+	var created []Application
+	switch {
+	case mode == importAtomic && len(job.Errors) > 0:
+		// Ни одна заявка не создается
+	case mode == importAtomic:
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for i := range drafts {
+				if err := createDraft(tx, &drafts[i], comment); err != nil {
+					return fmt.Errorf("строка %d: %w", lines[i], err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка импорта заявок: " + err.Error()})
+			return
+		}
+		created = drafts
+	default:
+		for i := range drafts {
+			err := db.Transaction(func(tx *gorm.DB) error {
+				return createDraft(tx, &drafts[i], comment)
+			})
+			if err != nil {
+				job.Errors = append(job.Errors, ImportError{Line: lines[i], Message: "ошибка сохранения заявки"})
+				continue
+			}
+			created = append(created, drafts[i])
+		}
+	}
+
+	job.CreatedCount = len(created)
+	job.ErrorCount = countErrorLines(job.Errors)
+	switch {
+	case len(created) == 0 && job.ErrorCount > 0:
+		job.Status = importRejected
+	case job.ErrorCount > 0:
+		job.Status = importPartially
+	default:
+		job.Status = importCompleted
+	}
+	if err := db.Create(&job).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения результата импорта"})
+		return
+	}
+
+	ids := make([]uint, len(created))
+	for i := range created {
+		ids[i] = created[i].ID
+		recordAudit(c, "application.create", "application", created[i].ID, nil, created[i].maskedPII())
+	}
+	recordAudit(c, "application.import", "import_job", job.ID, nil, gin.H{
+		"file_name": job.FileName, "mode": job.Mode, "status": job.Status,
+		"created_count": job.CreatedCount, "error_count": job.ErrorCount,
+	})
+
+	status := http.StatusCreated
+	if len(created) == 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	c.JSON(status, gin.H{
+		"job":             job,
+		"application_ids": ids,
+		"ignored_columns": ignored,
+		"report_url":      fmt.Sprintf("/api/applications/imports/%d/report", job.ID),
+	})
+}
+
+// countErrorLines считает строки файла с ошибками
+func countErrorLines(importErrors []ImportError) int {
+	lines := make(map[int]bool)
+	for _, importError := range importErrors {
+		lines[importError.Line] = true
+	}
+	return len(lines)
+}
+
+// findImportJob загружает импорт, доступный текущему пользователю.
+// При ошибке ответ уже отправлен клиенту.
+func findImportJob(c *gin.Context, job *ImportJob) bool {
+	query := db.Preload("Errors", func(tx *gorm.DB) *gorm.DB { return tx.Order("line, id") })
+	if a := currentActor(c); !a.can("view_all_applications") {
+		query = query.Where("user_id = ?", a.UserID)
+	}
+	if err := query.First(job, c.Param("importId")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Импорт не найден"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения импорта"})
+		}
+		return false
+	}
+	return true
+}
+
+// GetImportJob возвращает результат импорта с ошибками по строкам
+func GetImportJob(c *gin.Context) {
+	var job ImportJob
+	if !findImportJob(c, &job) {
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// DownloadImportReport отдает отчет об ошибках импорта в CSV (для Excel)
+func DownloadImportReport(c *gin.Context) {
+	var job ImportJob
+	if !findImportJob(c, &job) {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := writeImportReport(&buf, job.Errors); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка формирования отчета"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=import_%d_errors.csv", job.ID))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+// writeImportReport пишет ошибки импорта в CSV. Столбец и сообщение берутся
+// из загруженного файла, поэтому текст, похожий на формулу, экранируется так
+// же, как в выгрузках.
+func writeImportReport(w io.Writer, importErrors []ImportError) error {
+	writer, err := export.New("csv", w, 1)
+	if err != nil {
+		return err
+	}
+	if err := writer.AddSheet("errors", []string{"Строка", "Столбец", "Поле", "Ошибка"}); err != nil {
+		return err
+	}
+	for _, importError := range importErrors {
+		if err := writer.WriteRow([]interface{}{importError.Line, importError.Column, importError.Field, importError.Message}); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"tenderhelp/internal/adapters"
 	"tenderhelp/internal/queue"
 	"time"
@@ -38,20 +39,22 @@ func SendApplicationToBanks(c *gin.Context) {
 
 	// Получение заявки из базы данных
 	var application Application
-	if err := db.First(&application, applicationID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Заявка не найдена"})
+	if !findScopedApplication(c, applicationID, &application) {
 		return
 	}
 
-	// Подготовка данных для отправки
-	applicationData := adapters.ApplicationData{
-		ID:          fmt.Sprintf("%d", application.ID),
-		Type:        application.Type,
-		Amount:      application.Amount,
-		ClientData:  application.PersonalData, // Упрощение для примера
-		ScoringData: json.RawMessage(`{"score": 85.5, "risk_class": "A"}`),
-		CreatedAt:   application.CreatedAt,
+	// Отправлять можно только одобренную заявку
+	if !checkApplicationTransition(c, &application, eventSendToBanks) {
+		return
+	}
+
+	// Подготовка данных для отправки: банкам уходит результат последнего скоринга
+	scoringData, ok := latestScoringData(&application)
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "Заявка не прошла скоринг"})
+		return
 	}
+	applicationData := bankApplicationData(&application, scoringData)
 
 	// Получение списка банков для отправки
 	bankIDs := c.QueryArray("banks")
@@ -70,19 +73,38 @@ func SendApplicationToBanks(c *gin.Context) {
 		return
 	}
 
-	// Обновление статуса заявки
-	application.Status = "sent_to_banks"
-	application.UpdatedAt = time.Now()
-	db.Save(&application)
+	// Фиксация маршрутов: банк получает доступ к заявке только после успешной отправки
+	var routedBanks []string
+	for _, response := range responses {
+		if !response.Success {
+			continue
+		}
+		routedBanks = append(routedBanks, response.BankID)
+		db.Create(&ApplicationRoute{
+			ApplicationID: application.ID,
+			BankID:        response.BankID,
+			Status:        response.Status,
+			Message:       response.Message,
+		})
+	}
+
+	// Ни один банк не принял заявку: статус не меняется
+	if len(routedBanks) == 0 {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error":     "Ни один банк не принял заявку",
+			"responses": responses,
+		})
+		return
+	}
 
-	// Добавление записи в историю
-	statusHistory := StatusHistory{
-		ApplicationID: application.ID,
-		Status:        "sent_to_banks",
-		Timestamp:     time.Now(),
-		Comment:       "Заявка отправлена в банки",
+	// Обновление статуса заявки
+	before := application
+	if !transitionApplication(c, &application, eventSendToBanks, "Заявка отправлена в банки: "+strings.Join(routedBanks, ", ")) {
+		return
 	}
-	db.Create(&statusHistory)
+
+	recordAudit(c, "application.send_to_banks", "application", application.ID,
+		gin.H{"status": before.Status}, gin.H{"status": application.Status, "banks": routedBanks})
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "Заявка отправлена в банки",
@@ -90,6 +112,35 @@ func SendApplicationToBanks(c *gin.Context) {
 	})
 }
 
+// latestScoringData результат последнего скоринга заявки в том же виде, что
+// отправляет автоматическая обработка. Если запуска обработки со скорингом
+// нет, берется класс риска ручного скоринга; false - заявку не оценивали.
+func latestScoringData(application *Application) (json.RawMessage, bool) {
+	var run PipelineRun
+	err := db.Where("application_id = ? AND risk_class <> ''", application.ID).Order("id DESC").First(&run).Error
+	if err == nil {
+		data, _ := json.Marshal(gin.H{"risk_class": run.RiskClass, "rule_set_id": run.RuleSetID})
+		return data, true
+	}
+	if application.RiskClass == "" {
+		return nil, false
+	}
+	data, _ := json.Marshal(gin.H{"risk_class": application.RiskClass})
+	return data, true
+}
+
+// bankApplicationData данные заявки для адаптеров банков
+func bankApplicationData(application *Application, scoringData json.RawMessage) adapters.ApplicationData {
+	return adapters.ApplicationData{
+		ID:          fmt.Sprintf("%d", application.ID),
+		Type:        application.Type,
+		Amount:      application.Amount,
+		ClientData:  application.PersonalData, // Упрощение для примера
+		ScoringData: scoringData,
+		CreatedAt:   application.CreatedAt,
+	}
+}
+
 // GetBankSummary возвращает сводку по банкам
 func GetBankSummary(c *gin.Context) {
 	summary := adapterManager.GetBankSummary()
@@ -133,6 +184,11 @@ func GetApplicationStatusFromBank(c *gin.Context) {
 	applicationID := c.Param("id")
 	bankID := c.Param("bankId")
 
+	var application Application
+	if !findScopedApplication(c, applicationID, &application) {
+		return
+	}
+
 	// Получение адаптера банка
 	adapter, err := adapterManager.GetAdapter(bankID)
 	if err != nil {
@@ -216,8 +272,7 @@ func GetBankResponses(c *gin.Context) {
 
 	// Получение заявки
 	var application Application
-	if err := db.First(&application, applicationID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Заявка не найдена"})
+	if !findScopedApplication(c, applicationID, &application) {
 		return
 	}
 
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"tenderhelp/internal/auth"
+	"tenderhelp/internal/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// TrustedProxies адреса и подсети прокси (TRUSTED_PROXIES через запятую),
+// от которых принимается адрес клиента из X-Forwarded-For. По умолчанию прокси
+// не доверяются и блокировки по IP используют адрес соединения: иначе клиент
+// обходит блокировку, подставляя заголовок.
+func TrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// LoginThrottle счетчик неудачных попыток входа для учетной записи или IP
+type LoginThrottle struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	Key           string     `json:"key" gorm:"column:throttle_key;uniqueIndex"` // account:<email> или ip:<адрес>
+	Failures      int        `json:"failures"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// SecurityEvent событие безопасности (блокировки, разблокировки)
+type SecurityEvent struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Type      string    `json:"type" gorm:"index"`
+	UserID    uint      `json:"user_id" gorm:"index"`
+	ActorID   uint      `json:"actor_id"`
+	Email     string    `json:"email"`
+	IP        string    `json:"ip"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// Типы событий безопасности
+const (
+	securityEventAccountLocked = "login_account_locked"
+	securityEventIPLocked      = "login_ip_locked"
+	securityEventUnlocked      = "login_unlocked"
+	securityEventAuditBroken   = "audit_chain_broken"
+)
+
+// accountThrottleKey ключ счетчика учетной записи. Используется email, а не
+// ID, чтобы попытки входа в несуществующие аккаунты учитывались так же
+// и по ответам нельзя было определить, зарегистрирован ли адрес.
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// ipThrottleKey ключ счетчика IP-адреса
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// throttleLockedUntil возвращает время окончания блокировки, если ключ заблокирован
+func throttleLockedUntil(key string) (time.Time, bool) {
+	var throttle LoginThrottle
+	if err := db.Where("throttle_key = ?", key).First(&throttle).Error; err != nil {
+		return time.Time{}, false
+	}
+	if throttle.LockedUntil == nil || !time.Now().Before(*throttle.LockedUntil) {
+		return time.Time{}, false
+	}
+	return *throttle.LockedUntil, true
+}
+
+// checkLoginThrottle отвечает 429, если вход с этого адреса или в эту
+// учетную запись временно заблокирован
+func checkLoginThrottle(c *gin.Context, email string) bool {
+	for _, key := range []string{ipThrottleKey(c.ClientIP()), accountThrottleKey(email)} {
+		if lockedUntil, locked := throttleLockedUntil(key); locked {
+			respondLoginLocked(c, lockedUntil)
+			return false
+		}
+	}
+	return true
+}
+
+// respondLoginLocked отвечает 429 с заголовком Retry-After
+func respondLoginLocked(c *gin.Context, lockedUntil time.Time) {
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":        "Слишком много неудачных попыток входа. Повторите позже",
+		"locked_until": lockedUntil,
+	})
+}
+
+// registerLoginFailure учитывает неудачную попытку входа для учетной записи
+// и адреса. При достижении порога включается блокировка и пишется событие.
+func registerLoginFailure(c *gin.Context, email string) {
+	ip := c.ClientIP()
+
+	if until, locked := incrementThrottle(accountThrottleKey(email), auth.AccountLockoutPolicy); locked {
+		var user models.User
+		db.Select("id").Where("email = ?", strings.ToLower(email)).First(&user)
+		recordSecurityEvent(SecurityEvent{
+			Type:    securityEventAccountLocked,
+			UserID:  user.ID,
+			Email:   email,
+			IP:      ip,
+			Details: fmt.Sprintf("Вход заблокирован до %s", until.Format(time.RFC3339)),
+		})
+	}
+
+	if until, locked := incrementThrottle(ipThrottleKey(ip), auth.IPLockoutPolicy); locked {
+		recordSecurityEvent(SecurityEvent{
+			Type:    securityEventIPLocked,
+			Email:   email,
+			IP:      ip,
+			Details: fmt.Sprintf("Вход с адреса заблокирован до %s", until.Format(time.RFC3339)),
+		})
+	}
+}
+
+// incrementThrottle увеличивает счетчик неудачных попыток и возвращает
+// время окончания блокировки, если она включилась этой попыткой
+func incrementThrottle(key string, policy auth.LockoutPolicy) (time.Time, bool) {
+	now := time.Now()
+	var lockedUntil time.Time
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		throttle := LoginThrottle{Key: key}
+		if err := tx.Where(LoginThrottle{Key: key}).FirstOrCreate(&throttle).Error; err != nil {
+			return err
+		}
+
+		// Давние неудачи не учитываются
+		if !throttle.LastFailureAt.IsZero() && now.Sub(throttle.LastFailureAt) > policy.ResetAfter {
+			throttle.Failures = 0
+		}
+
+		throttle.Failures++
+		throttle.LastFailureAt = now
+		if delay := policy.LockDuration(throttle.Failures); delay > 0 {
+			lockedUntil = now.Add(delay)
+			throttle.LockedUntil = &lockedUntil
+		}
+
+		return tx.Save(&throttle).Error
+	})
+	if err != nil {
+		log.Printf("Ошибка учета неудачной попытки входа: %v", err)
+		return time.Time{}, false
+	}
+
+	return lockedUntil, !lockedUntil.IsZero()
+}
+
+// resetLoginThrottle сбрасывает счетчик учетной записи после успешного входа.
+// Счетчик IP не сбрасывается: иначе подбор по многим аккаунтам можно
+// маскировать входом в собственный.
+func resetLoginThrottle(email string) {
+	db.Where("throttle_key = ?", accountThrottleKey(email)).Delete(&LoginThrottle{})
+}
+
+// recordSecurityEvent сохраняет событие безопасности
+func recordSecurityEvent(event SecurityEvent) {
+	event.CreatedAt = time.Now()
+	if err := db.Create(&event).Error; err != nil {
+		log.Printf("Ошибка записи события безопасности %s: %v", event.Type, err)
+	}
+}
+
+// UnlockUser снимает блокировку входа с учетной записи
+func UnlockUser(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID пользователя"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	resetLoginThrottle(user.Email)
+	recordSecurityEvent(SecurityEvent{
+		Type:    securityEventUnlocked,
+		UserID:  user.ID,
+		ActorID: currentActor(c).UserID,
+		Email:   user.Email,
+		IP:      c.ClientIP(),
+		Details: "Блокировка учетной записи снята администратором",
+	})
+	recordAudit(c, "user.unlock", "user", user.ID, nil, gin.H{"locked": false})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Блокировка снята"})
+}
+
+// GetLoginLocks возвращает действующие блокировки входа
+func GetLoginLocks(c *gin.Context) {
+	var throttles []LoginThrottle
+	if err := db.Where("locked_until > ?", time.Now()).Order("locked_until DESC").Find(&throttles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения блокировок"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"locks": throttles,
+		"total": len(throttles),
+	})
+}
+
+// DeleteLoginLock снимает блокировку по ID (например, блокировку IP-адреса офиса)
+func DeleteLoginLock(c *gin.Context) {
+	var throttle LoginThrottle
+	if err := db.First(&throttle, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Блокировка не найдена"})
+		return
+	}
+
+	if err := db.Delete(&throttle).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка снятия блокировки"})
+		return
+	}
+
+	recordSecurityEvent(SecurityEvent{
+		Type:    securityEventUnlocked,
+		ActorID: currentActor(c).UserID,
+		IP:      c.ClientIP(),
+		Details: "Снята блокировка " + throttle.Key,
+	})
+	recordAudit(c, "login_lock.delete", "login_lock", throttle.ID, throttle, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Блокировка снята"})
+}
+
+// GetSecurityEvents возвращает события безопасности с фильтрацией
+func GetSecurityEvents(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	query := db.Model(&SecurityEvent{})
+	if eventType := c.Query("type"); eventType != "" {
+		query = query.Where("type = ?", eventType)
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if ip := c.Query("ip"); ip != "" {
+		query = query.Where("ip = ?", ip)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var events []SecurityEvent
+	if err := query.Order("created_at DESC").Limit(limit).Offset((page - 1) * limit).Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения событий"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"total":  total,
+		"page":   page,
+		"limit":  limit,
+	})
+}
@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"tenderhelp/internal/auth"
+	"tenderhelp/internal/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RecoveryCode одноразовый код восстановления доступа при потере устройства
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"index"`
+	CodeHash  string     `json:"-" gorm:"index"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// LoginMFARequest второй шаг входа
+type LoginMFARequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// TOTPCodeRequest запрос с кодом из приложения-аутентификатора
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// mfaChallengeResponse ответ на вход, требующий второго фактора
+// или подключения 2FA
+type mfaChallengeResponse struct {
+	MFARequired        bool      `json:"mfa_required,omitempty"`
+	EnrollmentRequired bool      `json:"mfa_enrollment_required,omitempty"`
+	Token              string    `json:"mfa_token"`
+	ExpiresAt          time.Time `json:"expires_at"`
+}
+
+// mfaActivationResponse ответ на подключение 2FA. При обязательном
+// подключении во время входа содержит также токены новой сессии.
+type mfaActivationResponse struct {
+	*tokenPair
+	User          *models.User `json:"user,omitempty"`
+	RecoveryCodes []string     `json:"recovery_codes"`
+}
+
+// mfaTokenTTL срок действия токена между вводом пароля и кода
+const mfaTokenTTL = 5 * time.Minute
+
+// mfaEnrollTokenTTL срок действия токена обязательного подключения 2FA
+const mfaEnrollTokenTTL = 15 * time.Minute
+
+// recoveryCodeCount количество выдаваемых кодов восстановления
+const recoveryCodeCount = 10
+
+// completeLogin завершает вход после проверки пароля: при подключенной 2FA
+// запрашивает код, при обязательной, но не подключенной - подключение,
+// иначе создает сессию
+func completeLogin(c *gin.Context, user *models.User, status int) {
+	security := loadUserSecurity(user.ID)
+
+	switch {
+	case security.TOTPEnabled:
+		respondMFAChallenge(c, user, auth.PurposeMFA, mfaTokenTTL)
+	case rolePermissions.requiresMFA(user.Role):
+		respondMFAChallenge(c, user, auth.PurposeMFAEnroll, mfaEnrollTokenTTL)
+	default:
+		// Счетчик неудач сбрасывается только после полного входа, иначе
+		// знание пароля позволило бы перебирать код 2FA без ограничений
+		resetLoginThrottle(user.Email)
+
+		tokens, err := startSession(c, user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания токена"})
+			return
+		}
+		c.JSON(status, loginResponse{
+			tokenPair: *tokens,
+			User:      *user,
+		})
+	}
+}
+
+// respondMFAChallenge выдает короткоживущий токен для следующего шага входа
+func respondMFAChallenge(c *gin.Context, user *models.User, purpose string, ttl time.Duration) {
+	token, expiresAt, err := tokenManager.GenerateWithTTL(auth.Claims{
+		UserID:  user.ID,
+		Role:    user.Role,
+		Purpose: purpose,
+	}, ttl)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания токена"})
+		return
+	}
+
+	c.JSON(http.StatusOK, mfaChallengeResponse{
+		MFARequired:        purpose == auth.PurposeMFA,
+		EnrollmentRequired: purpose == auth.PurposeMFAEnroll,
+		Token:              token,
+		ExpiresAt:          expiresAt,
+	})
+}
+
+// verifyTOTP проверяет код и запоминает его шаг, чтобы код нельзя было
+// использовать повторно (в том числе параллельными запросами)
+func verifyTOTP(security *UserSecurity, code string) bool {
+	if security.TOTPSecret == "" {
+		return false
+	}
+
+	step, ok := auth.ValidateTOTP(security.TOTPSecret, code, security.LastTOTPStep, time.Now())
+	if !ok {
+		return false
+	}
+
+	result := db.Model(&UserSecurity{}).
+		Where("user_id = ? AND last_totp_step < ?", security.UserID, step).
+		Update("last_totp_step", step)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+	security.LastTOTPStep = step
+	return true
+}
+
+// useRecoveryCode погашает код восстановления
+func useRecoveryCode(userID uint, code string) bool {
+	result := db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, auth.HashToken(auth.NormalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected > 0
+}
+
+// replaceRecoveryCodes выдает новый набор кодов восстановления взамен прежнего
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	codes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	records := make([]RecoveryCode, len(codes))
+	for i, code := range codes {
+		records[i] = RecoveryCode{UserID: userID, CodeHash: auth.HashToken(code)}
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// clearTOTP отключает 2FA пользователя и удаляет коды восстановления
+func clearTOTP(userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := updateUserSecurity(tx, userID, map[string]interface{}{
+			"totp_secret":       "",
+			"totp_enabled":      false,
+			"totp_confirmed_at": nil,
+		}); err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+}
+
+// LoginMFA второй шаг входа: проверка кода TOTP или кода восстановления
+func LoginMFA(c *gin.Context) {
+	var req LoginMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+
+	user, ok := mfaTokenUser(c, req.MFAToken, auth.PurposeMFA)
+	if !ok || !checkSecondFactor(c, user, req) {
+		return
+	}
+
+	tokens, err := startSession(c, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания токена"})
+		return
+	}
+
+	c.JSON(http.StatusOK, loginResponse{
+		tokenPair: *tokens,
+		User:      *user,
+	})
+}
+
+// mfaTokenUser возвращает пользователя из токена второго шага входа
+func mfaTokenUser(c *gin.Context, token, purpose string) (*models.User, bool) {
+	claims, err := tokenManager.Parse(token)
+	if err != nil || claims.Purpose != purpose {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Недействительный токен"})
+		return nil, false
+	}
+
+	var user models.User
+	if err := db.First(&user, claims.UserID).Error; err != nil || !user.IsActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Аккаунт заблокирован"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// checkSecondFactor проверяет код TOTP или код восстановления. Подбор кода
+// ограничивается теми же счетчиками, что и подбор пароля.
+func checkSecondFactor(c *gin.Context, user *models.User, req LoginMFARequest) bool {
+	if !checkLoginThrottle(c, user.Email) {
+		return false
+	}
+
+	security := loadUserSecurity(user.ID)
+	verified := false
+	switch {
+	case req.Code != "":
+		verified = security.TOTPEnabled && verifyTOTP(&security, req.Code)
+	case req.RecoveryCode != "":
+		verified = security.TOTPEnabled && useRecoveryCode(user.ID, req.RecoveryCode)
+	}
+	if !verified {
+		registerLoginFailure(c, user.Email)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный код подтверждения"})
+		return false
+	}
+
+	resetLoginThrottle(user.Email)
+	return true
+}
+
+// GetTOTPStatus возвращает состояние 2FA текущего пользователя
+func GetTOTPStatus(c *gin.Context) {
+	claims, _ := currentClaims(c)
+	security := loadUserSecurity(claims.UserID)
+
+	var recoveryCodesLeft int64
+	db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", claims.UserID).Count(&recoveryCodesLeft)
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":             security.TOTPEnabled,
+		"required":            rolePermissions.requiresMFA(claims.Role),
+		"confirmed_at":        security.TOTPConfirmedAt,
+		"recovery_codes_left": recoveryCodesLeft,
+	})
+}
+
+// EnrollTOTP начинает подключение 2FA: выдает секрет, URI и QR-код для
+// приложения-аутентификатора. 2FA включается только после ActivateTOTP.
+func EnrollTOTP(c *gin.Context) {
+	claims, _ := currentClaims(c)
+
+	var user models.User
+	if err := db.First(&user, claims.UserID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	if loadUserSecurity(user.ID).TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Двухфакторная аутентификация уже подключена"})
+		return
+	}
+
+	key, err := auth.NewTOTPKey(user.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка подключения 2FA"})
+		return
+	}
+
+	qrCode, err := auth.TOTPQRCode(key.URL, 200)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка подключения 2FA"})
+		return
+	}
+
+	if err := updateUserSecurity(db, user.ID, map[string]interface{}{
+		"totp_secret":    key.Secret,
+		"totp_enabled":   false,
+		"last_totp_step": 0,
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка подключения 2FA"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      key.Secret,
+		"otpauth_url": key.URL,
+		"qr_code":     qrCode,
+	})
+}
+
+// ActivateTOTP подтверждает подключение 2FA кодом из приложения и выдает
+// коды восстановления. Если подключение выполнялось во время входа,
+// дополнительно создается сессия.
+func ActivateTOTP(c *gin.Context) {
+	claims, _ := currentClaims(c)
+
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+
+	security := loadUserSecurity(claims.UserID)
+	if security.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Двухфакторная аутентификация уже подключена"})
+		return
+	}
+	if security.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Сначала начните подключение 2FA"})
+		return
+	}
+
+	if !verifyTOTP(&security, req.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный код подтверждения"})
+		return
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := updateUserSecurity(tx, claims.UserID, map[string]interface{}{
+			"totp_enabled":      true,
+			"totp_confirmed_at": time.Now(),
+		}); err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, claims.UserID)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка подключения 2FA"})
+		return
+	}
+
+	response := mfaActivationResponse{RecoveryCodes: codes}
+
+	if claims.Purpose == auth.PurposeMFAEnroll {
+		var user models.User
+		if err := db.First(&user, claims.UserID).Error; err != nil || !user.IsActive {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Аккаунт заблокирован"})
+			return
+		}
+
+		tokens, err := startSession(c, &user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания токена"})
+			return
+		}
+		response.tokenPair = tokens
+		response.User = &user
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RegenerateRecoveryCodes выдает новый набор кодов восстановления
+func RegenerateRecoveryCodes(c *gin.Context) {
+	claims, _ := currentClaims(c)
+
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+
+	security := loadUserSecurity(claims.UserID)
+	if !security.TOTPEnabled || !verifyTOTP(&security, req.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный код подтверждения"})
+		return
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, claims.UserID)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания кодов восстановления"})
+		return
+	}
+
+	c.JSON(http.StatusOK, mfaActivationResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP отключает 2FA, если она не обязательна для роли
+func DisableTOTP(c *gin.Context) {
+	claims, _ := currentClaims(c)
+
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+
+	if rolePermissions.requiresMFA(claims.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Для вашей роли двухфакторная аутентификация обязательна"})
+		return
+	}
+
+	security := loadUserSecurity(claims.UserID)
+	if !security.TOTPEnabled || !verifyTOTP(&security, req.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный код подтверждения"})
+		return
+	}
+
+	if err := clearTOTP(claims.UserID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отключения 2FA"})
+		return
+	}
+	recordAudit(c, "user.totp_disable", "user", claims.UserID, gin.H{"totp_enabled": true}, gin.H{"totp_enabled": false})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Двухфакторная аутентификация отключена"})
+}
+
+// ResetUserTOTP сбрасывает 2FA пользователя (например, при потере телефона).
+// Сессии пользователя завершаются; при обязательной 2FA он подключит ее
+// заново при следующем входе.
+func ResetUserTOTP(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID пользователя"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	if err := clearTOTP(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сброса 2FA"})
+		return
+	}
+
+	if _, err := revokeUserSessions(user.ID, revokeReasonAdmin); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения сессий"})
+		return
+	}
+
+	recordAudit(c, "user.totp_reset", "user", user.ID, nil, gin.H{"totp_enabled": false})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Двухфакторная аутентификация пользователя сброшена"})
+}
@@ -45,5 +45,6 @@ func CreateNews(c *gin.Context) {
 	}
 
 	db.Create(&news)
+	recordAudit(c, "news.create", "news", news.ID, nil, news)
 	c.JSON(http.StatusCreated, news)
 }
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Типы уведомлений
+const notificationMention = "comment.mention"
+
+// Notification уведомление пользователя в приложении
+type Notification struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	UserID        uint       `json:"user_id" gorm:"index"`
+	Type          string     `json:"type"`
+	ApplicationID uint       `json:"application_id,omitempty"`
+	CommentID     uint       `json:"comment_id,omitempty"`
+	ActorID       uint       `json:"actor_id"` // пользователь, действие которого вызвало уведомление
+	Message       string     `json:"message"`
+	ReadAt        *time.Time `json:"read_at"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"index"`
+}
+
+// notify создает уведомление для каждого пользователя из userIDs
+func notify(tx *gorm.DB, userIDs []uint, notification Notification) error {
+	for _, userID := range userIDs {
+		n := notification
+		n.UserID = userID
+		if err := tx.Create(&n).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetNotifications возвращает уведомления текущего пользователя, новые
+// первыми. Параметр unread=true оставляет только непрочитанные.
+func GetNotifications(c *gin.Context) {
+	userID := currentActor(c).UserID
+	query := db.Where("user_id = ?", userID)
+	if c.Query("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var notifications []Notification
+	if err := query.Order("created_at DESC, id DESC").Limit(100).Find(&notifications).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения уведомлений"})
+		return
+	}
+
+	var unread int64
+	db.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&unread)
+
+	c.JSON(http.StatusOK, gin.H{"notifications": notifications, "unread": unread})
+}
+
+// MarkNotificationRead отмечает уведомление прочитанным
+func MarkNotificationRead(c *gin.Context) {
+	var notification Notification
+	if err := db.Where("id = ? AND user_id = ?", c.Param("notificationId"), currentActor(c).UserID).
+		First(&notification).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Уведомление не найдено"})
+		return
+	}
+
+	if notification.ReadAt == nil {
+		now := time.Now()
+		if err := db.Model(&notification).Update("read_at", now).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления уведомления"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, notification)
+}
+
+// MarkAllNotificationsRead отмечает прочитанными все уведомления пользователя
+func MarkAllNotificationsRead(c *gin.Context) {
+	result := db.Model(&Notification{}).
+		Where("user_id = ? AND read_at IS NULL", currentActor(c).UserID).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления уведомлений"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Уведомления прочитаны", "count": result.RowsAffected})
+}
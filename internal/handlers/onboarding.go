@@ -0,0 +1,427 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"tenderhelp/internal/auth"
+	"tenderhelp/internal/mailer"
+	"tenderhelp/internal/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// UserSecurity параметры безопасности учетной записи
+type UserSecurity struct {
+	ID                 uint       `json:"id" gorm:"primaryKey"`
+	UserID             uint       `json:"user_id" gorm:"uniqueIndex"`
+	MustChangePassword bool       `json:"must_change_password"`
+	PasswordChangedAt  *time.Time `json:"password_changed_at,omitempty"`
+
+	// Двухфакторная аутентификация (TOTP)
+	TOTPSecret      string     `json:"-"`
+	TOTPEnabled     bool       `json:"totp_enabled"`
+	TOTPConfirmedAt *time.Time `json:"totp_confirmed_at,omitempty"`
+	LastTOTPStep    int64      `json:"-"` // защита от повторного использования кода
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Invitation одноразовое приглашение для установки пароля
+type Invitation struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	UserID      uint       `json:"user_id" gorm:"index"`
+	TokenHash   string     `json:"-" gorm:"uniqueIndex"`
+	CreatedByID uint       `json:"created_by_id"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// AcceptInvitationRequest запрос на принятие приглашения
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// ChangePasswordRequest запрос на смену пароля
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// passwordChangeResponse ответ на вход пользователя, обязанного сменить пароль
+type passwordChangeResponse struct {
+	PasswordChangeRequired bool      `json:"password_change_required"`
+	Token                  string    `json:"password_change_token"`
+	ExpiresAt              time.Time `json:"expires_at"`
+}
+
+// invitationTTL срок действия ссылки-приглашения
+const invitationTTL = 72 * time.Hour
+
+// passwordChangeTokenTTL срок действия токена для обязательной смены пароля
+const passwordChangeTokenTTL = 10 * time.Minute
+
+// revokeReasonPasswordChanged причина отзыва сессий при смене пароля
+const revokeReasonPasswordChanged = "password_changed"
+
+// mail отправитель писем с приглашениями
+var mail mailer.Mailer = mailer.LogMailer{}
+
+// SetMailer устанавливает отправителя писем
+func SetMailer(m mailer.Mailer) {
+	mail = m
+}
+
+// appBaseURL адрес фронтенда для ссылок в письмах
+func appBaseURL() string {
+	if base := os.Getenv("APP_BASE_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	return "http://localhost:3000"
+}
+
+// loadUserSecurity возвращает параметры безопасности пользователя
+// (пустые, если запись еще не создана)
+func loadUserSecurity(userID uint) UserSecurity {
+	var security UserSecurity
+	if err := db.Where("user_id = ?", userID).First(&security).Error; err != nil {
+		return UserSecurity{UserID: userID}
+	}
+	return security
+}
+
+// updateUserSecurity обновляет параметры безопасности, создавая запись при необходимости
+func updateUserSecurity(tx *gorm.DB, userID uint, values map[string]interface{}) error {
+	security := UserSecurity{UserID: userID}
+	return tx.Where(UserSecurity{UserID: userID}).Assign(values).FirstOrCreate(&security).Error
+}
+
+// mustChangePassword проверяет, обязан ли пользователь сменить пароль
+func mustChangePassword(userID uint) bool {
+	return loadUserSecurity(userID).MustChangePassword
+}
+
+// setMustChangePassword устанавливает или снимает требование смены пароля
+func setMustChangePassword(tx *gorm.DB, userID uint, required bool) error {
+	values := map[string]interface{}{"must_change_password": required}
+	if !required {
+		values["password_changed_at"] = time.Now()
+	}
+	return updateUserSecurity(tx, userID, values)
+}
+
+// issueInvitation создает новое приглашение; ранее выданные неиспользованные
+// приглашения пользователя перестают действовать
+func issueInvitation(user *models.User, createdByID uint) (*Invitation, string, error) {
+	token, err := auth.RandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	invitation := Invitation{
+		UserID:      user.ID,
+		TokenHash:   auth.HashToken(token),
+		CreatedByID: createdByID,
+		ExpiresAt:   now.Add(invitationTTL),
+		CreatedAt:   now,
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Invitation{}).
+			Where("user_id = ? AND used_at IS NULL AND expires_at > ?", user.ID, now).
+			Update("expires_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&invitation).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &invitation, token, nil
+}
+
+// sendInvitation отправляет пользователю ссылку для установки пароля
+func sendInvitation(user *models.User, token string, expiresAt time.Time) error {
+	link := fmt.Sprintf("%s/invite?token=%s", appBaseURL(), url.QueryEscape(token))
+	body := fmt.Sprintf(
+		"Здравствуйте, %s!\n\nДля вас создана учетная запись в Brokerum.\n"+
+			"Чтобы задать пароль, перейдите по ссылке:\n%s\n\n"+
+			"Ссылка одноразовая и действует до %s.\n",
+		user.Name, link, expiresAt.Format("02.01.2006 15:04"))
+
+	return mail.Send(user.Email, "Приглашение в Brokerum", body)
+}
+
+// inviteUser создает приглашение и отправляет его. Возвращает срок действия
+// приглашения и признак успешной отправки письма.
+func inviteUser(user *models.User, createdByID uint) (time.Time, bool, error) {
+	invitation, token, err := issueInvitation(user, createdByID)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	if err := sendInvitation(user, token, invitation.ExpiresAt); err != nil {
+		log.Printf("Не удалось отправить приглашение пользователю %d: %v", user.ID, err)
+		return invitation.ExpiresAt, false, nil
+	}
+	return invitation.ExpiresAt, true, nil
+}
+
+// startPasswordChange выдает токен, позволяющий только сменить пароль
+func startPasswordChange(user *models.User) (*passwordChangeResponse, error) {
+	token, expiresAt, err := tokenManager.GenerateWithTTL(auth.Claims{
+		UserID:  user.ID,
+		Role:    user.Role,
+		Purpose: auth.PurposePasswordChange,
+	}, passwordChangeTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &passwordChangeResponse{
+		PasswordChangeRequired: true,
+		Token:                  token,
+		ExpiresAt:              expiresAt,
+	}, nil
+}
+
+// AcceptInvitation устанавливает пароль по одноразовой ссылке и выполняет вход
+func AcceptInvitation(c *gin.Context) {
+	var req AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+
+	var invitation Invitation
+	err := db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", auth.HashToken(req.Token), time.Now()).
+		First(&invitation).Error
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Приглашение недействительно или истекло"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, invitation.UserID).Error; err != nil || !user.IsActive {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Приглашение недействительно или истекло"})
+		return
+	}
+
+	if err := auth.ValidatePassword(req.Password, user.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка установки пароля"})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Условное обновление: приглашение нельзя использовать дважды,
+		// даже при параллельных запросах
+		result := tx.Model(&Invitation{}).
+			Where("id = ? AND used_at IS NULL", invitation.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.Model(&user).Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+		return setMustChangePassword(tx, user.ID, false)
+	})
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Приглашение недействительно или истекло"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка установки пароля"})
+		return
+	}
+
+	completeLogin(c, &user, http.StatusOK)
+}
+
+// ChangePassword меняет пароль текущего пользователя. Доступен как с обычным
+// токеном, так и с токеном обязательной смены пароля. Все прежние сессии
+// завершаются, взамен выдается новая.
+func ChangePassword(c *gin.Context) {
+	claims, _ := currentClaims(c)
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, claims.UserID).Error; err != nil || !user.IsActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Аккаунт заблокирован"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный текущий пароль"})
+		return
+	}
+
+	if req.NewPassword == req.CurrentPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Новый пароль должен отличаться от текущего"})
+		return
+	}
+
+	if err := auth.ValidatePassword(req.NewPassword, user.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка смены пароля"})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+		return setMustChangePassword(tx, user.ID, false)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка смены пароля"})
+		return
+	}
+
+	if _, err := revokeUserSessions(user.ID, revokeReasonPasswordChanged); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения сессий"})
+		return
+	}
+
+	// При обязательной смене пароля вход еще не завершен: впереди
+	// может быть второй фактор
+	if claims.Purpose != "" {
+		completeLogin(c, &user, http.StatusOK)
+		return
+	}
+
+	tokens, err := startSession(c, &user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания токена"})
+		return
+	}
+
+	c.JSON(http.StatusOK, loginResponse{
+		tokenPair: *tokens,
+		User:      user,
+	})
+}
+
+// ResendInvitation повторно отправляет приглашение пользователю
+func ResendInvitation(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID пользователя"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	expiresAt, sent, err := inviteUser(&user, currentActor(c).UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания приглашения"})
+		return
+	}
+	recordAudit(c, "user.invitation_resend", "user", user.ID, nil, gin.H{"expires_at": expiresAt, "sent": sent})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":             "Приглашение создано",
+		"invitationSent":      sent,
+		"invitationExpiresAt": expiresAt,
+	})
+}
+
+// ForcePasswordChange требует от пользователя сменить пароль при следующем
+// входе и завершает его текущие сессии
+func ForcePasswordChange(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID пользователя"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	if err := setMustChangePassword(db, user.ID, true); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления пользователя"})
+		return
+	}
+
+	if _, err := revokeUserSessions(user.ID, revokeReasonAdmin); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения сессий"})
+		return
+	}
+
+	recordAudit(c, "user.force_password_change", "user", user.ID,
+		gin.H{"must_change_password": false}, gin.H{"must_change_password": true})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Пользователь сменит пароль при следующем входе"})
+}
+
+// SecureLegacyPasswords находит пароли, сохраненные в открытом виде
+// (временные пароли старой регистрации агентов), делает их непригодными
+// для входа и отправляет пользователям приглашения
+func SecureLegacyPasswords() (int, error) {
+	var users []models.User
+	if err := db.Find(&users).Error; err != nil {
+		return 0, err
+	}
+
+	secured := 0
+	for i := range users {
+		user := &users[i]
+		if auth.IsPasswordHash(user.Password) {
+			continue
+		}
+
+		hash, err := auth.UnusablePasswordHash()
+		if err != nil {
+			return secured, err
+		}
+		if err := db.Model(user).Update("password", hash).Error; err != nil {
+			return secured, err
+		}
+		secured++
+
+		if _, _, err := inviteUser(user, 0); err != nil {
+			log.Printf("Не удалось создать приглашение пользователю %d: %v", user.ID, err)
+		}
+	}
+
+	return secured, nil
+}
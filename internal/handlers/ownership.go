@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"tenderhelp/internal/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ClientAccess связывает пользователя (агента или представителя клиента) с клиентом
+type ClientAccess struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	ClientID  uint      `json:"client_id" gorm:"uniqueIndex:idx_client_access"`
+	UserID    uint      `json:"user_id" gorm:"uniqueIndex:idx_client_access;index"`
+	Relation  string    `json:"relation"` // agent, client
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BankMembership привязывает пользователя с ролью partner-bank к банку
+type BankMembership struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"uniqueIndex"`
+	BankID    string    `json:"bank_id" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ApplicationRoute фиксирует отправку заявки в конкретный банк
+type ApplicationRoute struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	ApplicationID uint      `json:"application_id" gorm:"index"`
+	BankID        string    `json:"bank_id" gorm:"index"`
+	Status        string    `json:"status"`
+	Message       string    `json:"message"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	// Решение банка
+	ApprovedAmount float64    `json:"approved_amount,omitempty"`
+	Rate           float64    `json:"rate,omitempty"`
+	DecidedAt      *time.Time `json:"decided_at,omitempty"`
+}
+
+// ClientAccessRequest запрос на выдачу доступа к клиенту
+type ClientAccessRequest struct {
+	ClientID uint   `json:"client_id" binding:"required"`
+	UserID   uint   `json:"user_id" binding:"required"`
+	Relation string `json:"relation" binding:"required,oneof=agent client"`
+}
+
+// BankMembershipRequest запрос на привязку пользователя к банку
+type BankMembershipRequest struct {
+	UserID uint   `json:"user_id" binding:"required"`
+	BankID string `json:"bank_id" binding:"required"`
+}
+
+// Роли, для которых видимость определяется привязкой к клиенту или банку
+const (
+	roleClient      = "client"
+	rolePartnerBank = "partner-bank"
+)
+
+// actor пользователь (или ключ API банка), выполняющий запрос
+type actor struct {
+	UserID uint
+	Role   string
+	BankID string // задан для запросов по ключу API
+}
+
+// currentActor возвращает пользователя из контекста, заполненного RequireAuth
+func currentActor(c *gin.Context) actor {
+	a := actor{}
+	if userID, ok := c.Get("user_id"); ok {
+		a.UserID, _ = userID.(uint)
+	}
+	if role, ok := c.Get("user_role"); ok {
+		a.Role, _ = role.(string)
+	}
+	a.BankID = c.GetString("bank_id")
+	return a
+}
+
+// can проверяет право роли текущего пользователя. Ключам API права
+// на просмотр всех записей не выдаются.
+func (a actor) can(permission string) bool {
+	if a.BankID != "" {
+		return false
+	}
+	return rolePermissions.hasPermission(a.Role, permission)
+}
+
+// scopeApplications ограничивает выборку заявок теми, что доступны пользователю:
+// агент видит свои заявки и заявки своих клиентов, клиент - заявки своей
+// компании, банк - только направленные ему заявки
+func scopeApplications(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	return applicationScope(currentActor(c))
+}
+
+// applicationScope ограничение выборки заявок для пользователя, в том числе
+// не выполняющего запрос (например, упомянутого в комментарии)
+func applicationScope(a actor) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if a.can("view_all_applications") {
+			return tx
+		}
+
+		if a.Role == rolePartnerBank {
+			return tx.Where("applications.id IN (?)", routedApplicationIDs(a))
+		}
+
+		return tx.Where("applications.agent_id = ? OR applications.client_id IN (?)",
+			a.UserID, accessibleClientIDs(a.UserID))
+	}
+}
+
+// scopeClients ограничивает выборку клиентов доступными пользователю
+func scopeClients(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	a := currentActor(c)
+	return func(tx *gorm.DB) *gorm.DB {
+		if a.can("view_all_clients") {
+			return tx
+		}
+
+		if a.Role == rolePartnerBank {
+			return tx.Where("clients.id IN (?)",
+				db.Model(&Application{}).Select("client_id").Where("id IN (?)", routedApplicationIDs(a)))
+		}
+
+		return tx.Where("clients.id IN (?)", accessibleClientIDs(a.UserID))
+	}
+}
+
+// scopeFiles ограничивает выборку файлов файлами доступных заявок
+func scopeFiles(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if currentActor(c).can("view_all_applications") {
+			return tx
+		}
+		return tx.Where("files.application_id IN (?)",
+			db.Model(&Application{}).Select("applications.id").Scopes(scopeApplications(c)))
+	}
+}
+
+// accessibleClientIDs подзапрос ID клиентов, к которым у пользователя есть доступ
+func accessibleClientIDs(userID uint) *gorm.DB {
+	return db.Model(&ClientAccess{}).Select("client_id").Where("user_id = ?", userID)
+}
+
+// routedApplicationIDs подзапрос ID заявок, направленных в банк пользователя
+// (или в банк ключа API)
+func routedApplicationIDs(a actor) *gorm.DB {
+	query := db.Model(&ApplicationRoute{}).Select("application_id")
+	if a.BankID != "" {
+		return query.Where("bank_id = ?", a.BankID)
+	}
+	return query.Where("bank_id IN (?)", db.Model(&BankMembership{}).Select("bank_id").Where("user_id = ?", a.UserID))
+}
+
+// findScopedApplication загружает заявку с учетом прав доступа и отвечает
+// 404, если она не найдена или недоступна пользователю
+func findScopedApplication(c *gin.Context, id interface{}, application *Application, preload ...string) bool {
+	query := db.Scopes(scopeApplications(c))
+	for _, association := range preload {
+		query = query.Preload(association)
+	}
+
+	if err := query.First(application, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Заявка не найдена"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения заявки"})
+		return false
+	}
+	return true
+}
+
+// grantClientAccess выдает пользователю доступ к клиенту (повторная выдача игнорируется)
+func grantClientAccess(tx *gorm.DB, clientID, userID uint, relation string) error {
+	access := ClientAccess{ClientID: clientID, UserID: userID, Relation: relation}
+	return tx.Where(ClientAccess{ClientID: clientID, UserID: userID}).
+		Attrs(ClientAccess{Relation: relation, CreatedAt: time.Now()}).
+		FirstOrCreate(&access).Error
+}
+
+// resolveApplicationClient определяет клиента новой заявки: указанного явно
+// (если он доступен пользователю) или компанию пользователя с ролью client
+func resolveApplicationClient(c *gin.Context, requestedID uint) (uint, bool) {
+	if requestedID != 0 {
+		var client models.Client
+		if err := db.Scopes(scopeClients(c)).First(&client, requestedID).Error; err != nil {
+			return 0, false
+		}
+		return client.ID, true
+	}
+
+	a := currentActor(c)
+	if a.Role == roleClient {
+		var access ClientAccess
+		if err := db.Where("user_id = ? AND relation = ?", a.UserID, roleClient).First(&access).Error; err != nil {
+			return 0, false
+		}
+		return access.ClientID, true
+	}
+
+	return 0, true
+}
+
+// GrantClientAccess выдает пользователю доступ к клиенту
+func GrantClientAccess(c *gin.Context) {
+	var req ClientAccessRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var client models.Client
+	if err := db.First(&client, req.ClientID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Клиент не найден"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, req.UserID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	if err := grantClientAccess(db, client.ID, user.ID, req.Relation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка выдачи доступа"})
+		return
+	}
+	recordAudit(c, "client_access.grant", "client", client.ID, nil,
+		gin.H{"user_id": user.ID, "relation": req.Relation})
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Доступ к клиенту выдан"})
+}
+
+// RevokeClientAccess отзывает доступ пользователя к клиенту
+func RevokeClientAccess(c *gin.Context) {
+	result := db.Where("client_id = ? AND user_id = ?", c.Param("clientId"), c.Param("userId")).
+		Delete(&ClientAccess{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка отзыва доступа"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Доступ не найден"})
+		return
+	}
+	recordAudit(c, "client_access.revoke", "client", c.Param("clientId"),
+		gin.H{"user_id": c.Param("userId")}, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Доступ к клиенту отозван"})
+}
+
+// SetBankMembership привязывает пользователя банка-партнера к банку
+func SetBankMembership(c *gin.Context) {
+	var req BankMembershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.BankID = strings.TrimSpace(req.BankID)
+	if _, err := adapterManager.GetAdapter(req.BankID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Банк не найден"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, req.UserID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+	if user.Role != rolePartnerBank {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Пользователь не является сотрудником банка-партнера"})
+		return
+	}
+
+	var before BankMembership
+	db.Where("user_id = ?", user.ID).Limit(1).Find(&before)
+
+	membership := BankMembership{UserID: user.ID}
+	err := db.Where(BankMembership{UserID: user.ID}).
+		Assign(BankMembership{BankID: req.BankID}).
+		FirstOrCreate(&membership).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка привязки к банку"})
+		return
+	}
+	recordAudit(c, "bank_membership.set", "user", user.ID,
+		gin.H{"bank_id": before.BankID}, gin.H{"bank_id": membership.BankID})
+
+	c.JSON(http.StatusOK, membership)
+}
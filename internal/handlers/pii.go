@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"tenderhelp/internal/pii"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Поля анкеты с персональными данными (см. docs/field-mapping.md). ФИО не
+// шифруется: по нему агенты находят заявки в списках.
+var (
+	personalDataPIIPaths = []string{
+		"birthDate", "birthPlace",
+		"passportSeries", "passportNumber", "passportIssuedBy", "passportIssueDate", "passportDepartmentCode",
+	}
+	contactDataPIIPaths = []string{
+		"primaryPhone", "additionalPhones", "email", "registrationAddress", "actualAddress",
+	}
+	familyDataPIIPaths = []string{
+		"spouse.spouseName", "spouse.spousePhone",
+		"children[].name", "children[].birthDate",
+		"emergencyContacts[].name", "emergencyContacts[].phone", "emergencyContacts[].address",
+	}
+)
+
+// piiRotationBatchSize размер пачки заявок при ротации ключей
+const piiRotationBatchSize = 200
+
+// piiKeyring мастер-ключи шифрования персональных данных (nil - шифрование отключено)
+var piiKeyring *pii.Keyring
+
+// errPIIKeysMissing запись зашифрована, но ключи не загружены
+var errPIIKeysMissing = errors.New("персональные данные зашифрованы, но ключи PII_KEYS не заданы")
+
+// SetPIIKeyring устанавливает мастер-ключи шифрования персональных данных
+func SetPIIKeyring(keyring *pii.Keyring) {
+	piiKeyring = keyring
+}
+
+// piiSection раздел анкеты и пути персональных данных в нем
+type piiSection struct {
+	name  string
+	data  *json.RawMessage
+	paths []string
+}
+
+// piiSections возвращает разделы анкеты, содержащие персональные данные
+func (a *Application) piiSections() []piiSection {
+	return []piiSection{
+		{"personal_data", &a.PersonalData, personalDataPIIPaths},
+		{"contact_data", &a.ContactData, contactDataPIIPaths},
+		{"family_data", &a.FamilyData, familyDataPIIPaths},
+	}
+}
+
+// hasPIIData проверяет, что в заявке заполнен хотя бы один раздел с
+// персональными данными (при обновлении отдельных колонок они пустые)
+func (a *Application) hasPIIData() bool {
+	for _, section := range a.piiSections() {
+		if len(*section.data) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// transformPII применяет fn к полям персональных данных всех разделов.
+// В fn передается полный путь поля вместе с разделом.
+func (a *Application) transformPII(fn func(path string, value interface{}) (interface{}, error)) error {
+	for _, section := range a.piiSections() {
+		if len(*section.data) == 0 {
+			continue
+		}
+		prefix := section.name + "."
+		transformed, err := pii.TransformPaths(*section.data, section.paths, func(path string, value interface{}) (interface{}, error) {
+			return fn(prefix+path, value)
+		})
+		if err != nil {
+			return err
+		}
+		*section.data = transformed
+	}
+	return nil
+}
+
+// dataKey возвращает ключ данных заявки, создавая его для новой записи.
+// Ключ, зашифрованный неактивным мастер-ключом, перешифровывается.
+func (a *Application) dataKey() ([]byte, error) {
+	if a.PIIDataKey == "" {
+		dataKey, wrapped, keyID, err := piiKeyring.NewDataKey()
+		if err != nil {
+			return nil, err
+		}
+		a.PIIDataKey, a.PIIKeyID = wrapped, keyID
+		return dataKey, nil
+	}
+
+	dataKey, err := piiKeyring.UnwrapDataKey(a.PIIKeyID, a.PIIDataKey)
+	if err != nil {
+		return nil, err
+	}
+	if a.PIIKeyID != piiKeyring.ActiveKeyID() {
+		if a.PIIDataKey, a.PIIKeyID, err = piiKeyring.Rewrap(a.PIIKeyID, a.PIIDataKey); err != nil {
+			return nil, err
+		}
+	}
+	return dataKey, nil
+}
+
+// encryptPII шифрует персональные данные заявки
+func (a *Application) encryptPII() error {
+	if piiKeyring == nil || !a.hasPIIData() {
+		return nil
+	}
+
+	dataKey, err := a.dataKey()
+	if err != nil {
+		return err
+	}
+	c, err := pii.NewCipher(dataKey)
+	if err != nil {
+		return err
+	}
+	return a.transformPII(c.EncryptValue)
+}
+
+// decryptPII расшифровывает персональные данные заявки
+func (a *Application) decryptPII() error {
+	if a.PIIDataKey == "" || !a.hasPIIData() {
+		return nil
+	}
+	if piiKeyring == nil {
+		return errPIIKeysMissing
+	}
+
+	dataKey, err := piiKeyring.UnwrapDataKey(a.PIIKeyID, a.PIIDataKey)
+	if err != nil {
+		return err
+	}
+	c, err := pii.NewCipher(dataKey)
+	if err != nil {
+		return err
+	}
+	return a.transformPII(c.DecryptValue)
+}
+
+// BeforeSave шифрует персональные данные перед записью в базу данных
+func (a *Application) BeforeSave(tx *gorm.DB) error {
+	return a.encryptPII()
+}
+
+// AfterSave возвращает в структуру расшифрованные значения
+func (a *Application) AfterSave(tx *gorm.DB) error {
+	return a.decryptPII()
+}
+
+// AfterFind расшифровывает персональные данные после чтения
+func (a *Application) AfterFind(tx *gorm.DB) error {
+	return a.decryptPII()
+}
+
+// maskedPII возвращает копию заявки со скрытыми персональными данными
+func (a Application) maskedPII() Application {
+	masked := a
+	for _, section := range masked.piiSections() {
+		if len(*section.data) == 0 {
+			continue
+		}
+		transformed, err := pii.TransformPaths(*section.data, section.paths, func(_ string, value interface{}) (interface{}, error) {
+			return pii.Mask(value), nil
+		})
+		if err != nil {
+			// Некорректный JSON не отдается целиком
+			transformed = json.RawMessage("null")
+		}
+		*section.data = transformed
+	}
+	return masked
+}
+
+// canViewPII проверяет право на просмотр персональных данных. Ключам API
+// оно выдается только через область действия ключа.
+func canViewPII(c *gin.Context) bool {
+	if isAPIKeyRequest(c) {
+		claims, ok := currentClaims(c)
+		return ok && claims.HasPermission("view_pii")
+	}
+	return rolePermissions.hasPermission(currentActor(c).Role, "view_pii")
+}
+
+// presentApplication подготавливает заявку к отдаче в API
+func presentApplication(c *gin.Context, application Application) Application {
+	if canViewPII(c) {
+		return application
+	}
+	return application.maskedPII()
+}
+
+// presentApplications подготавливает список заявок к отдаче в API
+func presentApplications(c *gin.Context, applications []Application) []Application {
+	if canViewPII(c) {
+		return applications
+	}
+	masked := make([]Application, len(applications))
+	for i, application := range applications {
+		masked[i] = application.maskedPII()
+	}
+	return masked
+}
+
+// rotatePIIKeys шифрует активным ключом заявки, сохраненные без шифрования
+// или другим мастер-ключом. Для зашифрованных записей перешифровывается
+// только ключ данных.
+func rotatePIIKeys() (int, error) {
+	if piiKeyring == nil {
+		return 0, errors.New("ключи PII_KEYS не заданы")
+	}
+
+	active := piiKeyring.ActiveKeyID()
+	updated := 0
+
+	var batch []Application
+	result := db.Where("pii_key_id IS NULL OR pii_key_id <> ?", active).
+		FindInBatches(&batch, piiRotationBatchSize, func(tx *gorm.DB, _ int) error {
+			for i := range batch {
+				application := &batch[i]
+
+				columns := map[string]interface{}{}
+				if application.PIIDataKey != "" {
+					wrapped, keyID, err := piiKeyring.Rewrap(application.PIIKeyID, application.PIIDataKey)
+					if err != nil {
+						return err
+					}
+					columns["pii_data_key"], columns["pii_key_id"] = wrapped, keyID
+				} else {
+					if !application.hasPIIData() {
+						continue
+					}
+					if err := application.encryptPII(); err != nil {
+						return err
+					}
+					if err := encryptRevisionsPII(application); err != nil {
+						return err
+					}
+					columns["personal_data"] = application.PersonalData
+					columns["contact_data"] = application.ContactData
+					columns["family_data"] = application.FamilyData
+					columns["pii_data_key"] = application.PIIDataKey
+					columns["pii_key_id"] = application.PIIKeyID
+				}
+
+				// UpdateColumns не вызывает хуки и не меняет updated_at
+				if err := db.Model(&Application{}).Where("id = ?", application.ID).UpdateColumns(columns).Error; err != nil {
+					return err
+				}
+				updated++
+			}
+			return nil
+		})
+	if result.Error != nil {
+		return updated, result.Error
+	}
+
+	// Сохраненные ответы на повторяемые запросы не перешифровываются
+	return updated, purgeIdempotentResponses()
+}
+
+// EncryptLegacyPII шифрует при запуске заявки, сохраненные до включения
+// шифрования или ключом, выведенным из активных
+func EncryptLegacyPII() (int, error) {
+	if piiKeyring == nil {
+		log.Println("ВНИМАНИЕ: PII_KEYS не задан, персональные данные хранятся без шифрования")
+		return 0, nil
+	}
+	return rotatePIIKeys()
+}
+
+// RotatePIIKeys перешифровывает ключи данных заявок активным мастер-ключом.
+// После ротации старый ключ можно удалить из PII_KEYS.
+func RotatePIIKeys(c *gin.Context) {
+	updated, err := rotatePIIKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка ротации ключей: " + err.Error()})
+		return
+	}
+
+	recordAudit(c, "pii.rotate_keys", "pii_keyring", piiKeyring.ActiveKeyID(), nil, gin.H{"updated": updated})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Ключи перешифрованы",
+		"active_key_id": piiKeyring.ActiveKeyID(),
+		"updated":       updated,
+	})
+}
+
+// GetPIIKeyStatus показывает, сколько заявок зашифровано каждым ключом
+func GetPIIKeyStatus(c *gin.Context) {
+	var rows []struct {
+		PIIKeyID string `json:"key_id"`
+		Count    int64  `json:"count"`
+	}
+	if err := db.Model(&Application{}).Select("pii_key_id, COUNT(*) AS count").Group("pii_key_id").Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения статистики"})
+		return
+	}
+
+	response := gin.H{"enabled": piiKeyring != nil, "applications": rows}
+	if piiKeyring != nil {
+		response["active_key_id"] = piiKeyring.ActiveKeyID()
+		response["key_ids"] = piiKeyring.KeyIDs()
+	}
+	c.JSON(http.StatusOK, response)
+}
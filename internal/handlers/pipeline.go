@@ -0,0 +1,659 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"tenderhelp/internal/adapters"
+	"tenderhelp/internal/statemachine"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// PipelineRun запуск автоматической обработки заявки: скоринг, выбор банков,
+// отправка и сбор ответов. Состояние хранится в базе данных, поэтому после
+// перезапуска сервера обработка продолжается с сохраненного этапа.
+type PipelineRun struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	ApplicationID  uint       `json:"application_id" gorm:"index"`
+	Stage          string     `json:"stage"`
+	Status         string     `json:"status" gorm:"index"` // active, completed, failed
+	RuleSetID      string     `json:"rule_set_id,omitempty"`
+	RiskClass      string     `json:"risk_class,omitempty"`
+	Attempts       int        `json:"attempts"` // неудачные попытки текущего этапа
+	LastError      string     `json:"last_error,omitempty"`
+	NextRunAt      time.Time  `json:"next_run_at" gorm:"index"`
+	StageStartedAt time.Time  `json:"stage_started_at"`
+	LockedUntil    *time.Time `json:"-"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	Tasks []PipelineTask `json:"tasks" gorm:"foreignKey:RunID"`
+}
+
+// PipelineTask отправка заявки в один банк в рамках запуска
+type PipelineTask struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	RunID         uint       `json:"run_id" gorm:"uniqueIndex:idx_pipeline_task"`
+	ApplicationID uint       `json:"application_id" gorm:"index"`
+	BankID        string     `json:"bank_id" gorm:"uniqueIndex:idx_pipeline_task"`
+	Status        string     `json:"status"` // pending, sent, failed
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastError     string     `json:"last_error,omitempty"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Этапы обработки
+const (
+	stageScoring       = "scoring"
+	stageReview        = "review" // ожидание ручной проверки после скоринга класса B
+	stageBankSelection = "bank_selection"
+	stageSending       = "sending"
+	stageCollecting    = "collecting"
+	stageDone          = "done"
+)
+
+// Состояния запуска и задач
+const (
+	pipelineActive    = "active"
+	pipelineCompleted = "completed"
+	pipelineFailed    = "failed"
+
+	taskPending = "pending"
+	taskSent    = "sent"
+	taskFailed  = "failed"
+)
+
+// Параметры обработки
+const (
+	pipelinePollInterval    = 5 * time.Second
+	pipelineBatchSize       = 20
+	pipelineLease           = 2 * time.Minute
+	pipelineMaxAttempts     = 5
+	pipelineMaxBackoff      = 30 * time.Minute
+	pipelineReviewInterval  = 15 * time.Minute
+	pipelineCollectInterval = 30 * time.Minute
+	pipelineCollectTimeout  = 14 * 24 * time.Hour
+)
+
+// pipelineWake будит обработчик, не дожидаясь следующего опроса
+var pipelineWake = make(chan struct{}, 1)
+
+// StartPipeline запускает фоновую обработку заявок. Заявки, отправленные на
+// рассмотрение до запуска сервера и еще не обработанные, ставятся в очередь.
+func StartPipeline() {
+	if err := resumePipelines(); err != nil {
+		log.Printf("Ошибка восстановления обработки заявок: %v", err)
+	}
+	go pipelineLoop()
+}
+
+// resumePipelines создает запуски для заявок в статусе submitted без обработки
+func resumePipelines() error {
+	var ids []uint
+	if err := db.Model(&Application{}).
+		Where("status = ? AND id NOT IN (?)", string(statusSubmitted), db.Model(&PipelineRun{}).Select("application_id")).
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := startPipeline(id); err != nil {
+			return err
+		}
+	}
+	if len(ids) > 0 {
+		log.Printf("Поставлено в обработку заявок: %d", len(ids))
+	}
+	return nil
+}
+
+// startPipeline ставит заявку в обработку, если она еще не обрабатывается
+func startPipeline(applicationID uint) error {
+	var count int64
+	db.Model(&PipelineRun{}).Where("application_id = ? AND status = ?", applicationID, pipelineActive).Count(&count)
+	if count > 0 {
+		return nil
+	}
+
+	now := time.Now()
+	run := PipelineRun{
+		ApplicationID:  applicationID,
+		Stage:          stageScoring,
+		Status:         pipelineActive,
+		NextRunAt:      now,
+		StageStartedAt: now,
+	}
+	if err := db.Create(&run).Error; err != nil {
+		return err
+	}
+	wakePipeline()
+	return nil
+}
+
+func wakePipeline() {
+	select {
+	case pipelineWake <- struct{}{}:
+	default:
+	}
+}
+
+func pipelineLoop() {
+	ticker := time.NewTicker(pipelinePollInterval)
+	defer ticker.Stop()
+
+	for {
+		processDuePipelines()
+		select {
+		case <-ticker.C:
+		case <-pipelineWake:
+		}
+	}
+}
+
+// processDuePipelines обрабатывает запуски, срок очередного шага которых наступил
+func processDuePipelines() {
+	var runs []PipelineRun
+	if err := db.Where("status = ? AND next_run_at <= ?", pipelineActive, time.Now()).
+		Order("next_run_at").
+		Limit(pipelineBatchSize).
+		Find(&runs).Error; err != nil {
+		log.Printf("Ошибка получения заявок для обработки: %v", err)
+		return
+	}
+
+	for i := range runs {
+		if claimPipelineRun(&runs[i]) {
+			advancePipeline(&runs[i])
+		}
+	}
+}
+
+// claimPipelineRun захватывает запуск на время обработки, чтобы его не взял
+// другой экземпляр сервера. Захват истекает сам, если процесс упал.
+func claimPipelineRun(run *PipelineRun) bool {
+	now := time.Now()
+	until := now.Add(pipelineLease)
+	result := db.Model(&PipelineRun{}).
+		Where("id = ? AND (locked_until IS NULL OR locked_until < ?)", run.ID, now).
+		UpdateColumn("locked_until", until)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+	run.LockedUntil = &until
+	return true
+}
+
+// advancePipeline выполняет текущий этап запуска. Ошибки этапа повторяются
+// с нарастающей задержкой, после pipelineMaxAttempts запуск завершается.
+func advancePipeline(run *PipelineRun) {
+	var application Application
+	err := db.First(&application, run.ApplicationID).Error
+	if err == nil {
+		switch run.Stage {
+		case stageScoring:
+			err = runScoringStage(run, &application)
+		case stageReview:
+			afterScoring(run, &application)
+		case stageBankSelection:
+			err = runBankSelectionStage(run, &application)
+		case stageSending:
+			err = runSendingStage(run, &application)
+		case stageCollecting:
+			err = runCollectingStage(run, &application)
+		default:
+			err = fmt.Errorf("неизвестный этап %s", run.Stage)
+		}
+	}
+
+	if err != nil {
+		run.Attempts++
+		run.LastError = err.Error()
+		if run.Attempts >= pipelineMaxAttempts || errors.Is(err, gorm.ErrRecordNotFound) {
+			failPipeline(run, &application, "Этап "+run.Stage+" завершился ошибкой: "+err.Error())
+		} else {
+			run.NextRunAt = time.Now().Add(pipelineBackoff(run.Attempts))
+		}
+	}
+
+	run.LockedUntil = nil
+	if err := db.Omit("Tasks").Save(run).Error; err != nil {
+		log.Printf("Ошибка сохранения обработки заявки %d: %v", run.ApplicationID, err)
+	}
+}
+
+// pipelineBackoff задержка перед повтором: 30 секунд, удваиваемые с каждой попыткой
+func pipelineBackoff(attempt int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempt && delay < pipelineMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > pipelineMaxBackoff {
+		delay = pipelineMaxBackoff
+	}
+	return delay
+}
+
+// setStage переводит запуск на следующий этап
+func setStage(run *PipelineRun, stage string, next time.Time) {
+	if run.Stage != stage {
+		run.Stage = stage
+		run.StageStartedAt = time.Now()
+		run.Attempts = 0
+		run.LastError = ""
+	}
+	run.NextRunAt = next
+}
+
+// finishPipeline завершает запуск и пишет итог в историю
+func finishPipeline(run *PipelineRun, application *Application, status, comment string) {
+	now := time.Now()
+	run.Status = status
+	run.FinishedAt = &now
+	recordPipelineStage(application, run.Stage, comment)
+	if status == pipelineCompleted {
+		run.Stage = stageDone
+	}
+}
+
+func failPipeline(run *PipelineRun, application *Application, comment string) {
+	run.LastError = comment
+	finishPipeline(run, application, pipelineFailed, comment)
+}
+
+// recordPipelineStage пишет этап обработки в историю статусов заявки
+func recordPipelineStage(application *Application, stage, comment string) {
+	if application.ID == 0 {
+		return
+	}
+	entry := StatusHistory{
+		ApplicationID: application.ID,
+		Status:        application.Status,
+		Stage:         stage,
+		Timestamp:     time.Now(),
+		Comment:       comment,
+	}
+	if err := db.Create(&entry).Error; err != nil {
+		log.Printf("Ошибка записи истории заявки %d: %v", application.ID, err)
+	}
+}
+
+// pipelineTransition переводит заявку в новый статус от имени системы.
+// Если статус уже изменил пользователь, этап будет выбран заново по новому статусу.
+func pipelineTransition(run *PipelineRun, application *Application, event statemachine.Event, comment string) (bool, error) {
+	err := applyTransition(application, event, StatusHistory{Stage: run.Stage, Comment: comment})
+	if err == nil {
+		return true, nil
+	}
+	var transitionErr *statemachine.TransitionError
+	if errors.Is(err, errStatusChanged) || errors.As(err, &transitionErr) {
+		run.NextRunAt = time.Now()
+		return false, nil
+	}
+	return false, err
+}
+
+// runScoringStage выполняет скоринг активным набором правил
+func runScoringStage(run *PipelineRun, application *Application) error {
+	// Заявку могли оценить вручную через /scoring/run
+	if application.Status != string(statusSubmitted) {
+		afterScoring(run, application)
+		return nil
+	}
+
+	ruleSetID := activeRuleSetID()
+	result, err := scoringEngine.ScoreApplication(scoringInput(application), ruleSetID)
+	if err != nil {
+		return fmt.Errorf("ошибка скоринга: %w", err)
+	}
+	run.RuleSetID = ruleSetID
+	run.RiskClass = result.RiskClass
+
+	comment := fmt.Sprintf("Скоринг завершен (набор правил %s). Класс риска: %s", ruleSetID, result.RiskClass)
+	if ok, err := pipelineTransition(run, application, scoringEvent(result.RiskClass), comment); !ok {
+		return err
+	}
+	saveRiskClass(application, result.RiskClass)
+
+	afterScoring(run, application)
+	return nil
+}
+
+// afterScoring выбирает следующий этап по статусу заявки после скоринга
+func afterScoring(run *PipelineRun, application *Application) {
+	now := time.Now()
+	switch statemachine.State(application.Status) {
+	case statusApproved:
+		setStage(run, stageBankSelection, now)
+	case statusInReview:
+		if run.Stage != stageReview {
+			recordPipelineStage(application, stageReview, "Заявка ожидает ручной проверки")
+		}
+		setStage(run, stageReview, now.Add(pipelineReviewInterval))
+	case statusSentToBanks:
+		setStage(run, stageCollecting, now)
+	case statusRejected:
+		finishPipeline(run, application, pipelineCompleted, "Обработка завершена: заявка отклонена по результатам скоринга")
+	default:
+		failPipeline(run, application, "Обработка остановлена: заявка в статусе "+application.Status)
+	}
+}
+
+// eligibleBanks возвращает активные банки, принимающие заявки такого типа и суммы
+func eligibleBanks(application *Application) []string {
+	var banks []string
+	for bankID, adapter := range adapterManager.GetActiveAdapters() {
+		info := adapter.GetBankInfo()
+		if application.Amount < info.MinAmount || (info.MaxAmount > 0 && application.Amount > info.MaxAmount) {
+			continue
+		}
+		for _, supported := range info.SupportedTypes {
+			if supported == application.Type {
+				banks = append(banks, bankID)
+				break
+			}
+		}
+	}
+	sort.Strings(banks)
+	return banks
+}
+
+// runBankSelectionStage выбирает банки и создает задачи отправки
+func runBankSelectionStage(run *PipelineRun, application *Application) error {
+	switch statemachine.State(application.Status) {
+	case statusApproved:
+	case statusSentToBanks:
+		// Заявку отправили вручную через /send
+		setStage(run, stageCollecting, time.Now())
+		return nil
+	default:
+		afterScoring(run, application)
+		return nil
+	}
+
+	var routed []string
+	db.Model(&ApplicationRoute{}).Where("application_id = ?", application.ID).Pluck("bank_id", &routed)
+	alreadyRouted := make(map[string]bool, len(routed))
+	for _, bankID := range routed {
+		alreadyRouted[bankID] = true
+	}
+
+	var selected []string
+	for _, bankID := range eligibleBanks(application) {
+		if !alreadyRouted[bankID] {
+			selected = append(selected, bankID)
+		}
+	}
+	if len(selected) == 0 {
+		failPipeline(run, application, "Нет банков, принимающих заявки такого типа и суммы")
+		return nil
+	}
+
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, bankID := range selected {
+			task := PipelineTask{
+				RunID:         run.ID,
+				ApplicationID: application.ID,
+				BankID:        bankID,
+				Status:        taskPending,
+				NextAttemptAt: now,
+			}
+			if err := tx.Where(PipelineTask{RunID: run.ID, BankID: bankID}).FirstOrCreate(&task).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	recordPipelineStage(application, stageBankSelection, "Выбраны банки: "+strings.Join(selected, ", "))
+	setStage(run, stageSending, now)
+	return nil
+}
+
+// runSendingStage отправляет заявку в выбранные банки
+func runSendingStage(run *PipelineRun, application *Application) error {
+	now := time.Now()
+	var due []PipelineTask
+	if err := db.Where("run_id = ? AND status = ? AND next_attempt_at <= ?", run.ID, taskPending, now).Find(&due).Error; err != nil {
+		return err
+	}
+
+	scoringData, _ := json.Marshal(gin.H{"risk_class": run.RiskClass, "rule_set_id": run.RuleSetID})
+	data := bankApplicationData(application, scoringData)
+	for i := range due {
+		sendPipelineTask(&due[i], application, data)
+	}
+
+	var tasks []PipelineTask
+	if err := db.Where("run_id = ?", run.ID).Find(&tasks).Error; err != nil {
+		return err
+	}
+
+	var sent []string
+	next := time.Time{}
+	for _, task := range tasks {
+		switch task.Status {
+		case taskPending:
+			if next.IsZero() || task.NextAttemptAt.Before(next) {
+				next = task.NextAttemptAt
+			}
+		case taskSent:
+			sent = append(sent, task.BankID)
+		}
+	}
+	if !next.IsZero() {
+		run.NextRunAt = next
+		return nil
+	}
+
+	if len(sent) == 0 {
+		failPipeline(run, application, "Ни один банк не принял заявку")
+		return nil
+	}
+
+	if application.Status != string(statusSentToBanks) {
+		ok, err := pipelineTransition(run, application, eventSendToBanks, "Заявка отправлена в банки: "+strings.Join(sent, ", "))
+		if !ok {
+			return err
+		}
+	}
+	setStage(run, stageCollecting, time.Now().Add(pipelineCollectInterval))
+	return nil
+}
+
+// sendPipelineTask отправляет заявку в банк. Ошибки валидации банка не
+// повторяются, остальные - с нарастающей задержкой.
+func sendPipelineTask(task *PipelineTask, application *Application, data adapters.ApplicationData) {
+	response, err := adapterManager.SendToSpecificBanks(data, []string{task.BankID})
+	task.Attempts++
+
+	var result *adapters.BankResponse
+	if err == nil && len(response) > 0 {
+		result = &response[0]
+	}
+
+	switch {
+	case result != nil && result.Success:
+		now := time.Now()
+		task.Status = taskSent
+		task.SentAt = &now
+		task.LastError = ""
+		db.Create(&ApplicationRoute{
+			ApplicationID: application.ID,
+			BankID:        task.BankID,
+			Status:        result.Status,
+			Message:       result.Message,
+		})
+		recordPipelineStage(application, stageSending, "Банк "+task.BankID+" принял заявку")
+
+	default:
+		message := "нет ответа"
+		if err != nil {
+			message = err.Error()
+		} else if result != nil {
+			message = result.Message
+		}
+		task.LastError = message
+
+		if (result != nil && (result.ErrorCode == "VALIDATION_ERROR" || result.ErrorCode == "ADAPTER_NOT_FOUND")) || task.Attempts >= pipelineMaxAttempts {
+			task.Status = taskFailed
+			recordPipelineStage(application, stageSending, "Банк "+task.BankID+" не принял заявку: "+message)
+		} else {
+			task.NextAttemptAt = time.Now().Add(pipelineBackoff(task.Attempts))
+		}
+	}
+
+	if err := db.Save(task).Error; err != nil {
+		log.Printf("Ошибка сохранения задачи отправки %d: %v", task.ID, err)
+	}
+}
+
+// bankDecisions итоговые статусы адаптеров банков
+var bankDecisions = map[string]string{
+	"approved": "approved",
+	"rejected": "rejected",
+	"declined": "rejected",
+}
+
+// runCollectingStage опрашивает банки о решениях по заявке и сохраняет их
+// в маршрутах заявки. Решения, переданные банком через API, не перезаписываются.
+func runCollectingStage(run *PipelineRun, application *Application) error {
+	var routes []ApplicationRoute
+	if err := db.Where("application_id = ? AND decided_at IS NULL", application.ID).Find(&routes).Error; err != nil {
+		return err
+	}
+
+	undecided := 0
+	for i := range routes {
+		route := &routes[i]
+		adapter, err := adapterManager.GetAdapter(route.BankID)
+		if err != nil {
+			undecided++
+			continue
+		}
+		status, err := adapter.GetApplicationStatus(strconv.FormatUint(uint64(application.ID), 10))
+		if err != nil {
+			undecided++
+			continue
+		}
+
+		decision, final := bankDecisions[status.Status]
+		if !final {
+			undecided++
+			if status.Status != route.Status {
+				db.Model(route).Updates(map[string]interface{}{"status": status.Status, "message": status.Message})
+			}
+			continue
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{
+			"status":          decision,
+			"message":         status.Message,
+			"approved_amount": status.Amount,
+			"rate":            status.Rate,
+			"decided_at":      now,
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&ApplicationRoute{}).Where("id = ? AND decided_at IS NULL", route.ID).Updates(updates)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			return tx.Create(&StatusHistory{
+				ApplicationID: application.ID,
+				Status:        "bank_response",
+				Stage:         stageCollecting,
+				Timestamp:     now,
+				Comment:       fmt.Sprintf("Банк %s: %s. %s", route.BankID, decision, status.Message),
+			}).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case undecided == 0:
+		finishPipeline(run, application, pipelineCompleted, "Получены решения всех банков")
+	case time.Since(run.StageStartedAt) > pipelineCollectTimeout:
+		finishPipeline(run, application, pipelineCompleted, "Истек срок ожидания решений банков")
+	default:
+		run.NextRunAt = time.Now().Add(pipelineCollectInterval)
+	}
+	return nil
+}
+
+// GetApplicationPipeline возвращает запуски обработки заявки
+func GetApplicationPipeline(c *gin.Context) {
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+
+	var runs []PipelineRun
+	if err := db.Preload("Tasks").Where("application_id = ?", application.ID).Order("id DESC").Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения обработки заявки"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+// RetryApplicationPipeline перезапускает завершившуюся ошибкой обработку
+// с этапа, на котором она остановилась
+func RetryApplicationPipeline(c *gin.Context) {
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+
+	var run PipelineRun
+	if err := db.Where("application_id = ?", application.ID).Order("id DESC").First(&run).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Обработка заявки не запускалась"})
+		return
+	}
+	if run.Status != pipelineFailed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Перезапустить можно только обработку, завершившуюся ошибкой"})
+		return
+	}
+
+	before := gin.H{"status": run.Status, "stage": run.Stage, "last_error": run.LastError}
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Банки, которые не приняли заявку, получают еще одну попытку
+		if err := tx.Model(&PipelineTask{}).Where("run_id = ? AND status = ?", run.ID, taskFailed).
+			Updates(map[string]interface{}{"status": taskPending, "attempts": 0, "next_attempt_at": now}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&run).Updates(map[string]interface{}{
+			"status":      pipelineActive,
+			"attempts":    0,
+			"last_error":  "",
+			"next_run_at": now,
+			"finished_at": nil,
+		}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка перезапуска обработки"})
+		return
+	}
+
+	recordAudit(c, "pipeline.retry", "pipeline_run", run.ID, before, gin.H{"status": pipelineActive, "stage": run.Stage})
+	wakePipeline()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Обработка заявки перезапущена", "run_id": run.ID})
+}
@@ -2,11 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // POSApplication заявка на кредит для ПОС
@@ -74,6 +76,14 @@ func CreatePOSApplication(c *gin.Context) {
 		return
 	}
 
+	var application Application
+	if !findScopedApplication(c, applicationID, &application) {
+		return
+	}
+	if !checkIfMatch(c, &application) || !checkApplicationEditable(c, &application) {
+		return
+	}
+
 	// Создание заявки на ПОС
 	posApplication := POSApplication{
 		ApplicationID:      uint(applicationID),
@@ -92,22 +102,26 @@ func CreatePOSApplication(c *gin.Context) {
 		UpdatedAt:          time.Now(),
 	}
 
-	// Сохранение в базу данных
-	if err := db.Create(&posApplication).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания заявки на ПОС"})
+	// Сохранение вместе со сменой типа основной заявки
+	application.Type = "pos_credit"
+	application.UpdatedAt = time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&posApplication).Error; err != nil {
+			return err
+		}
+		return saveApplication(tx, &application, "Type", "UpdatedAt")
+	})
+	if errors.Is(err, errVersionConflict) {
+		respondVersionConflict(c, &application)
 		return
 	}
-
-	// Обновление основной заявки
-	var application Application
-	if err := db.First(&application, applicationID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Заявка не найдена"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания заявки на ПОС"})
 		return
 	}
+	recordAudit(c, "application.pos_create", "pos_application", posApplication.ID, nil, posApplication)
 
-	application.Type = "pos_credit"
-	application.UpdatedAt = time.Now()
-	db.Save(&application)
+	setApplicationETag(c, &application)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message":         "Заявка на ПОС создана",
@@ -142,6 +156,14 @@ func CreateGuaranteeApplication(c *gin.Context) {
 		return
 	}
 
+	var application Application
+	if !findScopedApplication(c, applicationID, &application) {
+		return
+	}
+	if !checkIfMatch(c, &application) || !checkApplicationEditable(c, &application) {
+		return
+	}
+
 	// Создание заявки на банковскую гарантию
 	guaranteeApplication := GuaranteeApplication{
 		ApplicationID:      uint(applicationID),
@@ -160,22 +182,26 @@ func CreateGuaranteeApplication(c *gin.Context) {
 		UpdatedAt:          time.Now(),
 	}
 
-	// Сохранение в базу данных
-	if err := db.Create(&guaranteeApplication).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания заявки на банковскую гарантию"})
+	// Сохранение вместе со сменой типа основной заявки
+	application.Type = "guarantee"
+	application.UpdatedAt = time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&guaranteeApplication).Error; err != nil {
+			return err
+		}
+		return saveApplication(tx, &application, "Type", "UpdatedAt")
+	})
+	if errors.Is(err, errVersionConflict) {
+		respondVersionConflict(c, &application)
 		return
 	}
-
-	// Обновление основной заявки
-	var application Application
-	if err := db.First(&application, applicationID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Заявка не найдена"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания заявки на банковскую гарантию"})
 		return
 	}
+	recordAudit(c, "application.guarantee_create", "guarantee_application", guaranteeApplication.ID, nil, guaranteeApplication)
 
-	application.Type = "guarantee"
-	application.UpdatedAt = time.Now()
-	db.Save(&application)
+	setApplicationETag(c, &application)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message":               "Заявка на банковскую гарантию создана",
@@ -187,6 +213,11 @@ func CreateGuaranteeApplication(c *gin.Context) {
 func GetPOSApplication(c *gin.Context) {
 	applicationID := c.Param("id")
 
+	var application Application
+	if !findScopedApplication(c, applicationID, &application) {
+		return
+	}
+
 	var posApplication POSApplication
 	if err := db.Where("application_id = ?", applicationID).First(&posApplication).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Заявка на ПОС не найдена"})
@@ -200,6 +231,11 @@ func GetPOSApplication(c *gin.Context) {
 func GetGuaranteeApplication(c *gin.Context) {
 	applicationID := c.Param("id")
 
+	var application Application
+	if !findScopedApplication(c, applicationID, &application) {
+		return
+	}
+
 	var guaranteeApplication GuaranteeApplication
 	if err := db.Where("application_id = ?", applicationID).First(&guaranteeApplication).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Заявка на банковскую гарантию не найдена"})
@@ -222,8 +258,7 @@ func GeneratePOSDocument(c *gin.Context) {
 
 	// Получение основной заявки
 	var application Application
-	if err := db.First(&application, applicationID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Заявка не найдена"})
+	if !findScopedApplication(c, applicationID, &application) {
 		return
 	}
 
@@ -275,8 +310,7 @@ func GenerateGuaranteeDocument(c *gin.Context) {
 
 	// Получение основной заявки
 	var application Application
-	if err := db.First(&application, applicationID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Заявка не найдена"})
+	if !findScopedApplication(c, applicationID, &application) {
 		return
 	}
 
@@ -1,32 +1,253 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
-	"strconv"
-	"tenderhelp/internal/database"
+	"strings"
 	"tenderhelp/internal/models"
+	"tenderhelp/internal/statemachine"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
-func GetRequests(c *gin.Context) {
-	db := database.InitDB()
+// legacyStatuses статусы заявок старого образца и соответствующие им статусы заявки
+var legacyStatuses = map[string]statemachine.State{
+	"Черновик":  statusDraft,
+	"В работе":  statusInReview,
+	"Одобрено":  statusApproved,
+	"Отклонено": statusRejected,
+}
+
+// legacyRequestColumns колонки заявки, которые нужны для /api/requests
+var legacyRequestColumns = []string{
+	"id", "client_id", "agent_id", "manager_id", "type", "amount", "status", "bank", "version",
+	"number", "notice_number", "law_number", "term", "start_date", "end_date", "signature",
+	"created_at", "updated_at",
+}
+
+// legacyStatusName статус заявки в представлении /api/requests
+func legacyStatusName(status string) string {
+	switch statemachine.State(status) {
+	case statusSubmitted, statusInReview:
+		return "В работе"
+	case statusApproved, statusSentToBanks:
+		return "Одобрено"
+	case statusRejected:
+		return "Отклонено"
+	}
+	return "Черновик"
+}
+
+// legacyStatusFilter статусы заявки, которые показываются старым статусом name
+func legacyStatusFilter(name string) []string {
+	var statuses []string
+	for _, status := range []statemachine.State{statusDraft, statusSubmitted, statusInReview, statusApproved, statusRejected, statusSentToBanks} {
+		if legacyStatusName(string(status)) == name {
+			statuses = append(statuses, string(status))
+		}
+	}
+	return statuses
+}
+
+// legacyRequestType тип заявки по номеру: "К ..." - кредит, остальные
+// ("БГ ...") - банковская гарантия
+func legacyRequestType(number string) string {
+	if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(number)), "К") {
+		return "credit"
+	}
+	return "guarantee"
+}
+
+// applyLegacyRequest переносит в заявку сумму, банк и данные закупки
+func applyLegacyRequest(application *Application, request models.Request) {
+	application.Amount = request.Amount
+	application.Bank = request.Bank
+	application.TenderDetails = TenderDetails{
+		Number:       request.Number,
+		NoticeNumber: request.NoticeNumber,
+		LawNumber:    request.LawNumber,
+		Term:         request.Term,
+		Signature:    request.Signature,
+	}
+	if !request.StartDate.IsZero() {
+		startDate := request.StartDate
+		application.StartDate = &startDate
+	}
+	if !request.EndDate.IsZero() {
+		endDate := request.EndDate
+		application.EndDate = &endDate
+	}
+}
+
+// legacyRequestViews представляет заявки в формате старого API с клиентом,
+// агентом и именем менеджера
+func legacyRequestViews(applications []Application) ([]models.Request, error) {
+	var clientIDs, userIDs []uint
+	for _, application := range applications {
+		clientIDs = append(clientIDs, application.ClientID)
+		userIDs = append(userIDs, application.AgentID, application.ManagerID)
+	}
+	var clients []models.Client
+	if err := db.Where("id IN ?", uniqueIDs(clientIDs)).Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := db.Where("id IN ?", uniqueIDs(userIDs)).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	clientsByID := make(map[uint]models.Client, len(clients))
+	for _, client := range clients {
+		clientsByID[client.ID] = client
+	}
+	usersByID := make(map[uint]models.User, len(users))
+	for _, user := range users {
+		usersByID[user.ID] = user
+	}
+
+	requests := make([]models.Request, len(applications))
+	for i, application := range applications {
+		request := &requests[i]
+		request.ID = application.ID
+		request.CreatedAt = application.CreatedAt
+		request.UpdatedAt = application.UpdatedAt
+		request.Number = application.Number
+		request.ClientID = application.ClientID
+		request.Client = clientsByID[application.ClientID]
+		request.AgentID = application.AgentID
+		request.Agent = usersByID[application.AgentID]
+		if manager, ok := usersByID[application.ManagerID]; ok && application.ManagerID != 0 {
+			if application.Type == "credit" {
+				request.CreditManager = manager.Name
+			} else {
+				request.BGManager = manager.Name
+			}
+		}
+		request.Term = application.Term
+		request.Amount = application.Amount
+		request.Bank = application.Bank
+		request.NoticeNumber = application.NoticeNumber
+		request.LawNumber = application.LawNumber
+		request.Status = legacyStatusName(application.Status)
+		request.Signature = application.Signature
+		if application.StartDate != nil {
+			request.StartDate = *application.StartDate
+		}
+		if application.EndDate != nil {
+			request.EndDate = *application.EndDate
+		}
+	}
+	return requests, nil
+}
+
+// MigrateLegacyRequests переносит заявки старого образца (models.Request) в
+// заявки: данные закупки, клиента, агента, менеджера (по имени) и статус.
+// Перенесенная заявка ссылается на исходную через LegacyRequestID, поэтому
+// повторный запуск переносит только новые. Возвращает число перенесенных.
+func MigrateLegacyRequests() (int, error) {
+	migratedIDs := db.Model(&Application{}).Unscoped().Select("legacy_request_id").Where("legacy_request_id IS NOT NULL")
 	var requests []models.Request
+	if err := db.Where("id NOT IN (?)", migratedIDs).Order("id").Find(&requests).Error; err != nil {
+		return 0, err
+	}
+
+	managers := make(map[string]uint)
+	migrated := 0
+	for _, request := range requests {
+		requestID := request.ID
+		application := Application{
+			ClientID:        request.ClientID,
+			AgentID:         request.AgentID,
+			Type:            legacyRequestType(request.Number),
+			Status:          string(statusDraft),
+			Version:         1,
+			LegacyRequestID: &requestID,
+			CreatedAt:       request.CreatedAt,
+			UpdatedAt:       request.UpdatedAt,
+		}
+		applyLegacyRequest(&application, request)
+		if status, ok := legacyStatuses[request.Status]; ok {
+			application.Status = string(status)
+		}
+
+		// Менеджер старой заявки указан именем пользователя
+		name := strings.TrimSpace(request.BGManager)
+		if application.Type == "credit" {
+			name = strings.TrimSpace(request.CreditManager)
+		}
+		if managerID, ok := managers[name]; ok || name == "" {
+			application.ManagerID = managerID
+		} else {
+			var user models.User
+			if err := db.Where("name = ?", name).First(&user).Error; err == nil {
+				application.ManagerID = user.ID
+			} else {
+				log.Printf("Менеджер %q заявки %s не найден среди пользователей", name, request.Number)
+			}
+			managers[name] = application.ManagerID
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&application).Error; err != nil {
+				return err
+			}
+			// Время смены статуса неизвестно: берется время последнего изменения
+			return tx.Create(&StatusHistory{
+				ApplicationID: application.ID,
+				Status:        application.Status,
+				ActorID:       application.AgentID,
+				Timestamp:     request.UpdatedAt,
+				Comment:       fmt.Sprintf("Перенесено из заявки %s, статус «%s»", request.Number, request.Status),
+			}).Error
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("заявка %d: %w", request.ID, err)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
 
-	// Получаем параметры фильтрации
-	status := c.Query("status")
-	clientID := c.Query("client_id")
+// MigrateRequests переносит заявки старого образца, созданные после запуска
+func MigrateRequests(c *gin.Context) {
+	migrated, err := MigrateLegacyRequests()
+	if migrated > 0 {
+		recordAudit(c, "request.migrate", "request", "", nil, gin.H{"migrated": migrated})
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка переноса заявок: " + err.Error(), "migrated": migrated})
+		return
+	}
 
-	query := db.Preload("Client").Preload("Agent")
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Заявки старого образца перенесены",
+		"migrated": migrated,
+	})
+}
 
-	if status != "" {
-		query = query.Where("status = ?", status)
+// GetRequests возвращает заявки в формате старого API
+func GetRequests(c *gin.Context) {
+	query := db.Scopes(scopeApplications(c)).Select(legacyRequestColumns).Order("id")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status IN ?", legacyStatusFilter(status))
 	}
-	if clientID != "" {
+	if clientID := c.Query("client_id"); clientID != "" {
 		query = query.Where("client_id = ?", clientID)
 	}
 
-	query.Find(&requests)
+	var applications []Application
+	if err := query.Find(&applications).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения заявок"})
+		return
+	}
+	requests, err := legacyRequestViews(applications)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения заявок"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"data":  requests,
@@ -34,42 +255,137 @@ func GetRequests(c *gin.Context) {
 	})
 }
 
+// CreateRequest создает черновик заявки из запроса старого API
 func CreateRequest(c *gin.Context) {
-	db := database.InitDB()
 	var request models.Request
-
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if request.Status != "" && request.Status != legacyStatusName(string(statusDraft)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Заявка создается черновиком, статус меняется через /api/applications"})
+		return
+	}
+
+	clientID, ok := resolveApplicationClient(c, request.ClientID)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Нет доступа к клиенту"})
+		return
+	}
+
+	application := newDraft(CreateApplicationRequest{Type: legacyRequestType(request.Number)}, clientID, currentActor(c).UserID)
+	applyLegacyRequest(&application, request)
+	if _, ok := checkDuplicates(c, application, "", duplicateStageCreate); !ok {
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return createDraft(tx, &application, "Заявка создана")
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания заявки"})
+		return
+	}
 
-	db.Create(&request)
-	c.JSON(http.StatusCreated, request)
+	recordAudit(c, "application.create", "application", application.ID, nil, application.maskedPII())
+	respondLegacyRequest(c, http.StatusCreated, application)
 }
 
+// UpdateRequest обновляет сумму, банк, клиента и данные закупки черновика.
+// Поля, не переданные в запросе, не меняются.
 func UpdateRequest(c *gin.Context) {
-	db := database.InitDB()
-	id, _ := strconv.Atoi(c.Param("id"))
-
-	var request models.Request
-	if err := db.First(&request, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Заявка не найдена"})
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+	if !checkIfMatch(c, &application) || !checkApplicationEditable(c, &application) {
 		return
 	}
 
+	views, err := legacyRequestViews([]Application{application})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения заявки"})
+		return
+	}
+	request := views[0]
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if request.Status != legacyStatusName(application.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Статус заявки меняется через /api/applications"})
+		return
+	}
+
+	before := application
+	if request.ClientID != application.ClientID {
+		clientID, ok := resolveApplicationClient(c, request.ClientID)
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Нет доступа к клиенту"})
+			return
+		}
+		application.ClientID = clientID
+	}
+	applyLegacyRequest(&application, request)
+	application.UpdatedAt = time.Now()
+
+	// Другая сумма, банк или клиент могут совпасть с уже поданной заявкой
+	if application.Amount != before.Amount || application.Bank != before.Bank || application.ClientID != before.ClientID {
+		if _, ok := checkDuplicates(c, application, "", duplicateStageCreate); !ok {
+			return
+		}
+	}
+
+	err = saveApplication(db, &application, "ClientID", "Amount", "Bank", "Number", "NoticeNumber",
+		"LawNumber", "Term", "StartDate", "EndDate", "Signature", "UpdatedAt")
+	if errors.Is(err, errVersionConflict) {
+		respondVersionConflict(c, &application)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления заявки"})
+		return
+	}
 
-	db.Save(&request)
-	c.JSON(http.StatusOK, request)
+	recordAudit(c, "application.update", "application", application.ID, before.maskedPII(), application.maskedPII())
+	respondLegacyRequest(c, http.StatusOK, application)
 }
 
+// DeleteRequest удаляет черновик заявки. Заявки в работе не удаляются, их
+// можно только отклонить.
 func DeleteRequest(c *gin.Context) {
-	db := database.InitDB()
-	id, _ := strconv.Atoi(c.Param("id"))
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+	if !checkIfMatch(c, &application) {
+		return
+	}
 
-	db.Delete(&models.Request{}, id)
+	if application.Status != string(statusDraft) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Удалить можно только черновик"})
+		return
+	}
+	result := db.Where("status = ?", string(statusDraft)).Delete(&application)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка удаления заявки"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Удалить можно только черновик"})
+		return
+	}
+
+	recordAudit(c, "application.delete", "application", application.ID, application.maskedPII(), nil)
 	c.JSON(http.StatusOK, gin.H{"message": "Заявка удалена"})
 }
+
+// respondLegacyRequest отвечает заявкой в формате старого API
+func respondLegacyRequest(c *gin.Context, status int, application Application) {
+	views, err := legacyRequestViews([]Application{application})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения заявки"})
+		return
+	}
+	setApplicationETag(c, &application)
+	c.JSON(status, views[0])
+}
@@ -0,0 +1,519 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"tenderhelp/internal/jsondiff"
+	"tenderhelp/internal/pii"
+	"tenderhelp/internal/questionnaire"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ApplicationRevision неизменяемая версия данных одного шага анкеты.
+// Каждое сохранение шага добавляет ревизию с полным содержимым шага, поэтому
+// по ревизиям можно восстановить анкету на любой момент времени.
+// Персональные данные хранятся зашифрованными ключом данных заявки.
+type ApplicationRevision struct {
+	ID            uint            `json:"id" gorm:"primaryKey"`
+	ApplicationID uint            `json:"application_id" gorm:"uniqueIndex:idx_application_revision"`
+	Step          string          `json:"step" gorm:"uniqueIndex:idx_application_revision"`
+	Number        int             `json:"number" gorm:"uniqueIndex:idx_application_revision"` // номер ревизии шага, начиная с 1
+	Data          json.RawMessage `json:"data,omitempty" gorm:"type:jsonb"`
+	Status        string          `json:"status"` // статус заявки на момент изменения
+	Source        string          `json:"source"` // create, update, restore, baseline
+	RestoredFrom  *uint           `json:"restored_from,omitempty"`
+	ActorID       uint            `json:"actor_id"` // 0 - система
+	CreatedAt     time.Time       `json:"created_at" gorm:"index"`
+}
+
+// Источники ревизий
+const (
+	revisionCreate  = "create"
+	revisionUpdate  = "update"
+	revisionRestore = "restore"
+	// revisionBaseline данные шага, заполненные до появления истории изменений
+	revisionBaseline = "baseline"
+)
+
+var errRevisionImmutable = errors.New("ревизии анкеты не изменяются и не удаляются")
+
+// BeforeUpdate запрещает изменение ревизий. Ротация ключей шифрования
+// обновляет данные через UpdateColumns, который хуки не вызывает.
+func (r *ApplicationRevision) BeforeUpdate(tx *gorm.DB) error {
+	return errRevisionImmutable
+}
+
+// BeforeDelete запрещает удаление ревизий
+func (r *ApplicationRevision) BeforeDelete(tx *gorm.DB) error {
+	return errRevisionImmutable
+}
+
+// stepPIISection возвращает раздел с персональными данными, соответствующий
+// шагу анкеты. Для шагов без персональных данных ok равно false.
+func (a *Application) stepPIISection(step string) (piiSection, bool) {
+	data, ok := a.stepData(step)
+	if !ok {
+		return piiSection{}, false
+	}
+	for _, section := range a.piiSections() {
+		if section.data == data {
+			return section, true
+		}
+	}
+	return piiSection{}, false
+}
+
+// transformStepPII применяет fn к персональным данным шага. Пути полей
+// совпадают с путями в самой заявке, поэтому данные ревизии шифруются и
+// расшифровываются тем же ключом.
+func (a *Application) transformStepPII(step string, data json.RawMessage, fn func(path string, value interface{}) (interface{}, error)) (json.RawMessage, error) {
+	section, ok := a.stepPIISection(step)
+	if !ok || len(data) == 0 {
+		return data, nil
+	}
+	prefix := section.name + "."
+	return pii.TransformPaths(data, section.paths, func(path string, value interface{}) (interface{}, error) {
+		return fn(prefix+path, value)
+	})
+}
+
+// stepCipher возвращает шифр ключа данных заявки (nil - заявка не зашифрована)
+func (a *Application) stepCipher() (*pii.Cipher, error) {
+	if a.PIIDataKey == "" {
+		return nil, nil
+	}
+	if piiKeyring == nil {
+		return nil, errPIIKeysMissing
+	}
+	dataKey, err := piiKeyring.UnwrapDataKey(a.PIIKeyID, a.PIIDataKey)
+	if err != nil {
+		return nil, err
+	}
+	return pii.NewCipher(dataKey)
+}
+
+// encryptStepPII шифрует персональные данные шага ключом заявки
+func (a *Application) encryptStepPII(step string, data json.RawMessage) (json.RawMessage, error) {
+	c, err := a.stepCipher()
+	if err != nil || c == nil {
+		return data, err
+	}
+	return a.transformStepPII(step, data, c.EncryptValue)
+}
+
+// decryptStepPII расшифровывает персональные данные шага
+func (a *Application) decryptStepPII(step string, data json.RawMessage) (json.RawMessage, error) {
+	c, err := a.stepCipher()
+	if err != nil || c == nil {
+		return data, err
+	}
+	return a.transformStepPII(step, data, c.DecryptValue)
+}
+
+// maskStepPII скрывает персональные данные шага
+func (a *Application) maskStepPII(step string, data json.RawMessage) json.RawMessage {
+	masked, err := a.transformStepPII(step, data, func(_ string, value interface{}) (interface{}, error) {
+		return pii.Mask(value), nil
+	})
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return masked
+}
+
+// createRevision сохраняет ревизию шага. В revision заполняются Step, Data
+// (в открытом виде), Source и автор; номер, статус и время проставляются здесь.
+func createRevision(tx *gorm.DB, application *Application, revision ApplicationRevision) (ApplicationRevision, error) {
+	var count int64
+	if err := tx.Model(&ApplicationRevision{}).
+		Where("application_id = ? AND step = ?", application.ID, revision.Step).
+		Count(&count).Error; err != nil {
+		return revision, err
+	}
+
+	plain := revision.Data
+	stored, err := application.encryptStepPII(revision.Step, revision.Data)
+	if err != nil {
+		return revision, err
+	}
+
+	revision.ApplicationID = application.ID
+	revision.Number = int(count) + 1
+	revision.Data = stored
+	if revision.Status == "" {
+		revision.Status = application.Status
+	}
+	if revision.CreatedAt.IsZero() {
+		revision.CreatedAt = application.UpdatedAt
+	}
+	if err := tx.Create(&revision).Error; err != nil {
+		return revision, err
+	}
+
+	revision.Data = plain
+	return revision, nil
+}
+
+// updateStepData заменяет данные шага и сохраняет их новой ревизией. Если
+// у шага еще нет ревизий, прежние данные сохраняются базовой ревизией.
+func updateStepData(application *Application, step string, data json.RawMessage, revision ApplicationRevision) (ApplicationRevision, error) {
+	section, ok := application.stepData(step)
+	if !ok {
+		return revision, errors.New("неизвестный шаг " + step)
+	}
+	previous, previousAt := *section, application.UpdatedAt
+
+	*section = data
+	application.UpdatedAt = time.Now()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := saveApplication(tx, application, applicationDataFields...); err != nil {
+			return err
+		}
+
+		if len(previous) > 0 {
+			var count int64
+			tx.Model(&ApplicationRevision{}).Where("application_id = ? AND step = ?", application.ID, step).Count(&count)
+			if count == 0 {
+				baseline := ApplicationRevision{Step: step, Data: previous, Source: revisionBaseline, CreatedAt: previousAt}
+				if _, err := createRevision(tx, application, baseline); err != nil {
+					return err
+				}
+			}
+		}
+
+		revision.Step = step
+		revision.Data = data
+		var err error
+		revision, err = createRevision(tx, application, revision)
+		return err
+	})
+	return revision, err
+}
+
+// recordInitialRevisions сохраняет первые ревизии заполненных шагов новой заявки
+func recordInitialRevisions(tx *gorm.DB, application *Application, actorID uint) error {
+	for _, step := range questionnaire.Get().Steps {
+		data, _ := application.stepData(step.Name)
+		if data == nil || len(*data) == 0 {
+			continue
+		}
+		revision := ApplicationRevision{Step: step.Name, Data: *data, Source: revisionCreate, ActorID: actorID}
+		if _, err := createRevision(tx, application, revision); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encryptRevisionsPII шифрует ревизии заявки, сохраненные до включения шифрования
+func encryptRevisionsPII(application *Application) error {
+	var revisions []ApplicationRevision
+	if err := db.Where("application_id = ?", application.ID).Find(&revisions).Error; err != nil {
+		return err
+	}
+	for _, revision := range revisions {
+		if _, ok := application.stepPIISection(revision.Step); !ok || len(revision.Data) == 0 {
+			continue
+		}
+		encrypted, err := application.encryptStepPII(revision.Step, revision.Data)
+		if err != nil {
+			return err
+		}
+		if err := db.Model(&ApplicationRevision{}).Where("id = ?", revision.ID).UpdateColumn("data", encrypted).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// presentRevision расшифровывает данные ревизии и скрывает персональные
+// данные от пользователей без права view_pii
+func presentRevision(c *gin.Context, application *Application, revision ApplicationRevision) (ApplicationRevision, error) {
+	data, err := application.decryptStepPII(revision.Step, revision.Data)
+	if err != nil {
+		return revision, err
+	}
+	if !canViewPII(c) {
+		data = application.maskStepPII(revision.Step, data)
+	}
+	revision.Data = data
+	return revision, nil
+}
+
+// findRevision загружает ревизию заявки. При ошибке ответ уже отправлен клиенту.
+func findRevision(c *gin.Context, application *Application, id string, revision *ApplicationRevision) bool {
+	if err := db.Where("id = ? AND application_id = ?", id, application.ID).First(revision).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ревизия не найдена"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения ревизии"})
+		}
+		return false
+	}
+	return true
+}
+
+// revisionMoment разбирает параметр at: дата в формате RFC3339 или
+// submitted - момент последней отправки заявки на рассмотрение
+func revisionMoment(application *Application, at string) (time.Time, error) {
+	if at != "submitted" {
+		return time.Parse(time.RFC3339, at)
+	}
+
+	var entry StatusHistory
+	err := db.Where("application_id = ? AND status = ?", application.ID, string(statusSubmitted)).
+		Order("timestamp DESC").
+		First(&entry).Error
+	if err != nil {
+		return time.Time{}, errors.New("заявка не отправлялась на рассмотрение")
+	}
+	return entry.Timestamp, nil
+}
+
+// GetApplicationRevisions возвращает ревизии заявки без данных (фильтр по
+// шагу - step). С параметром at возвращает анкету на указанный момент:
+// последнюю ревизию каждого шага вместе с данными.
+func GetApplicationRevisions(c *gin.Context) {
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+
+	if at := c.Query("at"); at != "" {
+		moment, err := revisionMoment(&application, at)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный параметр at: " + err.Error()})
+			return
+		}
+
+		revisions := []ApplicationRevision{}
+		for _, step := range questionnaire.Get().Steps {
+			var revision ApplicationRevision
+			err := db.Where("application_id = ? AND step = ? AND created_at <= ?", application.ID, step.Name, moment).
+				Order("number DESC").
+				First(&revision).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			if err == nil {
+				revision, err = presentRevision(c, &application, revision)
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения ревизий"})
+				return
+			}
+			revisions = append(revisions, revision)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"at": moment, "revisions": revisions})
+		return
+	}
+
+	query := db.Omit("data").Where("application_id = ?", application.ID)
+	if step := c.Query("step"); step != "" {
+		query = query.Where("step = ?", step)
+	}
+
+	var revisions []ApplicationRevision
+	if err := query.Order("created_at DESC, id DESC").Find(&revisions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения ревизий"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revisions": revisions})
+}
+
+// GetApplicationRevision возвращает ревизию вместе с данными шага
+func GetApplicationRevision(c *gin.Context) {
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+
+	var revision ApplicationRevision
+	if !findRevision(c, &application, c.Param("revisionId"), &revision) {
+		return
+	}
+
+	revision, err := presentRevision(c, &application, revision)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения ревизии"})
+		return
+	}
+
+	c.JSON(http.StatusOK, revision)
+}
+
+// DiffApplicationRevisions сравнивает две ревизии одного шага (from, to).
+// Без to ревизия from сравнивается с последней ревизией шага. Пути полей
+// начинаются с имени раздела, как в ошибках валидации анкеты.
+func DiffApplicationRevisions(c *gin.Context) {
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+
+	var from, to ApplicationRevision
+	if !findRevision(c, &application, c.Query("from"), &from) {
+		return
+	}
+	if toID := c.Query("to"); toID != "" {
+		if !findRevision(c, &application, toID, &to) {
+			return
+		}
+	} else if err := db.Where("application_id = ? AND step = ?", application.ID, from.Step).Order("number DESC").First(&to).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения ревизии"})
+		return
+	}
+
+	if from.Step != to.Step {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Сравнивать можно только ревизии одного шага"})
+		return
+	}
+
+	changes, err := diffRevisions(c, &application, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сравнения ревизий"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"step":    from.Step,
+		"from":    from.ID,
+		"to":      to.ID,
+		"changes": changes,
+	})
+}
+
+// diffRevisions сравнивает расшифрованные данные ревизий. Пользователям без
+// права view_pii значения персональных данных отдаются скрытыми, но сами
+// изменения видны.
+func diffRevisions(c *gin.Context, application *Application, from, to ApplicationRevision) ([]jsondiff.Change, error) {
+	left, err := application.decryptStepPII(from.Step, from.Data)
+	if err != nil {
+		return nil, err
+	}
+	right, err := application.decryptStepPII(to.Step, to.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := jsondiff.Diff(left, right)
+	if err != nil {
+		return nil, err
+	}
+
+	if !canViewPII(c) {
+		maskedLeft := application.maskStepPII(from.Step, left)
+		maskedRight := application.maskStepPII(to.Step, right)
+		for i := range changes {
+			changes[i].Old = valueAt(maskedLeft, changes[i].Path)
+			changes[i].New = valueAt(maskedRight, changes[i].Path)
+		}
+	}
+
+	field := from.Step
+	if step, ok := questionnaire.FindStep(from.Step); ok {
+		field = step.Field
+	}
+	for i := range changes {
+		changes[i].Path = joinPath(field, changes[i].Path)
+	}
+	return changes, nil
+}
+
+// valueAt возвращает значение по пути jsondiff ("children.0.name")
+func valueAt(doc json.RawMessage, path string) interface{} {
+	var value interface{}
+	if len(doc) == 0 || json.Unmarshal(doc, &value) != nil {
+		return nil
+	}
+	if path == "" {
+		return value
+	}
+
+	for _, segment := range strings.Split(path, ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			value = node[segment]
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil
+			}
+			value = node[index]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+func joinPath(prefix, path string) string {
+	if path == "" {
+		return prefix
+	}
+	return prefix + "." + path
+}
+
+// RestoreApplicationRevision восстанавливает данные шага из ревизии.
+// Восстановление записывается новой ревизией, история не переписывается.
+// Как и обновление, доступно только для черновика.
+func RestoreApplicationRevision(c *gin.Context) {
+	var application Application
+	if !findScopedApplication(c, c.Param("id"), &application) {
+		return
+	}
+	if !checkIfMatch(c, &application) || !checkApplicationEditable(c, &application) {
+		return
+	}
+
+	var source ApplicationRevision
+	if !findRevision(c, &application, c.Param("revisionId"), &source) {
+		return
+	}
+
+	data, err := application.decryptStepPII(source.Step, source.Data)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения ревизии"})
+		return
+	}
+	if err := validateStepData(source.Step, data); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	before := application
+	revision, err := updateStepData(&application, source.Step, data, ApplicationRevision{
+		Source:       revisionRestore,
+		RestoredFrom: &source.ID,
+		ActorID:      currentActor(c).UserID,
+	})
+	if errors.Is(err, errVersionConflict) {
+		respondVersionConflict(c, &application)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка восстановления ревизии"})
+		return
+	}
+
+	recordAudit(c, "application.restore_revision", "application", application.ID, before.maskedPII(), application.maskedPII())
+	setApplicationETag(c, &application)
+
+	if !canViewPII(c) {
+		revision.Data = application.maskStepPII(revision.Step, revision.Data)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Данные шага восстановлены из ревизии",
+		"application": presentApplication(c, application),
+		"revision":    revision,
+	})
+}
@@ -0,0 +1,530 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"tenderhelp/internal/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Permission право доступа, проверяемое через RequirePermission
+type Permission struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Code        string    `json:"code" gorm:"uniqueIndex"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Role роль пользователя с настраиваемым набором прав
+type Role struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Name        string `json:"name" gorm:"uniqueIndex"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	IsSystem    bool   `json:"is_system"`
+	RequireMFA  bool   `json:"require_mfa"`
+	// Роль можно выбрать при публичной регистрации (/api/register)
+	SelfRegister bool         `json:"self_register"`
+	Permissions  []Permission `json:"permissions" gorm:"many2many:role_permissions"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+// RoleRequest запрос на создание или изменение роли
+type RoleRequest struct {
+	Name         string   `json:"name"`
+	Title        string   `json:"title" binding:"required"`
+	Description  string   `json:"description"`
+	RequireMFA   bool     `json:"require_mfa"`
+	SelfRegister bool     `json:"self_register"`
+	Permissions  []string `json:"permissions"`
+}
+
+// PermissionRequest запрос на создание права
+type PermissionRequest struct {
+	Code        string `json:"code" binding:"required"`
+	Description string `json:"description"`
+}
+
+// defaultPermissions права, создаваемые при первом запуске
+var defaultPermissions = []Permission{
+	{Code: "view_applications", Description: "Просмотр заявок"},
+	{Code: "create_applications", Description: "Создание заявок"},
+	{Code: "manage_applications", Description: "Редактирование и отправка заявок"},
+	{Code: "view_clients", Description: "Просмотр клиентов"},
+	{Code: "manage_clients", Description: "Управление клиентами"},
+	{Code: "view_analytics", Description: "Просмотр аналитики"},
+	{Code: "manage_sessions", Description: "Завершение сессий других пользователей"},
+	{Code: "view_all_applications", Description: "Просмотр заявок всех агентов и клиентов"},
+	{Code: "view_all_clients", Description: "Просмотр всех клиентов"},
+	{Code: "submit_bank_decisions", Description: "Передача решений банка по заявкам"},
+	{Code: "view_pii", Description: "Просмотр паспортных и контактных данных клиентов"},
+	{Code: "assign_managers", Description: "Назначение менеджеров на заявки и настройка их нагрузки"},
+	{Code: "manage_sla", Description: "Настройка нормативов сроков и получение эскалаций"},
+	{Code: "manage_users", Description: "Регистрация пользователей с любой ролью"},
+}
+
+// defaultRoles системные роли и их права при первом запуске
+var defaultRoles = []struct {
+	Name         string
+	Title        string
+	RequireMFA   bool
+	SelfRegister bool
+	Permissions  []string
+}{
+	{"admin", "Администратор", true, false, []string{"view_applications", "create_applications", "manage_applications", "view_clients", "manage_clients", "view_analytics", "manage_sessions", "view_all_applications", "view_all_clients", "view_pii", "assign_managers", "manage_sla", "manage_users"}},
+	{"director", "Директор", true, false, []string{"view_applications", "create_applications", "manage_applications", "view_clients", "manage_clients", "view_analytics", "manage_sessions", "view_all_applications", "view_all_clients", "view_pii", "assign_managers", "manage_sla", "manage_users"}},
+	{"manager", "Менеджер", false, false, []string{"view_applications", "create_applications", "manage_applications", "view_clients", "manage_clients", "manage_sessions", "view_all_applications", "view_all_clients"}},
+	{"agent", "Агент", false, false, []string{"view_applications", "create_applications", "manage_applications", "view_clients", "manage_clients"}},
+	{"user", "Пользователь", false, true, []string{"view_applications", "create_applications", "view_clients"}},
+	{"partner-bank", "Банк-партнер", true, false, []string{"view_applications", "submit_bank_decisions", "view_pii"}},
+	{"client", "Клиент", false, true, []string{"view_applications", "create_applications"}},
+}
+
+// mandatoryMFARoles роли, для которых 2FA обязательна независимо от настроек:
+// им доступны паспортные данные клиентов
+var mandatoryMFARoles = map[string]bool{
+	"admin":        true,
+	"director":     true,
+	"partner-bank": true,
+}
+
+// roleNamePattern допустимый формат имени роли
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,49}$`)
+
+// permissionCacheTTL период, после которого кэш перечитывается из БД,
+// даже если не был сброшен явно
+const permissionCacheTTL = 5 * time.Minute
+
+// permissionCache кэш прав ролей
+type permissionCache struct {
+	mutex    sync.RWMutex
+	loadedAt time.Time
+	roles    map[string]map[string]bool
+	mfa      map[string]bool
+	public   map[string]bool
+}
+
+// rolePermissions глобальный кэш прав ролей
+var rolePermissions = &permissionCache{}
+
+// EnsureDefaultRoles создает системные роли и права, если их еще нет.
+// Существующие записи не перезаписываются, чтобы не терять ручные изменения;
+// только что добавленные права выдаются существующим системным ролям.
+func EnsureDefaultRoles() error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		created := make(map[string]Permission)
+		for _, permission := range defaultPermissions {
+			var count int64
+			tx.Model(&Permission{}).Where("code = ?", permission.Code).Count(&count)
+			if count > 0 {
+				continue
+			}
+
+			p := permission
+			if err := tx.Create(&p).Error; err != nil {
+				return err
+			}
+			created[p.Code] = p
+		}
+
+		for _, def := range defaultRoles {
+			var existing Role
+			err := tx.Where("name = ?", def.Name).First(&existing).Error
+			if err == nil {
+				if err := grantNewPermissions(tx, &existing, def.Permissions, created); err != nil {
+					return err
+				}
+				// Признак публичной регистрации появился позже ролей: у
+				// существующих ролей он пустой, пока не задан
+				if err := tx.Model(&Role{}).Where("id = ? AND self_register IS NULL", existing.ID).
+					Update("self_register", def.SelfRegister).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err != gorm.ErrRecordNotFound {
+				return err
+			}
+
+			var permissions []Permission
+			if err := tx.Where("code IN ?", def.Permissions).Find(&permissions).Error; err != nil {
+				return err
+			}
+
+			role := Role{
+				Name:         def.Name,
+				Title:        def.Title,
+				IsSystem:     true,
+				RequireMFA:   def.RequireMFA,
+				SelfRegister: def.SelfRegister,
+				Permissions:  permissions,
+			}
+			if err := tx.Create(&role).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// grantNewPermissions выдает существующей роли права, появившиеся в новой версии
+func grantNewPermissions(tx *gorm.DB, role *Role, codes []string, created map[string]Permission) error {
+	var grant []Permission
+	for _, code := range codes {
+		if p, ok := created[code]; ok {
+			grant = append(grant, p)
+		}
+	}
+	if len(grant) == 0 {
+		return nil
+	}
+	return tx.Model(role).Association("Permissions").Append(grant)
+}
+
+// snapshot возвращает актуальную карту прав, при необходимости перечитывая ее
+func (pc *permissionCache) snapshot() map[string]map[string]bool {
+	pc.mutex.RLock()
+	if pc.roles != nil && time.Since(pc.loadedAt) < permissionCacheTTL {
+		roles := pc.roles
+		pc.mutex.RUnlock()
+		return roles
+	}
+	pc.mutex.RUnlock()
+
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	if pc.roles != nil && time.Since(pc.loadedAt) < permissionCacheTTL {
+		return pc.roles
+	}
+
+	var roles []Role
+	if err := db.Preload("Permissions").Find(&roles).Error; err != nil {
+		// При ошибке БД продолжаем работать с последней загруженной версией
+		if pc.roles != nil {
+			return pc.roles
+		}
+		return map[string]map[string]bool{}
+	}
+
+	loaded := make(map[string]map[string]bool, len(roles))
+	mfa := make(map[string]bool, len(roles))
+	public := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		mfa[role.Name] = role.RequireMFA
+		public[role.Name] = role.SelfRegister
+		set := make(map[string]bool, len(role.Permissions))
+		for _, permission := range role.Permissions {
+			set[permission.Code] = true
+		}
+		loaded[role.Name] = set
+	}
+
+	pc.roles = loaded
+	pc.mfa = mfa
+	pc.public = public
+	pc.loadedAt = time.Now()
+	return loaded
+}
+
+// invalidate сбрасывает кэш после изменения ролей или прав
+func (pc *permissionCache) invalidate() {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	pc.roles = nil
+}
+
+// hasPermission проверяет, есть ли у роли право
+func (pc *permissionCache) hasPermission(role, permission string) bool {
+	return pc.snapshot()[role][permission]
+}
+
+// requiresMFA проверяет, обязательна ли 2FA для роли
+func (pc *permissionCache) requiresMFA(role string) bool {
+	if mandatoryMFARoles[role] {
+		return true
+	}
+
+	pc.snapshot()
+	pc.mutex.RLock()
+	defer pc.mutex.RUnlock()
+	return pc.mfa[role]
+}
+
+// selfRegister проверяет, можно ли выбрать роль при публичной регистрации.
+// Роли с обязательной 2FA назначаются только администратором.
+func (pc *permissionCache) selfRegister(role string) bool {
+	if mandatoryMFARoles[role] {
+		return false
+	}
+
+	pc.snapshot()
+	pc.mutex.RLock()
+	defer pc.mutex.RUnlock()
+	return pc.public[role]
+}
+
+// roleExists проверяет, что роль существует
+func (pc *permissionCache) roleExists(role string) bool {
+	_, exists := pc.snapshot()[role]
+	return exists
+}
+
+// permissions возвращает список прав роли
+func (pc *permissionCache) permissions(role string) []string {
+	set := pc.snapshot()[role]
+	result := make([]string, 0, len(set))
+	for permission := range set {
+		result = append(result, permission)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// GetRoles возвращает все роли с правами
+func GetRoles(c *gin.Context) {
+	var roles []Role
+	if err := db.Preload("Permissions").Order("id").Find(&roles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения ролей"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"roles": roles,
+		"total": len(roles),
+	})
+}
+
+// CreateRole создает пользовательскую роль
+func CreateRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Name = strings.TrimSpace(strings.ToLower(req.Name))
+	if !roleNamePattern.MatchString(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Имя роли должно состоять из латинских букв, цифр, '-' и '_'"})
+		return
+	}
+
+	var count int64
+	db.Model(&Role{}).Where("name = ?", req.Name).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Роль с таким именем уже существует"})
+		return
+	}
+
+	permissions, err := findPermissions(req.Permissions)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role := Role{
+		Name:         req.Name,
+		Title:        strings.TrimSpace(req.Title),
+		Description:  strings.TrimSpace(req.Description),
+		RequireMFA:   req.RequireMFA,
+		SelfRegister: req.SelfRegister && !mandatoryMFARoles[req.Name],
+		Permissions:  permissions,
+	}
+
+	if err := db.Create(&role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания роли"})
+		return
+	}
+	rolePermissions.invalidate()
+	recordAudit(c, "role.create", "role", role.ID, nil, roleAuditState(role))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Роль создана",
+		"role":    role,
+	})
+}
+
+// UpdateRole изменяет описание и набор прав роли
+func UpdateRole(c *gin.Context) {
+	var role Role
+	if err := db.Preload("Permissions").First(&role, c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Роль не найдена"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения роли"})
+		return
+	}
+
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	permissions, err := findPermissions(req.Permissions)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before := roleAuditState(role)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		role.Title = strings.TrimSpace(req.Title)
+		role.Description = strings.TrimSpace(req.Description)
+		role.RequireMFA = req.RequireMFA || mandatoryMFARoles[role.Name]
+		role.SelfRegister = req.SelfRegister && !mandatoryMFARoles[role.Name]
+		if err := tx.Save(&role).Error; err != nil {
+			return err
+		}
+		return tx.Model(&role).Association("Permissions").Replace(permissions)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления роли"})
+		return
+	}
+	rolePermissions.invalidate()
+
+	role.Permissions = permissions
+	recordAudit(c, "role.update", "role", role.ID, before, roleAuditState(role))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Роль обновлена",
+		"role":    role,
+	})
+}
+
+// DeleteRole удаляет пользовательскую роль, если она никому не назначена
+func DeleteRole(c *gin.Context) {
+	var role Role
+	if err := db.First(&role, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Роль не найдена"})
+		return
+	}
+
+	if role.IsSystem {
+		c.JSON(http.StatusConflict, gin.H{"error": "Системную роль нельзя удалить"})
+		return
+	}
+
+	var usersCount int64
+	db.Model(&models.User{}).Where("role = ?", role.Name).Count(&usersCount)
+	if usersCount > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Роль назначена пользователям"})
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&role).Association("Permissions").Clear(); err != nil {
+			return err
+		}
+		return tx.Delete(&role).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка удаления роли"})
+		return
+	}
+	rolePermissions.invalidate()
+	recordAudit(c, "role.delete", "role", role.ID, roleAuditState(role), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Роль удалена"})
+}
+
+// GetPermissions возвращает все права
+func GetPermissions(c *gin.Context) {
+	var permissions []Permission
+	if err := db.Order("code").Find(&permissions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения прав"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"permissions": permissions,
+		"total":       len(permissions),
+	})
+}
+
+// CreatePermission регистрирует новое право
+func CreatePermission(c *gin.Context) {
+	var req PermissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Code = strings.TrimSpace(strings.ToLower(req.Code))
+	if !roleNamePattern.MatchString(req.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный код права"})
+		return
+	}
+
+	var count int64
+	db.Model(&Permission{}).Where("code = ?", req.Code).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Право с таким кодом уже существует"})
+		return
+	}
+
+	permission := Permission{
+		Code:        req.Code,
+		Description: strings.TrimSpace(req.Description),
+	}
+	if err := db.Create(&permission).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания права"})
+		return
+	}
+	recordAudit(c, "permission.create", "permission", permission.ID, nil, permission)
+
+	c.JSON(http.StatusCreated, permission)
+}
+
+// roleAuditState состояние роли для журнала аудита: права - списком кодов
+func roleAuditState(role Role) gin.H {
+	codes := make([]string, 0, len(role.Permissions))
+	for _, p := range role.Permissions {
+		codes = append(codes, p.Code)
+	}
+	sort.Strings(codes)
+
+	return gin.H{
+		"name":          role.Name,
+		"title":         role.Title,
+		"description":   role.Description,
+		"require_mfa":   role.RequireMFA,
+		"self_register": role.SelfRegister,
+		"permissions":   codes,
+	}
+}
+
+// findPermissions загружает права по кодам и проверяет, что все они существуют
+func findPermissions(codes []string) ([]Permission, error) {
+	permissions := make([]Permission, 0, len(codes))
+	if len(codes) == 0 {
+		return permissions, nil
+	}
+
+	if err := db.Where("code IN ?", codes).Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool, len(permissions))
+	for _, p := range permissions {
+		found[p.Code] = true
+	}
+	for _, code := range codes {
+		if !found[code] {
+			return nil, fmt.Errorf("неизвестное право: %s", code)
+		}
+	}
+
+	return permissions, nil
+}
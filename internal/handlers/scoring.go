@@ -1,12 +1,13 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
 	"strconv"
 	"tenderhelp/internal/scoring"
+	"tenderhelp/internal/statemachine"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
 // RunScoring запускает скоринг заявки
@@ -20,73 +21,97 @@ func RunScoring(c *gin.Context) {
 
 	// Получение заявки
 	var application Application
-	if err := db.Preload("StatusHistory").First(&application, applicationID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Заявка не найдена"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения заявки"})
+	if !findScopedApplication(c, applicationID, &application, "StatusHistory") {
 		return
 	}
 
-	// Проверка статуса заявки
-	if application.Status != "submitted" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Заявка должна быть в статусе 'submitted' для скоринга"})
+	// Все исходы скоринга допустимы из одних и тех же статусов
+	if !checkApplicationTransition(c, &application, eventReview) {
 		return
 	}
 
 	// Подготовка данных для скоринга
-	applicationData := scoring.ApplicationData{
-		PersonalData:     application.PersonalData,
-		ContactData:      application.ContactData,
-		ProfessionalData: application.ProfessionalData,
-		FinancialData:    application.FinancialData,
-		FamilyData:       application.FamilyData,
-		AdditionalData:   application.AdditionalData,
-	}
+	applicationData := scoringInput(&application)
 
 	// Запуск скоринга
-	scoringResult, err := scoringEngine.ScoreApplication(applicationData, "default_v1")
+	scoringResult, err := scoringEngine.ScoreApplication(applicationData, activeRuleSetID())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка выполнения скоринга: " + err.Error()})
 		return
 	}
 
 	// Обновление статуса заявки на основе результата скоринга
-	var newStatus string
-	switch scoringResult.RiskClass {
-	case "A":
-		newStatus = "approved"
-	case "B":
-		newStatus = "in_review"
-	case "C":
-		newStatus = "rejected"
-	default:
-		newStatus = "in_review"
-	}
-
-	application.Status = newStatus
-	if err := db.Save(&application).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления статуса заявки"})
+	before := application.Status
+	if !transitionApplication(c, &application, scoringEvent(scoringResult.RiskClass), "Скоринг завершен. Класс риска: "+scoringResult.RiskClass) {
 		return
 	}
 
-	// Добавление записи в историю статусов
-	statusHistory := StatusHistory{
-		ApplicationID: application.ID,
-		Status:        newStatus,
-		Timestamp:     scoringResult.Timestamp,
-		Comment:       "Скоринг завершен. Класс риска: " + scoringResult.RiskClass,
-	}
-	db.Create(&statusHistory)
+	saveRiskClass(&application, scoringResult.RiskClass)
+
+	recordAudit(c, "application.score", "application", application.ID,
+		gin.H{"status": before}, gin.H{"status": application.Status, "risk_class": scoringResult.RiskClass})
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":        "Скоринг успешно выполнен",
 		"scoring_result": scoringResult,
-		"application":    application,
+		"application":    presentApplication(c, application),
 	})
 }
 
+// defaultRuleSetID набор правил, если ни один не отмечен активным
+const defaultRuleSetID = "default_v1"
+
+// activeRuleSetID возвращает активный набор правил скоринга. Если активных
+// несколько, используется созданный последним.
+func activeRuleSetID() string {
+	var active *scoring.RuleSet
+	for _, ruleSet := range scoringEngine.GetRuleSets() {
+		if !ruleSet.IsActive {
+			continue
+		}
+		if active == nil || ruleSet.CreatedAt.After(active.CreatedAt) ||
+			(ruleSet.CreatedAt.Equal(active.CreatedAt) && ruleSet.ID > active.ID) {
+			active = ruleSet
+		}
+	}
+	if active == nil {
+		return defaultRuleSetID
+	}
+	return active.ID
+}
+
+// scoringEvent событие перехода по классу риска: A - одобрение, C - отказ,
+// остальные - ручная проверка
+func scoringEvent(riskClass string) statemachine.Event {
+	switch riskClass {
+	case "A":
+		return eventApprove
+	case "C":
+		return eventReject
+	}
+	return eventReview
+}
+
+// saveRiskClass сохраняет класс риска последнего скоринга для поиска заявок
+func saveRiskClass(application *Application, riskClass string) {
+	application.RiskClass = riskClass
+	if err := db.Model(&Application{}).Where("id = ?", application.ID).UpdateColumn("risk_class", riskClass).Error; err != nil {
+		log.Printf("Ошибка сохранения класса риска заявки %d: %v", application.ID, err)
+	}
+}
+
+// scoringInput данные анкеты для движка скоринга
+func scoringInput(application *Application) scoring.ApplicationData {
+	return scoring.ApplicationData{
+		PersonalData:     application.PersonalData,
+		ContactData:      application.ContactData,
+		ProfessionalData: application.ProfessionalData,
+		FinancialData:    application.FinancialData,
+		FamilyData:       application.FamilyData,
+		AdditionalData:   application.AdditionalData,
+	}
+}
+
 // GetScoringResult возвращает результат скоринга
 func GetScoringResult(c *gin.Context) {
 	applicationIDStr := c.Param("id")
@@ -98,12 +123,7 @@ func GetScoringResult(c *gin.Context) {
 
 	// Получение заявки
 	var application Application
-	if err := db.First(&application, applicationID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Заявка не найдена"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения заявки"})
+	if !findScopedApplication(c, applicationID, &application) {
 		return
 	}
 
@@ -201,6 +221,7 @@ func CreateScoringRuleSet(c *gin.Context) {
 
 	// Добавление набора правил в движок
 	scoringEngine.AddRuleSet(&ruleSet)
+	recordAudit(c, "scoring_ruleset.create", "scoring_ruleset", ruleSet.ID, nil, ruleSet)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message":  "Набор правил успешно создан",
@@ -227,6 +248,7 @@ func UpdateScoringRuleSet(c *gin.Context) {
 	}
 
 	// Обновление полей
+	before := gin.H{"name": ruleSet.Name, "is_active": ruleSet.IsActive}
 	if name, ok := updateData["name"].(string); ok {
 		ruleSet.Name = name
 	}
@@ -236,6 +258,8 @@ func UpdateScoringRuleSet(c *gin.Context) {
 
 	// Сохранение обновленного набора правил
 	scoringEngine.AddRuleSet(ruleSet)
+	recordAudit(c, "scoring_ruleset.update", "scoring_ruleset", ruleSet.ID, before,
+		gin.H{"name": ruleSet.Name, "is_active": ruleSet.IsActive})
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":  "Набор правил успешно обновлен",
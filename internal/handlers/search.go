@@ -0,0 +1,459 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// FilterPreset сохраненный пользователем набор фильтров списка заявок.
+// Query - параметры запроса GET /api/applications в виде строки запроса.
+type FilterPreset struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"uniqueIndex:idx_filter_preset"`
+	Name      string    `json:"name" gorm:"uniqueIndex:idx_filter_preset"`
+	Query     string    `json:"query"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FilterPresetRequest запрос на сохранение фильтра
+type FilterPresetRequest struct {
+	Name    string            `json:"name" binding:"required,max=100"`
+	Filters map[string]string `json:"filters" binding:"required"`
+}
+
+// Параметры списка заявок
+const (
+	defaultApplicationsLimit = 10
+	maxApplicationsLimit     = 100
+	defaultApplicationsSort  = "-created_at"
+)
+
+// applicationFilterParams параметры фильтрации, которые можно сохранить в наборе
+var applicationFilterParams = map[string]bool{
+	"status": true, "type": true, "amountMin": true, "amountMax": true,
+	"clientInn": true, "clientName": true, "bank": true, "managerId": true,
+	"riskClass": true, "dateFrom": true, "dateTo": true, "q": true, "sort": true,
+	"slaBreached": true,
+}
+
+// questionnaireColumns колонки анкеты для полнотекстового поиска.
+// Зашифрованные персональные данные поиском не находятся.
+var questionnaireColumns = []string{
+	"personal_data", "contact_data", "professional_data", "financial_data", "family_data", "additional_data",
+}
+
+// sortKind тип значения колонки сортировки (для разбора курсора)
+type sortKind int
+
+const (
+	sortTime sortKind = iota
+	sortNumber
+	sortString
+)
+
+// sortField колонка, по которой можно сортировать список заявок
+type sortField struct {
+	column string
+	kind   sortKind
+	value  func(*Application) interface{}
+}
+
+var applicationSortFields = map[string]sortField{
+	"id":         {"applications.id", sortNumber, func(a *Application) interface{} { return a.ID }},
+	"created_at": {"applications.created_at", sortTime, func(a *Application) interface{} { return a.CreatedAt }},
+	"updated_at": {"applications.updated_at", sortTime, func(a *Application) interface{} { return a.UpdatedAt }},
+	"amount":     {"applications.amount", sortNumber, func(a *Application) interface{} { return a.Amount }},
+	"status":     {"applications.status", sortString, func(a *Application) interface{} { return a.Status }},
+	"type":       {"applications.type", sortString, func(a *Application) interface{} { return a.Type }},
+	"risk_class": {"COALESCE(applications.risk_class, '')", sortString, func(a *Application) interface{} { return a.RiskClass }},
+}
+
+// sortKey поле сортировки с направлением
+type sortKey struct {
+	name string
+	desc bool
+}
+
+// applicationQuery разобранные параметры списка заявок
+type applicationQuery struct {
+	filters []func(*gorm.DB) *gorm.DB
+	sort    []sortKey
+	cursor  []interface{} // значения полей сортировки последней заявки предыдущей страницы
+	paged   bool          // постраничный режим (page) вместо курсора
+	page    int
+	limit   int
+}
+
+// parseApplicationQuery разбирает фильтры, сортировку и пагинацию списка
+// заявок. Значение managerId=me означает текущего пользователя.
+func parseApplicationQuery(values url.Values, userID uint) (*applicationQuery, error) {
+	q := &applicationQuery{limit: defaultApplicationsLimit, paged: true, page: 1}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, errors.New("некорректный параметр limit")
+		}
+		q.limit = min(n, maxApplicationsLimit)
+	}
+
+	if statuses := splitList(values.Get("status")); len(statuses) > 0 && statuses[0] != "all" {
+		q.where("applications.status IN ?", statuses)
+	}
+	if types := splitList(values.Get("type")); len(types) > 0 {
+		q.where("applications.type IN ?", types)
+	}
+	if classes := splitList(values.Get("riskClass")); len(classes) > 0 {
+		q.where("applications.risk_class IN ?", classes)
+	}
+
+	for param, condition := range map[string]string{
+		"amountMin": "applications.amount >= ?",
+		"amountMax": "applications.amount <= ?",
+	} {
+		if value := values.Get(param); value != "" {
+			amount, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("некорректный параметр %s", param)
+			}
+			q.where(condition, amount)
+		}
+	}
+
+	if dateFrom := values.Get("dateFrom"); dateFrom != "" {
+		q.where("applications.created_at >= ?", dateFrom)
+	}
+	if dateTo := values.Get("dateTo"); dateTo != "" {
+		q.where("applications.created_at <= ?", dateTo)
+	}
+
+	if inn := strings.TrimSpace(values.Get("clientInn")); inn != "" {
+		q.where("applications.client_id IN (?)", db.Table("clients").Select("id").Where("inn = ?", inn))
+	}
+	if name := strings.TrimSpace(values.Get("clientName")); name != "" {
+		q.where("applications.client_id IN (?)",
+			db.Table("clients").Select("id").Where(`LOWER(name) LIKE ? ESCAPE '\'`, likePattern(name)))
+	}
+
+	if bank := strings.TrimSpace(values.Get("bank")); bank != "" {
+		q.where("applications.bank = ? OR applications.id IN (?)",
+			bank, db.Model(&ApplicationRoute{}).Select("application_id").Where("bank_id = ?", bank))
+	}
+
+	if manager := values.Get("managerId"); manager != "" {
+		if manager == "me" {
+			q.where("applications.manager_id = ?", userID)
+		} else {
+			id, err := strconv.ParseUint(manager, 10, 32)
+			if err != nil {
+				return nil, errors.New("некорректный параметр managerId")
+			}
+			q.where("applications.manager_id = ?", id)
+		}
+	}
+
+	if values.Get("slaBreached") == "true" {
+		q.where("applications.id IN (?)", db.Model(&SLABreach{}).Select("application_id").Where("resolved_at IS NULL"))
+	}
+
+	// Каждое слово должно встречаться в анкете или в названии клиента
+	for _, term := range strings.Fields(values.Get("q")) {
+		pattern := likePattern(term)
+		conditions := make([]string, 0, len(questionnaireColumns)+1)
+		args := make([]interface{}, 0, len(questionnaireColumns)+1)
+		for _, column := range questionnaireColumns {
+			conditions = append(conditions, "LOWER(CAST(applications."+column+` AS TEXT)) LIKE ? ESCAPE '\'`)
+			args = append(args, pattern)
+		}
+		conditions = append(conditions, "applications.client_id IN (?)")
+		args = append(args, db.Table("clients").Select("id").Where(`LOWER(name) LIKE ? ESCAPE '\'`, pattern))
+		q.where(strings.Join(conditions, " OR "), args...)
+	}
+
+	sort, err := parseSort(values.Get("sort"))
+	if err != nil {
+		return nil, err
+	}
+	q.sort = sort
+
+	// Курсор имеет приоритет над номером страницы
+	if cursor := values.Get("cursor"); cursor != "" {
+		if q.cursor, err = decodeCursor(cursor, q.sort); err != nil {
+			return nil, err
+		}
+		q.paged, q.page = false, 0
+	} else if page := values.Get("page"); page != "" {
+		n, err := strconv.Atoi(page)
+		if err != nil || n < 1 {
+			return nil, errors.New("некорректный параметр page")
+		}
+		q.page = n
+	}
+
+	return q, nil
+}
+
+func (q *applicationQuery) where(condition string, args ...interface{}) {
+	q.filters = append(q.filters, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where(condition, args...)
+	})
+}
+
+// scope применяет фильтры без сортировки и пагинации (для подсчета)
+func (q *applicationQuery) scope(tx *gorm.DB) *gorm.DB {
+	for _, filter := range q.filters {
+		tx = filter(tx)
+	}
+	return tx
+}
+
+// paginate применяет сортировку, курсор и ограничение выборки
+func (q *applicationQuery) paginate(tx *gorm.DB) *gorm.DB {
+	order := make([]string, len(q.sort))
+	for i, key := range q.sort {
+		order[i] = applicationSortFields[key.name].column
+		if key.desc {
+			order[i] += " DESC"
+		}
+	}
+	tx = tx.Order(strings.Join(order, ", ")).Limit(q.limit)
+
+	if q.cursor != nil {
+		condition, args := q.after()
+		tx = tx.Where(condition, args...)
+	}
+	if q.paged {
+		tx = tx.Offset((q.page - 1) * q.limit)
+	}
+	return tx
+}
+
+// after условие "после курсора" для сортировки по нескольким полям:
+// (a > x) OR (a = x AND b > y) OR ...
+func (q *applicationQuery) after() (string, []interface{}) {
+	var alternatives []string
+	var args []interface{}
+	for i, key := range q.sort {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, applicationSortFields[q.sort[j].name].column+" = ?")
+			args = append(args, q.cursor[j])
+		}
+		op := " > ?"
+		if key.desc {
+			op = " < ?"
+		}
+		parts = append(parts, applicationSortFields[key.name].column+op)
+		args = append(args, q.cursor[i])
+		alternatives = append(alternatives, "("+strings.Join(parts, " AND ")+")")
+	}
+	return strings.Join(alternatives, " OR "), args
+}
+
+// nextCursor курсор следующей страницы или пустая строка, если страница последняя
+func (q *applicationQuery) nextCursor(applications []Application) string {
+	if len(applications) < q.limit {
+		return ""
+	}
+	data, err := json.Marshal(q.cursorValues(&applications[len(applications)-1]))
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// cursorValues значения полей сортировки заявки для условия "после курсора"
+func (q *applicationQuery) cursorValues(application *Application) []interface{} {
+	values := make([]interface{}, len(q.sort))
+	for i, key := range q.sort {
+		values[i] = applicationSortFields[key.name].value(application)
+	}
+	return values
+}
+
+// parseSort разбирает сортировку вида "-amount,created_at" (минус - по
+// убыванию). Для однозначного порядка в конец добавляется id.
+func parseSort(value string) ([]sortKey, error) {
+	if value == "" {
+		value = defaultApplicationsSort
+	}
+
+	var keys []sortKey
+	seen := make(map[string]bool)
+	for _, item := range splitList(value) {
+		key := sortKey{name: strings.TrimPrefix(item, "-"), desc: strings.HasPrefix(item, "-")}
+		if _, ok := applicationSortFields[key.name]; !ok {
+			return nil, fmt.Errorf("сортировка по полю '%s' не поддерживается", key.name)
+		}
+		if seen[key.name] {
+			continue
+		}
+		seen[key.name] = true
+		keys = append(keys, key)
+	}
+
+	if !seen["id"] {
+		keys = append(keys, sortKey{name: "id", desc: keys[0].desc})
+	}
+	return keys, nil
+}
+
+// decodeCursor разбирает курсор и приводит значения к типам колонок
+func decodeCursor(cursor string, sort []sortKey) ([]interface{}, error) {
+	errInvalid := errors.New("некорректный курсор")
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalid
+	}
+	var raw []interface{}
+	if err := json.Unmarshal(data, &raw); err != nil || len(raw) != len(sort) {
+		// Курсор получен при другой сортировке
+		return nil, errInvalid
+	}
+
+	values := make([]interface{}, len(raw))
+	for i, key := range sort {
+		switch applicationSortFields[key.name].kind {
+		case sortTime:
+			s, ok := raw[i].(string)
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if !ok || err != nil {
+				return nil, errInvalid
+			}
+			values[i] = t
+		case sortNumber:
+			n, ok := raw[i].(float64)
+			if !ok {
+				return nil, errInvalid
+			}
+			values[i] = n
+		case sortString:
+			s, ok := raw[i].(string)
+			if !ok {
+				return nil, errInvalid
+			}
+			values[i] = s
+		}
+	}
+	return values, nil
+}
+
+// splitList разбирает значения через запятую
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// likePattern шаблон LIKE для поиска подстроки без учета регистра. Колонка
+// сравнивается через LOWER, которая в SQLite заменена на версию с поддержкой
+// кириллицы (database.Open).
+func likePattern(term string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(term))
+	return "%" + escaped + "%"
+}
+
+// applicationListParams параметры списка заявок с учетом сохраненного
+// фильтра (preset): параметры запроса переопределяют сохраненные.
+func applicationListParams(c *gin.Context) (url.Values, error) {
+	values := c.Request.URL.Query()
+	presetID := values.Get("preset")
+	if presetID == "" {
+		return values, nil
+	}
+
+	var preset FilterPreset
+	if err := db.Where("id = ? AND user_id = ?", presetID, currentActor(c).UserID).First(&preset).Error; err != nil {
+		return nil, errors.New("сохраненный фильтр не найден")
+	}
+	merged, err := url.ParseQuery(preset.Query)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		if key != "preset" {
+			merged[key] = value
+		}
+	}
+	return merged, nil
+}
+
+// GetFilterPresets возвращает сохраненные фильтры текущего пользователя
+func GetFilterPresets(c *gin.Context) {
+	var presets []FilterPreset
+	if err := db.Where("user_id = ?", currentActor(c).UserID).Order("name").Find(&presets).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения фильтров"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"presets": presets})
+}
+
+// CreateFilterPreset сохраняет набор фильтров списка заявок
+func CreateFilterPreset(c *gin.Context) {
+	var req FilterPresetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	values := url.Values{}
+	for key, value := range req.Filters {
+		if !applicationFilterParams[key] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Неизвестный параметр фильтра: " + key})
+			return
+		}
+		values.Set(key, value)
+	}
+	userID := currentActor(c).UserID
+	if _, err := parseApplicationQuery(values, userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var count int64
+	db.Model(&FilterPreset{}).Where("user_id = ? AND name = ?", userID, req.Name).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Фильтр с таким названием уже существует"})
+		return
+	}
+
+	preset := FilterPreset{UserID: userID, Name: req.Name, Query: values.Encode()}
+	if err := db.Create(&preset).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения фильтра"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, preset)
+}
+
+// DeleteFilterPreset удаляет сохраненный фильтр текущего пользователя
+func DeleteFilterPreset(c *gin.Context) {
+	result := db.Where("id = ? AND user_id = ?", c.Param("presetId"), currentActor(c).UserID).Delete(&FilterPreset{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка удаления фильтра"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Фильтр не найден"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Фильтр удален"})
+}
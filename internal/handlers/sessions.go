@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"tenderhelp/internal/auth"
+	"tenderhelp/internal/models"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Session представляет сессию пользователя на конкретном устройстве
+type Session struct {
+	ID                uint       `json:"id" gorm:"primaryKey"`
+	UserID            uint       `json:"user_id" gorm:"index"`
+	Kind              string     `json:"kind,omitempty"` // пусто - токены API, admin - cookie-сессия админки
+	RefreshTokenHash  string     `json:"-" gorm:"uniqueIndex"`
+	PreviousTokenHash string     `json:"-" gorm:"index"`
+	UserAgent         string     `json:"user_agent"`
+	IP                string     `json:"ip"`
+	CreatedAt         time.Time  `json:"created_at"`
+	LastUsedAt        time.Time  `json:"last_used_at"`
+	ExpiresAt         time.Time  `json:"expires_at"`
+	RevokedAt         *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason     string     `json:"revoked_reason,omitempty"`
+
+	// Текущая сессия запроса (не хранится в БД)
+	Current bool `json:"current" gorm:"-"`
+}
+
+// IsActive проверяет, что сессия не отозвана и не истекла
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+// RefreshTokenRequest запрос на обновление токенов
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// RevokeSessionsRequest запрос на отзыв сессий пользователя
+type RevokeSessionsRequest struct {
+	Reason string `json:"reason"`
+}
+
+// tokenPair пара токенов, выдаваемая при входе и обновлении
+type tokenPair struct {
+	Token        string    `json:"token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	RefreshToken string    `json:"refresh_token"`
+}
+
+// Причины отзыва сессий
+const (
+	revokeReasonLogout = "logout"
+	revokeReasonUser   = "revoked_by_user"
+	revokeReasonAdmin  = "revoked_by_manager"
+	revokeReasonReuse  = "refresh_token_reuse"
+	revokeReasonIdle   = "idle_timeout"
+)
+
+// startSession создает новую сессию для пользователя и выдает пару токенов
+func startSession(c *gin.Context, user *models.User) (*tokenPair, error) {
+	refreshToken, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := Session{
+		UserID:           user.ID,
+		RefreshTokenHash: auth.HashToken(refreshToken),
+		UserAgent:        c.GetHeader("User-Agent"),
+		IP:               c.ClientIP(),
+		CreatedAt:        now,
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(auth.DefaultRefreshTokenTTL),
+	}
+
+	if err := db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+
+	token, expiresAt, err := generateToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenPair{
+		Token:        token,
+		ExpiresAt:    expiresAt,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// isSessionActive проверяет, что сессия из токена не была отозвана
+func isSessionActive(sessionID uint) bool {
+	var session Session
+	if err := db.Select("id", "revoked_at", "expires_at").First(&session, sessionID).Error; err != nil {
+		return false
+	}
+	return session.IsActive()
+}
+
+// revokeSession отзывает одну сессию
+func revokeSession(sessionID uint, reason string) error {
+	now := time.Now()
+	return db.Model(&Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Updates(map[string]interface{}{"revoked_at": now, "revoked_reason": reason}).Error
+}
+
+// revokeUserSessions отзывает все активные сессии пользователя
+func revokeUserSessions(userID uint, reason string) (int64, error) {
+	now := time.Now()
+	result := db.Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Updates(map[string]interface{}{"revoked_at": now, "revoked_reason": reason})
+	return result.RowsAffected, result.Error
+}
+
+// activeSessions возвращает активные сессии пользователя
+func activeSessions(userID uint) ([]Session, error) {
+	var sessions []Session
+	err := db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RefreshToken обменивает refresh-токен на новую пару токенов (с ротацией)
+func RefreshToken(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректные данные"})
+		return
+	}
+
+	tokenHash := auth.HashToken(req.RefreshToken)
+
+	var session Session
+	if err := db.Where("refresh_token_hash = ?", tokenHash).First(&session).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			// Повторное использование уже замененного токена - признак утечки,
+			// поэтому сессия отзывается целиком
+			var reused Session
+			if db.Where("previous_token_hash = ?", tokenHash).First(&reused).Error == nil {
+				revokeSession(reused.ID, revokeReasonReuse)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Недействительный refresh-токен"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сессии"})
+		return
+	}
+
+	if !session.IsActive() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Сессия завершена"})
+		return
+	}
+
+	// Секрет cookie-сессии админки не обменивается на токены API
+	if session.Kind != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Недействительный refresh-токен"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, session.UserID).Error; err != nil || !user.IsActive {
+		revokeSession(session.ID, revokeReasonAdmin)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Аккаунт заблокирован"})
+		return
+	}
+
+	newRefreshToken, err := auth.NewRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания токена"})
+		return
+	}
+
+	// Ротация выполняется условным обновлением, чтобы два параллельных
+	// запроса с одним токеном не получили две рабочие пары
+	result := db.Model(&Session{}).
+		Where("id = ? AND refresh_token_hash = ?", session.ID, tokenHash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":  auth.HashToken(newRefreshToken),
+			"previous_token_hash": tokenHash,
+			"last_used_at":        time.Now(),
+			"ip":                  c.ClientIP(),
+		})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления сессии"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Недействительный refresh-токен"})
+		return
+	}
+
+	token, expiresAt, err := generateToken(&user, session.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка создания токена"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokenPair{
+		Token:        token,
+		ExpiresAt:    expiresAt,
+		RefreshToken: newRefreshToken,
+	})
+}
+
+// GetSessions возвращает активные сессии текущего пользователя
+func GetSessions(c *gin.Context) {
+	claims, _ := currentClaims(c)
+
+	sessions, err := activeSessions(claims.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения сессий"})
+		return
+	}
+	markCurrentSession(sessions, claims.SessionID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessions,
+		"total":    len(sessions),
+	})
+}
+
+// RevokeSession завершает одну из сессий текущего пользователя
+func RevokeSession(c *gin.Context) {
+	claims, _ := currentClaims(c)
+
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID сессии"})
+		return
+	}
+
+	var session Session
+	if err := db.Where("id = ? AND user_id = ?", sessionID, claims.UserID).First(&session).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Сессия не найдена"})
+		return
+	}
+
+	if err := revokeSession(session.ID, revokeReasonUser); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения сессии"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Сессия завершена"})
+}
+
+// RevokeUserSessions завершает все сессии указанного пользователя
+// (например, при увольнении агента)
+func RevokeUserSessions(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Некорректный ID пользователя"})
+		return
+	}
+
+	var req RevokeSessionsRequest
+	c.ShouldBindJSON(&req)
+
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	reason := revokeReasonAdmin
+	if req.Reason != "" {
+		reason = req.Reason
+	}
+
+	revoked, err := revokeUserSessions(user.ID, reason)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка завершения сессий"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Сессии пользователя завершены",
+		"revoked": revoked,
+	})
+}
+
+// markCurrentSession отмечает сессию, из которой выполнен запрос
+func markCurrentSession(sessions []Session, currentID uint) {
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+}
@@ -0,0 +1,410 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"tenderhelp/internal/calendar"
+	"tenderhelp/internal/models"
+	"tenderhelp/internal/statemachine"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Параметры контроля сроков
+const (
+	slaCheckInterval = 5 * time.Minute
+	slaBatchSize     = 200
+)
+
+// notificationSLABreach тип уведомления о нарушении срока
+const notificationSLABreach = "sla.breach"
+
+// businessCalendar производственный календарь для расчета сроков. Переносы
+// задаются в CALENDAR_HOLIDAYS и CALENDAR_WORKDAYS при запуске.
+var businessCalendar, _ = calendar.Russian(nil, nil)
+
+// SLATarget норматив времени нахождения заявки в статусе в рабочих часах
+// (рабочий день - сутки, кроме выходных и праздников). Type - тип заявки;
+// пустой тип - норматив для всех продуктов без собственного норматива.
+type SLATarget struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Status      string    `json:"status" gorm:"uniqueIndex:idx_sla_target"`
+	Type        string    `json:"type" gorm:"uniqueIndex:idx_sla_target"`
+	TargetHours int       `json:"target_hours"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// SLATargetRequest запрос на установку норматива
+type SLATargetRequest struct {
+	Status      string `json:"status" binding:"required"`
+	Type        string `json:"type"`
+	TargetHours int    `json:"targetHours" binding:"required,min=1"`
+}
+
+// SLABreach нарушение срока: заявка находится в статусе с момента
+// StatusSince дольше норматива. Закрывается при смене статуса.
+type SLABreach struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	ApplicationID uint       `json:"application_id" gorm:"uniqueIndex:idx_sla_breach"`
+	Status        string     `json:"status" gorm:"uniqueIndex:idx_sla_breach"`
+	StatusSince   time.Time  `json:"status_since" gorm:"uniqueIndex:idx_sla_breach"`
+	TargetHours   int        `json:"target_hours"`
+	DueAt         time.Time  `json:"due_at"`
+	BreachedAt    time.Time  `json:"breached_at"`
+	ManagerID     uint       `json:"manager_id"`
+	EscalatedTo   []uint     `json:"escalated_to" gorm:"serializer:json"`
+	EscalatedAt   *time.Time `json:"escalated_at"`
+	ResolvedAt    *time.Time `json:"resolved_at" gorm:"index"`
+}
+
+// SLAStatus срок нахождения заявки в текущем статусе
+type SLAStatus struct {
+	Status       string     `json:"status"`
+	Since        time.Time  `json:"since"`
+	TargetHours  int        `json:"target_hours"`
+	ElapsedHours float64    `json:"elapsed_hours"` // рабочих часов в статусе
+	DueAt        time.Time  `json:"due_at"`
+	Breached     bool       `json:"breached"`
+	EscalatedAt  *time.Time `json:"escalated_at,omitempty"`
+}
+
+// StartSLAChecker загружает переносы календаря и запускает фоновую проверку сроков
+func StartSLAChecker() {
+	cal, err := calendar.Russian(splitList(os.Getenv("CALENDAR_HOLIDAYS")), splitList(os.Getenv("CALENDAR_WORKDAYS")))
+	if err != nil {
+		log.Printf("Ошибка настройки календаря: %v", err)
+	} else {
+		businessCalendar = cal
+	}
+
+	go func() {
+		ticker := time.NewTicker(slaCheckInterval)
+		defer ticker.Stop()
+		for {
+			if err := checkSLA(time.Now()); err != nil {
+				log.Printf("Ошибка проверки сроков заявок: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// findSLATarget норматив для статуса и типа заявки
+func findSLATarget(targets []SLATarget, status, applicationType string) (SLATarget, bool) {
+	var common *SLATarget
+	for i := range targets {
+		if targets[i].Status != status {
+			continue
+		}
+		if targets[i].Type == applicationType {
+			return targets[i], true
+		}
+		if targets[i].Type == "" {
+			common = &targets[i]
+		}
+	}
+	if common != nil {
+		return *common, true
+	}
+	return SLATarget{}, false
+}
+
+// statusSince момент перехода заявки в текущий статус по истории статусов.
+// Повторный переход в тот же статус (повторная отправка в банки) срок не
+// сбрасывает.
+func statusSince(application *Application, history []StatusHistory) time.Time {
+	var since time.Time
+	for _, entry := range history {
+		if entry.Status == application.Status && entry.FromStatus != entry.Status && entry.Timestamp.After(since) {
+			since = entry.Timestamp
+		}
+	}
+	if since.IsZero() {
+		return application.UpdatedAt
+	}
+	return since
+}
+
+// slaStatus рассчитывает срок по нормативу на момент now
+func slaStatus(target SLATarget, status string, since, now time.Time) SLAStatus {
+	targetDuration := time.Duration(target.TargetHours) * time.Hour
+	elapsed := businessCalendar.BusinessTime(since, now)
+	return SLAStatus{
+		Status:       status,
+		Since:        since,
+		TargetHours:  target.TargetHours,
+		ElapsedHours: math.Round(elapsed.Hours()*10) / 10,
+		DueAt:        businessCalendar.AddBusinessTime(since, targetDuration),
+		Breached:     elapsed >= targetDuration,
+	}
+}
+
+// attachSLA заполняет сроки заявок с загруженной историей статусов
+func attachSLA(applications []Application) {
+	var targets []SLATarget
+	if err := db.Find(&targets).Error; err != nil || len(targets) == 0 || len(applications) == 0 {
+		return
+	}
+
+	ids := make([]uint, len(applications))
+	for i := range applications {
+		ids[i] = applications[i].ID
+	}
+	var breaches []SLABreach
+	db.Where("application_id IN ? AND resolved_at IS NULL", ids).Find(&breaches)
+	escalated := make(map[uint]*time.Time, len(breaches))
+	for _, breach := range breaches {
+		escalated[breach.ApplicationID] = breach.EscalatedAt
+	}
+
+	now := time.Now()
+	for i := range applications {
+		application := &applications[i]
+		target, ok := findSLATarget(targets, application.Status, application.Type)
+		if !ok {
+			continue
+		}
+		sla := slaStatus(target, application.Status, statusSince(application, application.StatusHistory), now)
+		sla.EscalatedAt = escalated[application.ID]
+		application.SLA = &sla
+	}
+}
+
+// checkSLA закрывает нарушения заявок, сменивших статус, и фиксирует новые
+// нарушения с эскалацией руководителю менеджера
+func checkSLA(now time.Time) error {
+	if err := db.Model(&SLABreach{}).
+		Where("resolved_at IS NULL").
+		Where("NOT EXISTS (?)", db.Model(&Application{}).Select("1").
+			Where("applications.id = sla_breaches.application_id AND applications.status = sla_breaches.status")).
+		Update("resolved_at", now).Error; err != nil {
+		return err
+	}
+
+	var targets []SLATarget
+	if err := db.Find(&targets).Error; err != nil {
+		return err
+	}
+	statuses := make(map[string]bool)
+	for _, target := range targets {
+		statuses[target.Status] = true
+	}
+
+	for status := range statuses {
+		var batch []Application
+		result := db.Select("id", "type", "status", "manager_id", "updated_at").
+			Where("status = ?", status).
+			FindInBatches(&batch, slaBatchSize, func(tx *gorm.DB, _ int) error {
+				return checkSLABatch(batch, targets, now)
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+	}
+	return nil
+}
+
+func checkSLABatch(applications []Application, targets []SLATarget, now time.Time) error {
+	ids := make([]uint, len(applications))
+	for i := range applications {
+		ids[i] = applications[i].ID
+	}
+	var history []StatusHistory
+	if err := db.Where("application_id IN ?", ids).Find(&history).Error; err != nil {
+		return err
+	}
+	byApplication := make(map[uint][]StatusHistory)
+	for _, entry := range history {
+		byApplication[entry.ApplicationID] = append(byApplication[entry.ApplicationID], entry)
+	}
+
+	for i := range applications {
+		application := &applications[i]
+		target, ok := findSLATarget(targets, application.Status, application.Type)
+		if !ok {
+			continue
+		}
+		sla := slaStatus(target, application.Status, statusSince(application, byApplication[application.ID]), now)
+		if !sla.Breached {
+			continue
+		}
+		if err := recordSLABreach(application, sla, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordSLABreach сохраняет нарушение (один раз на пребывание в статусе) и
+// уведомляет менеджера и его руководителя
+func recordSLABreach(application *Application, sla SLAStatus, now time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		breach := SLABreach{
+			ApplicationID: application.ID,
+			Status:        sla.Status,
+			StatusSince:   sla.Since,
+			TargetHours:   sla.TargetHours,
+			DueAt:         sla.DueAt,
+			BreachedAt:    now,
+			ManagerID:     application.ManagerID,
+		}
+		result := tx.Where(SLABreach{ApplicationID: breach.ApplicationID, Status: breach.Status, StatusSince: breach.StatusSince}).
+			Attrs(breach).FirstOrCreate(&breach)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		supervisors, err := slaSupervisors(tx, application.ManagerID)
+		if err != nil {
+			return err
+		}
+		recipients := supervisors
+		if application.ManagerID != 0 {
+			recipients = append([]uint{application.ManagerID}, supervisors...)
+		}
+		if err := notify(tx, uniqueIDs(recipients), Notification{
+			Type:          notificationSLABreach,
+			ApplicationID: application.ID,
+			Message: fmt.Sprintf("Заявка #%d находится в статусе %s дольше норматива (%d рабочих ч.)",
+				application.ID, sla.Status, sla.TargetHours),
+		}); err != nil {
+			return err
+		}
+
+		return tx.Model(&breach).Select("EscalatedTo", "EscalatedAt").
+			Updates(SLABreach{EscalatedTo: supervisors, EscalatedAt: &now}).Error
+	})
+}
+
+// slaSupervisors получатели эскалации: руководитель менеджера, а если он не
+// задан (или менеджер не назначен) - пользователи с правом manage_sla
+func slaSupervisors(tx *gorm.DB, managerID uint) ([]uint, error) {
+	if managerID != 0 {
+		var profile ManagerProfile
+		err := tx.Where("user_id = ?", managerID).First(&profile).Error
+		if err == nil && profile.SupervisorID != 0 {
+			return []uint{profile.SupervisorID}, nil
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	var roles []Role
+	if err := tx.Preload("Permissions", "code = ?", "manage_sla").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, role := range roles {
+		if len(role.Permissions) > 0 {
+			names = append(names, role.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	var ids []uint
+	err := tx.Model(&models.User{}).Where("role IN ? AND is_active = ?", names, true).Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// uniqueIDs убирает повторы, сохраняя порядок
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	result := ids[:0:0]
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// GetSLATargets возвращает нормативы сроков
+func GetSLATargets(c *gin.Context) {
+	var targets []SLATarget
+	if err := db.Order("status, type").Find(&targets).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения нормативов"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"targets": targets})
+}
+
+// SetSLATarget создает или изменяет норматив для статуса и типа заявки
+func SetSLATarget(c *gin.Context) {
+	var req SLATargetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !applicationStatuses.HasState(statemachine.State(req.Status)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неизвестный статус заявки: " + req.Status})
+		return
+	}
+
+	var target SLATarget
+	err := db.Where("status = ? AND type = ?", req.Status, req.Type).First(&target).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения норматива"})
+		return
+	}
+	before := target
+	target.Status, target.Type, target.TargetHours = req.Status, req.Type, req.TargetHours
+	if err := db.Save(&target).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка сохранения норматива"})
+		return
+	}
+
+	if before.ID == 0 {
+		recordAudit(c, "sla_target.create", "sla_target", target.ID, nil, target)
+	} else {
+		recordAudit(c, "sla_target.update", "sla_target", target.ID, before, target)
+	}
+
+	c.JSON(http.StatusOK, target)
+}
+
+// DeleteSLATarget удаляет норматив
+func DeleteSLATarget(c *gin.Context) {
+	var target SLATarget
+	if err := db.First(&target, c.Param("targetId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Норматив не найден"})
+		return
+	}
+	if err := db.Delete(&target).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка удаления норматива"})
+		return
+	}
+	recordAudit(c, "sla_target.delete", "sla_target", target.ID, target, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Норматив удален"})
+}
+
+// GetSLABreaches возвращает нарушения сроков, новые первыми. По умолчанию
+// только открытые; all=true - включая закрытые.
+func GetSLABreaches(c *gin.Context) {
+	query := db.Model(&SLABreach{}).Where("application_id IN (?)",
+		db.Model(&Application{}).Select("applications.id").Scopes(scopeApplications(c)))
+	if c.Query("all") != "true" {
+		query = query.Where("resolved_at IS NULL")
+	}
+
+	var breaches []SLABreach
+	if err := query.Order("breached_at DESC, id DESC").Limit(500).Find(&breaches).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка получения нарушений сроков"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"breaches": breaches})
+}
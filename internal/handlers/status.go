@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"tenderhelp/internal/jsonschema"
+	"tenderhelp/internal/statemachine"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Статусы заявки
+const (
+	statusDraft       statemachine.State = "draft"
+	statusSubmitted   statemachine.State = "submitted"
+	statusInReview    statemachine.State = "in_review"
+	statusApproved    statemachine.State = "approved"
+	statusRejected    statemachine.State = "rejected"
+	statusSentToBanks statemachine.State = "sent_to_banks"
+)
+
+// События жизненного цикла заявки
+const (
+	eventSubmit      statemachine.Event = "submit"
+	eventReview      statemachine.Event = "review"
+	eventApprove     statemachine.Event = "approve"
+	eventReject      statemachine.Event = "reject"
+	eventSendToBanks statemachine.Event = "send_to_banks"
+)
+
+// errStatusChanged статус заявки изменился параллельным запросом
+var errStatusChanged = errors.New("статус заявки изменился, обновите данные")
+
+// applicationStatuses допустимые переходы между статусами заявки.
+// Отклоненная заявка не может быть отправлена в банки; повторная отправка
+// уже отправленной заявки добавляет новые банки.
+var applicationStatuses = statemachine.MustNew(
+	[]statemachine.State{statusDraft, statusSubmitted, statusInReview, statusApproved, statusRejected, statusSentToBanks},
+	statemachine.Transition[*Application]{
+		Event: eventSubmit,
+		From:  []statemachine.State{statusDraft},
+		To:    statusSubmitted,
+		Guard: func(a *Application) error {
+			return validateApplicationData(CreateApplicationRequest{
+				PersonalData:     a.PersonalData,
+				ContactData:      a.ContactData,
+				ProfessionalData: a.ProfessionalData,
+				FinancialData:    a.FinancialData,
+				FamilyData:       a.FamilyData,
+				AdditionalData:   a.AdditionalData,
+			}, jsonschema.Complete)
+		},
+		Effect: func(a *Application) {
+			if a.ManagerID == 0 {
+				if err := autoAssignManager(a); err != nil {
+					log.Printf("Ошибка назначения менеджера заявке %d: %v", a.ID, err)
+				}
+			}
+			// Скоринг и отправка в банки выполняются конвейером
+			if err := startPipeline(a.ID); err != nil {
+				log.Printf("Ошибка запуска обработки заявки %d: %v", a.ID, err)
+			}
+		},
+	},
+	statemachine.Transition[*Application]{
+		Event: eventReview,
+		From:  []statemachine.State{statusSubmitted},
+		To:    statusInReview,
+	},
+	statemachine.Transition[*Application]{
+		Event: eventApprove,
+		From:  []statemachine.State{statusSubmitted, statusInReview},
+		To:    statusApproved,
+	},
+	statemachine.Transition[*Application]{
+		Event: eventReject,
+		From:  []statemachine.State{statusSubmitted, statusInReview},
+		To:    statusRejected,
+	},
+	statemachine.Transition[*Application]{
+		Event: eventSendToBanks,
+		From:  []statemachine.State{statusApproved, statusSentToBanks},
+		To:    statusSentToBanks,
+	},
+)
+
+// transitionApplication переводит заявку в новый статус по событию от имени
+// текущего пользователя. При ошибке ответ уже отправлен клиенту.
+func transitionApplication(c *gin.Context, application *Application, event statemachine.Event, comment string) bool {
+	entry := StatusHistory{ActorID: currentActor(c).UserID, Comment: comment}
+	if err := applyTransition(application, event, entry); err != nil {
+		respondTransitionError(c, err)
+		return false
+	}
+	return true
+}
+
+// applyTransition переводит заявку в новый статус по событию и пишет в
+// историю статусов entry (автор, этап обработки, комментарий). Статус
+// обновляется условно, чтобы параллельный запрос не перезаписал уже
+// измененный статус.
+func applyTransition(application *Application, event statemachine.Event, entry StatusHistory) error {
+	from := statemachine.State(application.Status)
+	now := time.Now()
+
+	to, err := applicationStatuses.Fire(application, from, event, func(to statemachine.State) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&Application{}).
+				Where("id = ? AND status = ?", application.ID, string(from)).
+				Updates(map[string]interface{}{"status": string(to), "updated_at": now, "version": gorm.Expr("version + 1")})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errStatusChanged
+			}
+
+			entry.ApplicationID = application.ID
+			entry.FromStatus = string(from)
+			entry.Status = string(to)
+			entry.Timestamp = now
+			return tx.Create(&entry).Error
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	application.Status = string(to)
+	application.UpdatedAt = now
+	application.Version++
+	return nil
+}
+
+// editableStatuses статусы, в которых можно менять анкету. После отправки
+// анкета должна совпадать с тем, что получили скоринг и банки.
+var editableStatuses = map[statemachine.State]bool{statusDraft: true}
+
+// checkApplicationEditable отвечает 409, если анкету заявки уже нельзя менять.
+// Если заявку отправят между проверкой и записью, запись отклонит проверка
+// версии: смена статуса увеличивает версию.
+func checkApplicationEditable(c *gin.Context, application *Application) bool {
+	if editableStatuses[statemachine.State(application.Status)] {
+		return true
+	}
+	c.JSON(http.StatusConflict, gin.H{
+		"error":  "Анкету можно менять только в черновике, заявка в статусе '" + application.Status + "'",
+		"status": application.Status,
+	})
+	return false
+}
+
+// checkApplicationTransition проверяет допустимость перехода до выполнения
+// долгих операций (скоринг, отправка в банки)
+func checkApplicationTransition(c *gin.Context, application *Application, event statemachine.Event) bool {
+	if err := applicationStatuses.Check(statemachine.State(application.Status), event); err != nil {
+		respondTransitionError(c, err)
+		return false
+	}
+	return true
+}
+
+// respondTransitionError отвечает на ошибку перехода: 409 для недопустимого
+// перехода или изменившегося статуса, 400 для невыполненного предусловия
+func respondTransitionError(c *gin.Context, err error) {
+	var transitionErr *statemachine.TransitionError
+	var guardErr *statemachine.GuardError
+	switch {
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":          "Действие недоступно для заявки в статусе '" + string(transitionErr.From) + "'",
+			"status":         transitionErr.From,
+			"allowed_events": transitionErr.Allowed,
+		})
+	case errors.As(err, &guardErr):
+		respondValidationError(c, guardErr.Err)
+	case errors.Is(err, errStatusChanged):
+		c.JSON(http.StatusConflict, gin.H{"error": "Статус заявки изменился, обновите данные"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления статуса заявки"})
+	}
+}
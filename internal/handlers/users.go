@@ -1,11 +1,10 @@
 package handlers
 
 import (
-	"math/rand"
 	"net/http"
 	"strings"
+	"tenderhelp/internal/auth"
 	"tenderhelp/internal/models"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -16,7 +15,9 @@ type registerAgentRequest struct {
 	Email string `json:"email"`
 }
 
-// RegisterAgent обрабатывает регистрацию агента и создает пользователя с ролью agent.
+// RegisterAgent обрабатывает регистрацию агента сотрудником с правом
+// manage_users: создает пользователя с ролью agent и отправляет ему
+// приглашение для установки пароля.
 func RegisterAgent(c *gin.Context) {
 	var req registerAgentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -33,16 +34,29 @@ func RegisterAgent(c *gin.Context) {
 		return
 	}
 
-	// Генерация временного пароля
-	tempPassword := generateTempPassword(10)
+	// Проверка, что пользователь еще не зарегистрирован
+	var existingUser models.User
+	if err := db.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Пользователь с таким email уже существует"})
+		return
+	}
+
+	// Пароль задает сам агент по ссылке из приглашения, до этого
+	// войти в учетную запись невозможно
+	passwordHash, err := auth.UnusablePasswordHash()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось создать пользователя"})
+		return
+	}
 
 	user := models.User{
 		Email:    req.Email,
-		Password: tempPassword,
+		Password: passwordHash,
 		Name:     req.Name,
 		Role:     "agent",
 		// Phone сохранится в поле модели (добавлено в модели)
-		Phone: req.Phone,
+		Phone:    req.Phone,
+		IsActive: true,
 	}
 
 	if err := db.Create(&user).Error; err != nil {
@@ -50,26 +64,25 @@ func RegisterAgent(c *gin.Context) {
 		return
 	}
 
+	// Одноразовая ссылка для установки пароля отправляется на почту
+	expiresAt, sent, err := inviteUser(&user, currentActor(c).UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось создать приглашение"})
+		return
+	}
+	recordAudit(c, "user.register_agent", "user", user.ID, nil, user)
+
 	c.JSON(http.StatusCreated, gin.H{
-		"id":                user.ID,
-		"email":             user.Email,
-		"name":              user.Name,
-		"phone":             user.Phone,
-		"role":              user.Role,
-		"temporaryPassword": tempPassword,
+		"id":                  user.ID,
+		"email":               user.Email,
+		"name":                user.Name,
+		"phone":               user.Phone,
+		"role":                user.Role,
+		"invitationSent":      sent,
+		"invitationExpiresAt": expiresAt,
 	})
 }
 
-func generateTempPassword(length int) string {
-	const letters = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKMNPQRSTUVWXYZ23456789"
-	rand.Seed(time.Now().UnixNano())
-	b := make([]byte, length)
-	for i := 0; i < length; i++ {
-		b[i] = letters[rand.Intn(len(letters))]
-	}
-	return string(b)
-}
-
 type registerClientRequest struct {
 	Name          string `json:"name"`
 	INN           string `json:"inn"`
@@ -117,5 +130,6 @@ func RegisterClient(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, "client.register", "client", client.ID, nil, client)
 	c.JSON(http.StatusCreated, gin.H{"id": client.ID, "name": client.Name})
 }
@@ -0,0 +1,86 @@
+package importer
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestRead_CSV(t *testing.T) {
+	data := "\xef\xbb\xbfФамилия;Сумма\nИванов;\"1 500 000,50\"\n;\nПетров\n"
+	table, err := Read("leads.csv", strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Ошибка чтения CSV: %v", err)
+	}
+
+	if !reflect.DeepEqual(table.Header, []string{"Фамилия", "Сумма"}) {
+		t.Errorf("Неверный заголовок: %v", table.Header)
+	}
+	if len(table.Rows) != 2 {
+		t.Fatalf("Ожидалось 2 строки, получено %d", len(table.Rows))
+	}
+	// Пустая строка пропускается, но нумерация строк файла сохраняется
+	if table.Rows[1].Line != 4 || table.Rows[1].Value(0) != "Петров" || table.Rows[1].Value(1) != "" {
+		t.Errorf("Неверная строка: %+v", table.Rows[1])
+	}
+}
+
+func TestRead_XLSX(t *testing.T) {
+	book := excelize.NewFile()
+	book.SetSheetRow("Sheet1", "A1", &[]interface{}{"Фамилия", "Сумма"})
+	book.SetSheetRow("Sheet1", "A2", &[]interface{}{"Иванов", 1000000})
+	var buf bytes.Buffer
+	if err := book.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := Read("leads.XLSX", &buf)
+	if err != nil {
+		t.Fatalf("Ошибка чтения XLSX: %v", err)
+	}
+	if len(table.Rows) != 1 || table.Rows[0].Line != 2 || table.Rows[0].Value(1) != "1000000" {
+		t.Errorf("Неверные строки: %+v", table.Rows)
+	}
+}
+
+func TestRead_UnsupportedFormat(t *testing.T) {
+	if _, err := Read("leads.xls", strings.NewReader("")); err != ErrUnsupportedFormat {
+		t.Errorf("Ожидалась ошибка формата, получено %v", err)
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	if n, err := ParseNumber("1 500 000,50"); err != nil || n != 1500000.5 {
+		t.Errorf("ParseNumber: %v, %v", n, err)
+	}
+	if _, err := ParseNumber("много"); err == nil {
+		t.Error("ParseNumber должен вернуть ошибку")
+	}
+	if b, err := ParseBool("Да"); err != nil || !b {
+		t.Errorf("ParseBool: %v, %v", b, err)
+	}
+	if d, err := ParseDate("15.03.1985"); err != nil || d != "1985-03-15" {
+		t.Errorf("ParseDate: %v, %v", d, err)
+	}
+	if items := ParseList("+79161234567; +79160000000,"); !reflect.DeepEqual(items, []string{"+79161234567", "+79160000000"}) {
+		t.Errorf("ParseList: %v", items)
+	}
+}
+
+func TestSet(t *testing.T) {
+	doc := map[string]interface{}{}
+	Set(doc, "currentJob.companyName", "ООО Тест")
+	Set(doc, "currentJob.position", "Менеджер")
+	Set(doc, "education", "higher")
+
+	expected := map[string]interface{}{
+		"currentJob": map[string]interface{}{"companyName": "ООО Тест", "position": "Менеджер"},
+		"education":  "higher",
+	}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Errorf("Неверный документ: %v", doc)
+	}
+}
@@ -0,0 +1,151 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// MaxRows максимальное количество строк данных в одном файле
+const MaxRows = 5000
+
+// ErrUnsupportedFormat файл не CSV и не XLSX
+var ErrUnsupportedFormat = errors.New("поддерживаются только файлы CSV и XLSX")
+
+// Table таблица из файла: заголовки столбцов и строки данных. Line - номер
+// строки в файле (заголовок - строка 1), по нему строятся отчеты об ошибках.
+type Table struct {
+	Header []string
+	Rows   []Row
+}
+
+// Row строка данных таблицы
+type Row struct {
+	Line   int
+	Values []string
+}
+
+// Value возвращает значение столбца (пустая строка, если строка короче заголовка)
+func (r Row) Value(column int) string {
+	if column < len(r.Values) {
+		return strings.TrimSpace(r.Values[column])
+	}
+	return ""
+}
+
+// Format определяет формат файла по расширению: "csv" или "xlsx"
+func Format(name string) (string, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return "csv", nil
+	case ".xlsx":
+		return "xlsx", nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Read читает таблицу из файла CSV или XLSX. Пустые строки пропускаются.
+func Read(name string, r io.Reader) (*Table, error) {
+	format, err := Format(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var records [][]string
+	switch format {
+	case "csv":
+		records, err = readCSV(r)
+	case "xlsx":
+		records, err = readXLSX(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newTable(records)
+}
+
+// readCSV читает CSV с разделителем ";" (выгрузка Excel) или ","
+func readCSV(r io.Reader) ([][]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.Comma = detectDelimiter(data)
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("некорректный CSV: %w", err)
+	}
+	return records, nil
+}
+
+// detectDelimiter выбирает разделитель по первой строке файла
+func detectDelimiter(data []byte) rune {
+	line := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		line = data[:i]
+	}
+	if bytes.Count(line, []byte(";")) > bytes.Count(line, []byte(",")) {
+		return ';'
+	}
+	return ','
+}
+
+// readXLSX читает первый лист книги
+func readXLSX(r io.Reader) ([][]string, error) {
+	book, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("некорректный файл XLSX: %w", err)
+	}
+	defer book.Close()
+
+	sheets := book.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, errors.New("в книге нет листов")
+	}
+	return book.GetRows(sheets[0])
+}
+
+func newTable(records [][]string) (*Table, error) {
+	table := &Table{}
+	for i, record := range records {
+		if isBlank(record) {
+			continue
+		}
+		if table.Header == nil {
+			table.Header = make([]string, len(record))
+			for j, name := range record {
+				table.Header[j] = strings.TrimSpace(name)
+			}
+			continue
+		}
+		table.Rows = append(table.Rows, Row{Line: i + 1, Values: record})
+	}
+
+	if table.Header == nil {
+		return nil, errors.New("файл пустой")
+	}
+	if len(table.Rows) > MaxRows {
+		return nil, fmt.Errorf("в файле больше %d строк", MaxRows)
+	}
+	return table, nil
+}
+
+func isBlank(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
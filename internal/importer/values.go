@@ -0,0 +1,70 @@
+package importer
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseNumber разбирает число в русской записи: пробелы между разрядами,
+// запятая как десятичный разделитель ("1 500 000,50")
+func ParseNumber(value string) (float64, error) {
+	normalized := strings.NewReplacer(" ", "", " ", "", ",", ".").Replace(value)
+	number, err := strconv.ParseFloat(normalized, 64)
+	if err != nil {
+		return 0, errors.New("ожидается число")
+	}
+	return number, nil
+}
+
+// ParseBool разбирает логическое значение: да/нет, true/false, 1/0
+func ParseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "да", "true", "1", "yes", "+":
+		return true, nil
+	case "нет", "false", "0", "no", "-":
+		return false, nil
+	}
+	return false, errors.New("ожидается да или нет")
+}
+
+// dateLayouts форматы дат в таблицах: ISO, русский и американский формат Excel
+var dateLayouts = []string{"2006-01-02", "02.01.2006", "2.1.2006", "01-02-06", "1/2/06", "1/2/2006"}
+
+// ParseDate приводит дату к формату ГГГГ-ММ-ДД
+func ParseDate(value string) (string, error) {
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date.Format("2006-01-02"), nil
+		}
+	}
+	return "", errors.New("ожидается дата в формате ДД.ММ.ГГГГ или ГГГГ-ММ-ДД")
+}
+
+// ParseList разбирает список значений через запятую или точку с запятой
+func ParseList(value string) []string {
+	fields := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' })
+	items := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if field = strings.TrimSpace(field); field != "" {
+			items = append(items, field)
+		}
+	}
+	return items
+}
+
+// Set записывает значение в документ по пути через точку, создавая
+// вложенные объекты ("currentJob.companyName")
+func Set(doc map[string]interface{}, path string, value interface{}) {
+	segments := strings.Split(path, ".")
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := doc[segment].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			doc[segment] = next
+		}
+		doc = next
+	}
+	doc[segments[len(segments)-1]] = value
+}
@@ -0,0 +1,134 @@
+package jsondiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Change изменение одного поля. Path - путь к полю через точку
+// (например, "personal_data.inn" или "scopes.1"). Old равен nil для
+// добавленных полей, New - для удаленных.
+type Change struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+// Diff сравнивает JSON-представления двух значений и возвращает список
+// измененных полей. Любое из значений может быть nil (создание или удаление
+// сущности). Поля верхнего уровня из ignore не сравниваются.
+func Diff(before, after interface{}, ignore ...string) ([]Change, error) {
+	left, err := normalize(before)
+	if err != nil {
+		return nil, err
+	}
+	right, err := normalize(after)
+	if err != nil {
+		return nil, err
+	}
+
+	skip := make(map[string]bool, len(ignore))
+	for _, path := range ignore {
+		skip[path] = true
+	}
+
+	changes := []Change{}
+	compare("", left, right, skip, &changes)
+	return changes, nil
+}
+
+// normalize приводит значение к дереву map/slice/скаляров через JSON
+func normalize(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	var data []byte
+	switch v := value.(type) {
+	case json.RawMessage:
+		data = v
+	case []byte:
+		data = v
+	default:
+		var err error
+		if data, err = json.Marshal(value); err != nil {
+			return nil, fmt.Errorf("ошибка сериализации: %w", err)
+		}
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var result interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("некорректный JSON: %w", err)
+	}
+	return result, nil
+}
+
+func compare(path string, left, right interface{}, skip map[string]bool, changes *[]Change) {
+	if skip[path] {
+		return
+	}
+
+	leftMap, leftIsMap := left.(map[string]interface{})
+	rightMap, rightIsMap := right.(map[string]interface{})
+	if leftIsMap && rightIsMap {
+		for _, key := range unionKeys(leftMap, rightMap) {
+			compare(join(path, key), leftMap[key], rightMap[key], skip, changes)
+		}
+		return
+	}
+
+	// Объект на месте nil (создание/удаление) раскладывается по полям,
+	// чтобы в журнале было видно, какие значения были установлены
+	if leftIsMap && right == nil {
+		for _, key := range unionKeys(leftMap, nil) {
+			compare(join(path, key), leftMap[key], nil, skip, changes)
+		}
+		return
+	}
+	if rightIsMap && left == nil {
+		for _, key := range unionKeys(nil, rightMap) {
+			compare(join(path, key), nil, rightMap[key], skip, changes)
+		}
+		return
+	}
+
+	leftSlice, leftIsSlice := left.([]interface{})
+	rightSlice, rightIsSlice := right.([]interface{})
+	if leftIsSlice && rightIsSlice && len(leftSlice) == len(rightSlice) {
+		for i := range leftSlice {
+			compare(join(path, strconv.Itoa(i)), leftSlice[i], rightSlice[i], skip, changes)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(left, right) {
+		*changes = append(*changes, Change{Path: path, Old: left, New: right})
+	}
+}
+
+func unionKeys(left, right map[string]interface{}) []string {
+	keys := make([]string, 0, len(left)+len(right))
+	for key := range left {
+		keys = append(keys, key)
+	}
+	for key := range right {
+		if _, ok := left[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
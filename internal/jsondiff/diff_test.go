@@ -0,0 +1,70 @@
+package jsondiff
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type sample struct {
+	Name      string          `json:"name"`
+	Amount    float64         `json:"amount"`
+	Tags      []string        `json:"tags"`
+	Data      json.RawMessage `json:"data"`
+	UpdatedAt string          `json:"updated_at"`
+}
+
+func TestDiff(t *testing.T) {
+	before := sample{
+		Name:      "ООО Ромашка",
+		Amount:    1000,
+		Tags:      []string{"a", "b"},
+		Data:      json.RawMessage(`{"inn":"7701","phone":"123"}`),
+		UpdatedAt: "2024-01-01",
+	}
+	after := before
+	after.Amount = 1500
+	after.Tags = []string{"a", "c"}
+	after.Data = json.RawMessage(`{"inn":"7701","email":"a@b.ru"}`)
+	after.UpdatedAt = "2024-01-02"
+
+	changes, err := Diff(before, after, "updated_at")
+	if err != nil {
+		t.Fatalf("Ошибка сравнения: %v", err)
+	}
+
+	expected := []Change{
+		{Path: "amount", Old: 1000.0, New: 1500.0},
+		{Path: "data.email", Old: nil, New: "a@b.ru"},
+		{Path: "data.phone", Old: "123", New: nil},
+		{Path: "tags.1", Old: "b", New: "c"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Ожидалось %v, получено %v", expected, changes)
+	}
+}
+
+func TestDiff_CreateAndDelete(t *testing.T) {
+	value := map[string]interface{}{"name": "Клиент", "inn": "7701"}
+
+	created, err := Diff(nil, value)
+	if err != nil {
+		t.Fatalf("Ошибка сравнения: %v", err)
+	}
+	if len(created) != 2 || created[0].Path != "inn" || created[0].New != "7701" {
+		t.Errorf("Некорректный diff создания: %v", created)
+	}
+
+	deleted, err := Diff(value, nil)
+	if err != nil {
+		t.Fatalf("Ошибка сравнения: %v", err)
+	}
+	if len(deleted) != 2 || deleted[1].Path != "name" || deleted[1].Old != "Клиент" {
+		t.Errorf("Некорректный diff удаления: %v", deleted)
+	}
+
+	same, _ := Diff(value, value)
+	if len(same) != 0 {
+		t.Errorf("Для одинаковых значений ожидался пустой diff: %v", same)
+	}
+}
@@ -0,0 +1,375 @@
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Mode режим проверки документа
+type Mode int
+
+const (
+	// Complete полная проверка, включая обязательность полей
+	Complete Mode = iota
+	// Partial проверка черновика: проверяются типы и форматы заполненных
+	// полей, а правила полноты (required, const, minItems) пропускаются
+	Partial
+)
+
+// Schema подмножество JSON Schema: type, properties, required, items, enum,
+// const, format, pattern, ограничения длины и значений, allOf, if/then/else.
+// ErrorMessage (нестандартное) заменяет текст любой ошибки самого поля.
+type Schema struct {
+	Type         string             `json:"type,omitempty"`
+	Title        string             `json:"title,omitempty"`
+	Description  string             `json:"description,omitempty"`
+	Properties   map[string]*Schema `json:"properties,omitempty"`
+	Required     []string           `json:"required,omitempty"`
+	Items        *Schema            `json:"items,omitempty"`
+	Enum         []interface{}      `json:"enum,omitempty"`
+	Const        interface{}        `json:"const,omitempty"`
+	Format       string             `json:"format,omitempty"`
+	Pattern      string             `json:"pattern,omitempty"`
+	MinLength    *int               `json:"minLength,omitempty"`
+	MaxLength    *int               `json:"maxLength,omitempty"`
+	Minimum      *float64           `json:"minimum,omitempty"`
+	Maximum      *float64           `json:"maximum,omitempty"`
+	MinItems     *int               `json:"minItems,omitempty"`
+	MaxItems     *int               `json:"maxItems,omitempty"`
+	AllOf        []*Schema          `json:"allOf,omitempty"`
+	If           *Schema            `json:"if,omitempty"`
+	Then         *Schema            `json:"then,omitempty"`
+	Else         *Schema            `json:"else,omitempty"`
+	ErrorMessage string             `json:"errorMessage,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// FieldError ошибка конкретного поля. Path - путь через точку, индексы
+// массивов записываются числами (например, "children.0.birthDate").
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// formats проверки значений format
+var formats = map[string]struct {
+	check   func(string) bool
+	message string
+}{
+	"date": {
+		check: func(s string) bool {
+			_, err := time.Parse("2006-01-02", s)
+			return err == nil
+		},
+		message: "ожидается дата в формате ГГГГ-ММ-ДД",
+	},
+	"email": {
+		check: func(s string) bool {
+			address, err := mail.ParseAddress(s)
+			return err == nil && address.Address == s
+		},
+		message: "некорректный email",
+	},
+	"phone": {
+		check:   regexp.MustCompile(`^\+7\d{10}$`).MatchString,
+		message: "ожидается телефон в формате +7XXXXXXXXXX",
+	},
+}
+
+// typeNames названия типов для сообщений об ошибках
+var typeNames = map[string]string{
+	"object":  "объект",
+	"array":   "массив",
+	"string":  "строка",
+	"number":  "число",
+	"integer": "целое число",
+	"boolean": "логическое значение",
+}
+
+// Parse разбирает схему и проверяет ее корректность
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if err := s.Compile(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Compile проверяет схему и подготавливает регулярные выражения. Вызывается
+// один раз после сборки или разбора схемы.
+func (s *Schema) Compile() error {
+	return s.compile("")
+}
+
+func (s *Schema) compile(path string) error {
+	if s.Type != "" {
+		if _, ok := typeNames[s.Type]; !ok {
+			return fmt.Errorf("%s: неизвестный тип '%s'", displayPath(path), s.Type)
+		}
+	}
+	if s.Format != "" {
+		if _, ok := formats[s.Format]; !ok {
+			return fmt.Errorf("%s: неизвестный формат '%s'", displayPath(path), s.Format)
+		}
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s: некорректный pattern: %w", displayPath(path), err)
+		}
+		s.pattern = re
+	}
+
+	for name, property := range s.Properties {
+		if err := property.compile(join(path, name)); err != nil {
+			return err
+		}
+	}
+	for _, sub := range append([]*Schema{s.Items, s.If, s.Then, s.Else}, s.AllOf...) {
+		if sub == nil {
+			continue
+		}
+		if err := sub.compile(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateJSON проверяет JSON-документ
+func (s *Schema) ValidateJSON(doc []byte, mode Mode) []FieldError {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return []FieldError{{Path: "", Message: "некорректный JSON"}}
+	}
+	return s.Validate(value, mode)
+}
+
+// Validate проверяет значение, полученное из encoding/json
+func (s *Schema) Validate(value interface{}, mode Mode) []FieldError {
+	errors := []FieldError{}
+	s.validate(value, "", mode, &errors)
+	return errors
+}
+
+func (s *Schema) validate(value interface{}, path string, mode Mode, errors *[]FieldError) {
+	fail := func(message string) {
+		if s.ErrorMessage != "" {
+			message = s.ErrorMessage
+		}
+		*errors = append(*errors, FieldError{Path: path, Message: message})
+	}
+
+	if s.Type != "" && !hasType(value, s.Type) {
+		fail("ожидается " + typeNames[s.Type])
+		return
+	}
+
+	if s.Const != nil && mode == Complete && !equal(value, s.Const) {
+		fail("недопустимое значение")
+		return
+	}
+
+	if len(s.Enum) > 0 && !s.inEnum(value) {
+		options := make([]string, len(s.Enum))
+		for i, option := range s.Enum {
+			options[i] = fmt.Sprint(option)
+		}
+		fail("недопустимое значение, ожидается одно из: " + strings.Join(options, ", "))
+		return
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			fail(fmt.Sprintf("минимальная длина %d символов", *s.MinLength))
+			return
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail(fmt.Sprintf("максимальная длина %d символов", *s.MaxLength))
+			return
+		}
+		if f, ok := formats[s.Format]; ok && !f.check(v) {
+			fail(f.message)
+			return
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("значение не соответствует формату")
+			return
+		}
+
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems && mode == Complete {
+			fail(fmt.Sprintf("требуется не меньше %d элементов", *s.MinItems))
+			return
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail(fmt.Sprintf("допускается не больше %d элементов", *s.MaxItems))
+			return
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(item, join(path, strconv.Itoa(i)), mode, errors)
+			}
+		}
+
+	case map[string]interface{}:
+		s.validateObject(v, path, mode, errors)
+		for _, sub := range s.AllOf {
+			sub.validateObject(v, path, mode, errors)
+		}
+
+	default:
+		if number, ok := toFloat(value); ok {
+			if s.Minimum != nil && number < *s.Minimum {
+				fail(fmt.Sprintf("значение должно быть не меньше %v", *s.Minimum))
+				return
+			}
+			if s.Maximum != nil && number > *s.Maximum {
+				fail(fmt.Sprintf("значение должно быть не больше %v", *s.Maximum))
+				return
+			}
+		}
+	}
+}
+
+func (s *Schema) validateObject(object map[string]interface{}, path string, mode Mode, errors *[]FieldError) {
+	if mode == Complete {
+		for _, name := range s.Required {
+			if isEmpty(object[name]) {
+				*errors = append(*errors, FieldError{Path: join(path, name), Message: "обязательное поле"})
+			}
+		}
+	}
+
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, exists := object[name]
+		// Пустые значения считаются незаполненными: их проверяет только required
+		if !exists || isEmpty(value) {
+			continue
+		}
+		s.Properties[name].validate(value, join(path, name), mode, errors)
+	}
+
+	// Условные правила: условие проверяется полностью, ветка - в текущем режиме
+	if s.If != nil {
+		var conditionErrors []FieldError
+		s.If.validateObject(object, path, Complete, &conditionErrors)
+		if len(conditionErrors) == 0 {
+			if s.Then != nil {
+				s.Then.validateObject(object, path, mode, errors)
+			}
+		} else if s.Else != nil {
+			s.Else.validateObject(object, path, mode, errors)
+		}
+	}
+}
+
+func (s *Schema) inEnum(value interface{}) bool {
+	for _, option := range s.Enum {
+		if equal(value, option) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasType проверяет соответствие значения типу схемы
+func hasType(value interface{}, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := toFloat(value)
+		return ok
+	case "integer":
+		number, ok := toFloat(value)
+		return ok && number == math.Trunc(number)
+	}
+	return true
+}
+
+// toFloat приводит числовое значение к float64
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// equal сравнивает значения с учетом разных представлений чисел
+func equal(a, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// isEmpty считает незаполненными отсутствующие значения, null и пустые строки
+func isEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	}
+	return false
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "схема"
+	}
+	return path
+}
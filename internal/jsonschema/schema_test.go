@@ -0,0 +1,119 @@
+package jsonschema
+
+import (
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["phone", "hasChildren", "consent"],
+	"properties": {
+		"phone": {"type": "string", "format": "phone"},
+		"passport": {"type": "string", "pattern": "^\\d{4}$"},
+		"hasChildren": {"type": "boolean"},
+		"childrenCount": {"type": "integer", "minimum": 0, "maximum": 10},
+		"consent": {"type": "boolean", "const": true, "errorMessage": "необходимо согласие"},
+		"children": {
+			"type": "array",
+			"maxItems": 2,
+			"items": {
+				"type": "object",
+				"required": ["name"],
+				"properties": {"birthDate": {"type": "string", "format": "date"}}
+			}
+		}
+	},
+	"if": {"required": ["hasChildren"], "properties": {"hasChildren": {"const": true}}},
+	"then": {"required": ["childrenCount"], "properties": {"childrenCount": {"minimum": 1}}}
+}`
+
+func errorPaths(errors []FieldError) map[string]string {
+	paths := make(map[string]string, len(errors))
+	for _, e := range errors {
+		paths[e.Path] = e.Message
+	}
+	return paths
+}
+
+func TestValidate_Complete(t *testing.T) {
+	s, err := Parse([]byte(testSchema))
+	if err != nil {
+		t.Fatalf("Ошибка разбора схемы: %v", err)
+	}
+
+	valid := `{"phone":"+79161234567","hasChildren":true,"childrenCount":2,"consent":true,"children":[{"name":"Петр","birthDate":"2015-06-01"}]}`
+	if errors := s.ValidateJSON([]byte(valid), Complete); len(errors) != 0 {
+		t.Errorf("Ожидалось отсутствие ошибок, получено %v", errors)
+	}
+
+	invalid := `{"phone":"89161234567","passport":"45 10","hasChildren":true,"childrenCount":1.5,"consent":false,"children":[{"birthDate":"2015-13-01"}]}`
+	paths := errorPaths(s.ValidateJSON([]byte(invalid), Complete))
+
+	expected := map[string]string{
+		"phone":                "ожидается телефон в формате +7XXXXXXXXXX",
+		"passport":             "значение не соответствует формату",
+		"childrenCount":        "ожидается целое число",
+		"consent":              "необходимо согласие",
+		"children.0.name":      "обязательное поле",
+		"children.0.birthDate": "ожидается дата в формате ГГГГ-ММ-ДД",
+	}
+	for path, message := range expected {
+		if paths[path] != message {
+			t.Errorf("Поле %s: ожидалось '%s', получено '%s'", path, message, paths[path])
+		}
+	}
+	if len(paths) != len(expected) {
+		t.Errorf("Ожидалось %d ошибок, получено %v", len(expected), paths)
+	}
+}
+
+func TestValidate_Conditional(t *testing.T) {
+	s, err := Parse([]byte(testSchema))
+	if err != nil {
+		t.Fatalf("Ошибка разбора схемы: %v", err)
+	}
+
+	paths := errorPaths(s.ValidateJSON([]byte(`{"phone":"+79161234567","hasChildren":true,"consent":true}`), Complete))
+	if paths["childrenCount"] != "обязательное поле" {
+		t.Errorf("При hasChildren=true количество детей обязательно, получено %v", paths)
+	}
+
+	paths = errorPaths(s.ValidateJSON([]byte(`{"phone":"+79161234567","hasChildren":true,"childrenCount":0,"consent":true}`), Complete))
+	if paths["childrenCount"] == "" {
+		t.Error("При hasChildren=true количество детей должно быть больше нуля")
+	}
+
+	if errors := s.ValidateJSON([]byte(`{"phone":"+79161234567","hasChildren":false,"consent":true}`), Complete); len(errors) != 0 {
+		t.Errorf("При hasChildren=false количество детей не требуется, получено %v", errors)
+	}
+}
+
+func TestValidate_Partial(t *testing.T) {
+	s, err := Parse([]byte(testSchema))
+	if err != nil {
+		t.Fatalf("Ошибка разбора схемы: %v", err)
+	}
+
+	// Черновик: незаполненные поля и отсутствие согласия допустимы
+	if errors := s.ValidateJSON([]byte(`{"hasChildren":true,"consent":false,"phone":""}`), Partial); len(errors) != 0 {
+		t.Errorf("Ожидалось отсутствие ошибок в черновике, получено %v", errors)
+	}
+
+	// Формат заполненных полей проверяется и в черновике
+	paths := errorPaths(s.ValidateJSON([]byte(`{"phone":"123","children":[{},{},{}]}`), Partial))
+	if paths["phone"] == "" || paths["children"] == "" {
+		t.Errorf("Ожидались ошибки phone и children, получено %v", paths)
+	}
+}
+
+func TestParse_InvalidSchema(t *testing.T) {
+	for _, schema := range []string{
+		`{"type": "text"}`,
+		`{"properties": {"a": {"format": "inn"}}}`,
+		`{"properties": {"a": {"pattern": "("}}}`,
+	} {
+		if _, err := Parse([]byte(schema)); err == nil {
+			t.Errorf("Схема %s должна отклоняться", schema)
+		}
+	}
+}
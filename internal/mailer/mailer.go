@@ -0,0 +1,93 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// Mailer отправляет письма пользователям
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPMailer отправляет письма через SMTP-сервер
+type SMTPMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPMailer создает SMTP-отправителя
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+// Send отправляет письмо в формате text/plain
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("недопустимые символы в заголовках письма")
+	}
+
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	message := strings.Join([]string{
+		"From: " + m.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	if err := smtp.SendMail(net.JoinHostPort(m.host, m.port), auth, m.from, []string{to}, []byte(message)); err != nil {
+		return fmt.Errorf("ошибка отправки письма: %w", err)
+	}
+	return nil
+}
+
+// LogMailer используется, когда SMTP не настроен. Пишет в лог только
+// получателя и тему: тело письма может содержать одноразовые ссылки.
+type LogMailer struct{}
+
+// Send записывает факт отправки в лог
+func (LogMailer) Send(to, subject, body string) error {
+	log.Printf("Письмо не отправлено (SMTP не настроен): %s, тема: %s", to, subject)
+	return nil
+}
+
+// FromEnv создает отправителя по переменным окружения SMTP_HOST, SMTP_PORT,
+// SMTP_USER, SMTP_PASSWORD и SMTP_FROM
+func FromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		log.Println("ВНИМАНИЕ: SMTP_HOST не задан, письма не отправляются")
+		return LogMailer{}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "noreply@brokerum.ru"
+	}
+
+	return NewSMTPMailer(host, port, os.Getenv("SMTP_USER"), os.Getenv("SMTP_PASSWORD"), from)
+}
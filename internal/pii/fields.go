@@ -0,0 +1,201 @@
+package pii
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// encryptedPrefix префикс зашифрованного значения внутри JSON
+const encryptedPrefix = "pii:v1:"
+
+// Cipher шифрует отдельные значения JSON ключом данных записи
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher создает шифр для ключа данных записи
+func NewCipher(dataKey []byte) (*Cipher, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// EncryptValue шифрует значение поля. Путь поля используется как
+// дополнительные данные, поэтому зашифрованные значения нельзя поменять
+// местами между полями.
+func (c *Cipher) EncryptValue(path string, value interface{}) (interface{}, error) {
+	if value == nil || IsEncrypted(value) {
+		return value, nil
+	}
+
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := seal(c.aead, plaintext, []byte(path))
+	if err != nil {
+		return nil, err
+	}
+	return encryptedPrefix + sealed, nil
+}
+
+// DecryptValue расшифровывает значение, зашифрованное EncryptValue.
+// Незашифрованные значения возвращаются без изменений.
+func (c *Cipher) DecryptValue(path string, value interface{}) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok || !strings.HasPrefix(s, encryptedPrefix) {
+		return value, nil
+	}
+
+	plaintext, err := open(c.aead, strings.TrimPrefix(s, encryptedPrefix), []byte(path))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка расшифровки поля %s: %w", path, err)
+	}
+
+	var result interface{}
+	decoder := json.NewDecoder(bytes.NewReader(plaintext))
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// IsEncrypted проверяет, что значение зашифровано
+func IsEncrypted(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && strings.HasPrefix(s, encryptedPrefix)
+}
+
+// Mask скрывает значение для пользователей без доступа к персональным
+// данным: буквы и цифры заменяются на '*', у длинных строк остаются
+// видны два последних символа. Разделители (дефисы, точки, @) сохраняются.
+func Mask(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case bool:
+		return v
+	case string:
+		if IsEncrypted(v) {
+			return "***"
+		}
+		return maskString(v)
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, item := range v {
+			masked[i] = Mask(item)
+		}
+		return masked
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			masked[key] = Mask(item)
+		}
+		return masked
+	default:
+		return "***"
+	}
+}
+
+func maskString(s string) string {
+	runes := []rune(s)
+
+	alnum := 0
+	for _, r := range runes {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			alnum++
+		}
+	}
+
+	visible := 0
+	if alnum >= 6 {
+		visible = 2
+	}
+
+	for i := len(runes) - 1; i >= 0; i-- {
+		if !unicode.IsLetter(runes[i]) && !unicode.IsDigit(runes[i]) {
+			continue
+		}
+		if visible > 0 {
+			visible--
+			continue
+		}
+		runes[i] = '*'
+	}
+	return string(runes)
+}
+
+// TransformPaths применяет fn к значениям по указанным путям JSON-документа.
+// Путь состоит из ключей через точку; суффикс [] обходит элементы массива
+// (например, "children[].birthDate"). Отсутствующие пути пропускаются.
+func TransformPaths(doc []byte, paths []string, fn func(path string, value interface{}) (interface{}, error)) ([]byte, error) {
+	if len(bytes.TrimSpace(doc)) == 0 {
+		return doc, nil
+	}
+
+	var root interface{}
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	if err := decoder.Decode(&root); err != nil {
+		return nil, fmt.Errorf("некорректный JSON: %w", err)
+	}
+
+	for _, path := range paths {
+		var err error
+		root, err = transform(root, strings.Split(path, "."), path, fn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(root)
+}
+
+func transform(node interface{}, segments []string, path string, fn func(string, interface{}) (interface{}, error)) (interface{}, error) {
+	if len(segments) == 0 {
+		return fn(path, node)
+	}
+
+	object, ok := node.(map[string]interface{})
+	if !ok {
+		return node, nil
+	}
+
+	key := segments[0]
+	isArray := strings.HasSuffix(key, "[]")
+	key = strings.TrimSuffix(key, "[]")
+
+	value, exists := object[key]
+	if !exists {
+		return node, nil
+	}
+
+	if isArray {
+		items, ok := value.([]interface{})
+		if !ok {
+			return node, nil
+		}
+		for i, item := range items {
+			transformed, err := transform(item, segments[1:], path, fn)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = transformed
+		}
+		return node, nil
+	}
+
+	transformed, err := transform(value, segments[1:], path, fn)
+	if err != nil {
+		return nil, err
+	}
+	object[key] = transformed
+	return node, nil
+}
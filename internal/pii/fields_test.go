@@ -0,0 +1,73 @@
+package pii
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTransformPaths_EncryptDecrypt(t *testing.T) {
+	c, err := NewCipher(testKey(7))
+	if err != nil {
+		t.Fatalf("Ошибка создания шифра: %v", err)
+	}
+
+	doc := []byte(`{"lastName":"Иванов","passportNumber":"123456","children":[{"name":"Петр","age":5}],"spouse":null}`)
+	paths := []string{"passportNumber", "children[].name", "spouse.spouseName", "missing"}
+
+	encrypted, err := TransformPaths(doc, paths, c.EncryptValue)
+	if err != nil {
+		t.Fatalf("Ошибка шифрования: %v", err)
+	}
+	if strings.Contains(string(encrypted), "123456") || strings.Contains(string(encrypted), "Петр") {
+		t.Errorf("Данные не зашифрованы: %s", encrypted)
+	}
+	if !strings.Contains(string(encrypted), "Иванов") {
+		t.Errorf("Поле вне списка не должно шифроваться: %s", encrypted)
+	}
+
+	decrypted, err := TransformPaths(encrypted, paths, c.DecryptValue)
+	if err != nil {
+		t.Fatalf("Ошибка расшифровки: %v", err)
+	}
+
+	var original, restored map[string]interface{}
+	json.Unmarshal(doc, &original)
+	json.Unmarshal(decrypted, &restored)
+	if restored["passportNumber"] != "123456" {
+		t.Errorf("Ожидался номер паспорта 123456, получено %v", restored["passportNumber"])
+	}
+	children := restored["children"].([]interface{})
+	if children[0].(map[string]interface{})["name"] != "Петр" {
+		t.Errorf("Имя ребенка не восстановлено: %v", children)
+	}
+
+	// Значение нельзя перенести в другое поле
+	var swapped map[string]interface{}
+	json.Unmarshal(encrypted, &swapped)
+	if _, err := c.DecryptValue("birthDate", swapped["passportNumber"]); err == nil {
+		t.Error("Значение, зашифрованное для другого поля, не должно расшифровываться")
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{"4510", "****"},
+		{"123456", "****56"},
+		{"1990-05-12", "****-**-12"},
+		{"+7 (999) 123-45-67", "+* (***) ***-**-67"},
+		{true, true},
+		{json.Number("42"), "***"},
+		{nil, nil},
+		{"pii:v1:abc", "***"},
+	}
+
+	for _, tt := range tests {
+		if got := Mask(tt.value); got != tt.expected {
+			t.Errorf("Mask(%v) = %v, ожидалось %v", tt.value, got, tt.expected)
+		}
+	}
+}
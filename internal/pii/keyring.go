@@ -0,0 +1,181 @@
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// dataKeySize размер ключа данных записи (AES-256)
+const dataKeySize = 32
+
+// ErrUnknownKey возвращается, если запись зашифрована ключом, которого нет в наборе
+var ErrUnknownKey = errors.New("неизвестный ключ шифрования")
+
+// Keyring набор мастер-ключей. Данные каждой записи шифруются собственным
+// ключом данных, который хранится в записи зашифрованным активным
+// мастер-ключом (envelope encryption). При ротации заново шифруются только
+// ключи данных, сами данные не меняются.
+type Keyring struct {
+	keys   map[string][]byte
+	active string
+}
+
+// NewKeyring создает набор мастер-ключей. Все ключи должны быть длиной
+// 32 байта, активный ключ должен присутствовать в наборе.
+func NewKeyring(keys map[string][]byte, active string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("не задано ни одного ключа шифрования")
+	}
+	for id, key := range keys {
+		if id == "" || strings.ContainsAny(id, ":,") {
+			return nil, fmt.Errorf("некорректный идентификатор ключа '%s'", id)
+		}
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("ключ '%s' должен быть длиной %d байт", id, dataKeySize)
+		}
+	}
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("активный ключ '%s' отсутствует в наборе", active)
+	}
+	return &Keyring{keys: keys, active: active}, nil
+}
+
+// KeyringFromEnv загружает мастер-ключи из PII_KEYS (список id:base64 через
+// запятую) и активный ключ из PII_ACTIVE_KEY (по умолчанию последний
+// в списке). Если PII_KEYS не задана, возвращает nil без ошибки.
+func KeyringFromEnv() (*Keyring, error) {
+	value := strings.TrimSpace(os.Getenv("PII_KEYS"))
+	if value == "" {
+		return nil, nil
+	}
+
+	keys := make(map[string][]byte)
+	var last string
+	for _, entry := range strings.Split(value, ",") {
+		id, encoded, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if !found {
+			return nil, fmt.Errorf("некорректная запись PII_KEYS '%s', ожидается id:base64", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("ключ '%s' не в формате base64: %w", id, err)
+		}
+		keys[id] = key
+		last = id
+	}
+
+	active := strings.TrimSpace(os.Getenv("PII_ACTIVE_KEY"))
+	if active == "" {
+		active = last
+	}
+	return NewKeyring(keys, active)
+}
+
+// ActiveKeyID возвращает идентификатор активного мастер-ключа
+func (k *Keyring) ActiveKeyID() string {
+	return k.active
+}
+
+// KeyIDs возвращает идентификаторы всех мастер-ключей
+func (k *Keyring) KeyIDs() []string {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// NewDataKey генерирует ключ данных для новой записи и возвращает его вместе
+// с зашифрованной активным мастер-ключом копией для хранения
+func (k *Keyring) NewDataKey() (dataKey []byte, wrapped, keyID string, err error) {
+	dataKey = make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", "", fmt.Errorf("ошибка генерации ключа данных: %w", err)
+	}
+
+	wrapped, err = k.wrap(k.active, dataKey)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return dataKey, wrapped, k.active, nil
+}
+
+// UnwrapDataKey расшифровывает ключ данных записи
+func (k *Keyring) UnwrapDataKey(keyID, wrapped string) ([]byte, error) {
+	master, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := open(aead, wrapped, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка расшифровки ключа данных: %w", err)
+	}
+	return dataKey, nil
+}
+
+// Rewrap перешифровывает ключ данных активным мастер-ключом
+func (k *Keyring) Rewrap(keyID, wrapped string) (string, string, error) {
+	dataKey, err := k.UnwrapDataKey(keyID, wrapped)
+	if err != nil {
+		return "", "", err
+	}
+	rewrapped, err := k.wrap(k.active, dataKey)
+	if err != nil {
+		return "", "", err
+	}
+	return rewrapped, k.active, nil
+}
+
+// wrap шифрует ключ данных мастер-ключом; идентификатор мастер-ключа
+// используется как дополнительные данные AEAD
+func (k *Keyring) wrap(keyID string, dataKey []byte) (string, error) {
+	aead, err := newAEAD(k.keys[keyID])
+	if err != nil {
+		return "", err
+	}
+	return seal(aead, dataKey, []byte(keyID))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка инициализации шифра: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal шифрует данные и возвращает base64(nonce || ciphertext)
+func seal(aead cipher.AEAD, plaintext, additional []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("ошибка генерации nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, additional)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open расшифровывает результат seal
+func open(aead cipher.AEAD, encoded string, additional []byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("слишком короткий шифртекст")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
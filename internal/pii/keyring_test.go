@@ -0,0 +1,82 @@
+package pii
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	old, err := NewKeyring(map[string][]byte{"k1": testKey(1)}, "k1")
+	if err != nil {
+		t.Fatalf("Ошибка создания набора ключей: %v", err)
+	}
+
+	dataKey, wrapped, keyID, err := old.NewDataKey()
+	if err != nil {
+		t.Fatalf("Ошибка генерации ключа данных: %v", err)
+	}
+	if keyID != "k1" {
+		t.Errorf("Ожидался ключ k1, получен %s", keyID)
+	}
+
+	// Новый активный ключ, старый остается для расшифровки
+	rotated, err := NewKeyring(map[string][]byte{"k1": testKey(1), "k2": testKey(2)}, "k2")
+	if err != nil {
+		t.Fatalf("Ошибка создания набора ключей: %v", err)
+	}
+
+	rewrapped, newKeyID, err := rotated.Rewrap(keyID, wrapped)
+	if err != nil {
+		t.Fatalf("Ошибка перешифровки: %v", err)
+	}
+	if newKeyID != "k2" {
+		t.Errorf("Ожидался ключ k2, получен %s", newKeyID)
+	}
+
+	unwrapped, err := rotated.UnwrapDataKey(newKeyID, rewrapped)
+	if err != nil {
+		t.Fatalf("Ошибка расшифровки ключа данных: %v", err)
+	}
+	if !bytes.Equal(unwrapped, dataKey) {
+		t.Error("Ключ данных изменился при ротации")
+	}
+
+	// Ключ, зашифрованный k2, нельзя выдать за зашифрованный k1
+	if _, err := rotated.UnwrapDataKey("k1", rewrapped); err == nil {
+		t.Error("Ключ данных не должен расшифровываться чужим мастер-ключом")
+	}
+
+	if _, err := old.UnwrapDataKey("k2", rewrapped); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Ожидалась ошибка неизвестного ключа, получено %v", err)
+	}
+}
+
+func TestKeyringFromEnv(t *testing.T) {
+	t.Setenv("PII_KEYS", "")
+	keyring, err := KeyringFromEnv()
+	if err != nil || keyring != nil {
+		t.Fatalf("Без PII_KEYS ожидался пустой набор, получено %v, %v", keyring, err)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(testKey(3))
+	t.Setenv("PII_KEYS", "2024:"+encoded+", 2025:"+encoded)
+	t.Setenv("PII_ACTIVE_KEY", "")
+	keyring, err = KeyringFromEnv()
+	if err != nil {
+		t.Fatalf("Ошибка загрузки ключей: %v", err)
+	}
+	if keyring.ActiveKeyID() != "2025" {
+		t.Errorf("Активным должен быть последний ключ, получен %s", keyring.ActiveKeyID())
+	}
+
+	t.Setenv("PII_KEYS", "short:"+base64.StdEncoding.EncodeToString([]byte("short")))
+	if _, err := KeyringFromEnv(); err == nil {
+		t.Error("Короткий ключ должен отклоняться")
+	}
+}
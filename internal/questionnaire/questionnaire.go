@@ -0,0 +1,163 @@
+package questionnaire
+
+import (
+	_ "embed"
+	"encoding/json"
+	"sort"
+	"strings"
+	"tenderhelp/internal/jsonschema"
+)
+
+// schemaJSON схемы шагов анкеты (поля описаны в docs/field-mapping.md).
+// Этот же файл отдается фронтенду через GET /api/applications/schema.
+//
+//go:embed schema.json
+var schemaJSON []byte
+
+// Step шаг анкеты. Field - имя раздела в запросе на создание заявки.
+type Step struct {
+	Name   string             `json:"step"`
+	Field  string             `json:"field"`
+	Title  string             `json:"title"`
+	Schema *jsonschema.Schema `json:"schema"`
+}
+
+// Definition описание анкеты
+type Definition struct {
+	Version string `json:"version"`
+	Steps   []Step `json:"steps"`
+}
+
+// ValidationError ошибки заполнения анкеты с путями полей
+type ValidationError struct {
+	Errors []jsonschema.FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldError := range e.Errors {
+		messages[i] = fieldError.Path + ": " + fieldError.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// definition разобранная анкета; ошибка в schema.json обнаруживается при запуске
+var definition = mustLoad()
+
+func mustLoad() *Definition {
+	d, err := load(schemaJSON)
+	if err != nil {
+		panic("questionnaire: " + err.Error())
+	}
+	return d
+}
+
+func load(data []byte) (*Definition, error) {
+	var d Definition
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+	for _, step := range d.Steps {
+		if err := step.Schema.Compile(); err != nil {
+			return nil, err
+		}
+	}
+	return &d, nil
+}
+
+// Get возвращает описание анкеты
+func Get() *Definition {
+	return definition
+}
+
+// FindStep ищет шаг анкеты по имени
+func FindStep(name string) (Step, bool) {
+	for _, step := range definition.Steps {
+		if step.Name == name {
+			return step, true
+		}
+	}
+	return Step{}, false
+}
+
+// Field поле анкеты, которое заполняется одним значением (например, столбцом
+// таблицы при импорте). Path начинается с имени раздела.
+type Field struct {
+	Path   string
+	Title  string
+	Schema *jsonschema.Schema
+}
+
+// Fields возвращает поля анкеты со скалярными значениями и списками
+// скаляров. Поля элементов массивов объектов (дети, контакты) не входят.
+func Fields() []Field {
+	var fields []Field
+	for _, step := range definition.Steps {
+		collectFields(step.Field, step.Schema, &fields)
+	}
+	return fields
+}
+
+func collectFields(path string, schema *jsonschema.Schema, fields *[]Field) {
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property := schema.Properties[name]
+		switch {
+		case property.Type == "object":
+			collectFields(path+"."+name, property, fields)
+		case property.Type == "array" && (property.Items == nil || property.Items.Type == "object"):
+			continue
+		default:
+			*fields = append(*fields, Field{Path: path + "." + name, Title: property.Title, Schema: property})
+		}
+	}
+}
+
+// ValidateStep проверяет данные одного шага. Пути ошибок начинаются с имени
+// раздела (например, "personalData.passportNumber").
+func ValidateStep(step Step, data json.RawMessage, mode jsonschema.Mode) error {
+	var errors []jsonschema.FieldError
+	if len(data) == 0 || string(data) == "null" {
+		if mode == jsonschema.Complete {
+			errors = append(errors, jsonschema.FieldError{Path: step.Field, Message: "раздел не заполнен"})
+		}
+	} else {
+		for _, fieldError := range step.Schema.ValidateJSON(data, mode) {
+			fieldError.Path = prefixed(step.Field, fieldError.Path)
+			errors = append(errors, fieldError)
+		}
+	}
+
+	if len(errors) > 0 {
+		return &ValidationError{Errors: errors}
+	}
+	return nil
+}
+
+// Validate проверяет все шаги анкеты. sections - данные разделов по именам
+// шагов; отсутствующий раздел считается незаполненным.
+func Validate(sections map[string]json.RawMessage, mode jsonschema.Mode) error {
+	var errors []jsonschema.FieldError
+	for _, step := range definition.Steps {
+		if err := ValidateStep(step, sections[step.Name], mode); err != nil {
+			errors = append(errors, err.(*ValidationError).Errors...)
+		}
+	}
+
+	if len(errors) > 0 {
+		return &ValidationError{Errors: errors}
+	}
+	return nil
+}
+
+func prefixed(field, path string) string {
+	if path == "" {
+		return field
+	}
+	return field + "." + path
+}
@@ -0,0 +1,120 @@
+package questionnaire
+
+import (
+	"encoding/json"
+	"errors"
+	"tenderhelp/internal/jsonschema"
+	"testing"
+)
+
+// completeSections полностью заполненная анкета
+func completeSections() map[string]json.RawMessage {
+	return map[string]json.RawMessage{
+		"personal": json.RawMessage(`{
+			"lastName": "Иванов", "firstName": "Иван", "middleName": "Иванович",
+			"birthDate": "1985-03-15", "birthPlace": "г. Москва", "gender": "male",
+			"citizenship": "RU", "maritalStatus": "married", "hasChildren": true, "childrenCount": 1,
+			"passportSeries": "4510", "passportNumber": "123456", "passportIssuedBy": "ОВД Тверской",
+			"passportIssueDate": "2005-04-01", "passportDepartmentCode": "770-001"
+		}`),
+		"contact": json.RawMessage(`{
+			"primaryPhone": "+79161234567", "email": "ivan@example.com",
+			"registrationAddress": "Москва, ул. Тверская, 1", "sameAddress": true
+		}`),
+		"professional": json.RawMessage(`{
+			"currentJob": {
+				"companyName": "ООО Тест", "position": "Менеджер", "workPhone": "+74951234567",
+				"workAddress": "Москва", "employmentDate": "2020-01-15", "monthlyIncome": 100000
+			},
+			"education": {"level": "higher", "institution": "МГУ", "graduationYear": 2007, "specialty": "Экономика"}
+		}`),
+		"financial": json.RawMessage(`{
+			"income": {"salary": 100000, "totalMonthlyIncome": 100000},
+			"expenses": {"totalMonthlyExpenses": 40000},
+			"property": {"hasRealEstate": false, "hasVehicle": true, "vehicleValue": 900000},
+			"creditHistory": {"hasActiveLoans": false, "hasOverdue": false}
+		}`),
+		"family": json.RawMessage(`{
+			"spouse": {"hasSpouse": true, "spouseName": "Мария"},
+			"children": [{"name": "Петр", "birthDate": "2015-06-01"}],
+			"emergencyContacts": [{"name": "Мария", "relationship": "жена", "phone": "+79160000000"}]
+		}`),
+		"additional": json.RawMessage(`{
+			"additionalInfo": {
+				"hasCriminalRecord": false, "hasAdministrativeViolations": false,
+				"hasTaxDebts": false, "hasAlimonyObligations": false
+			},
+			"consents": {"dataProcessing": true, "creditHistory": true, "scoring": true}
+		}`),
+	}
+}
+
+func TestValidate_CompleteQuestionnaire(t *testing.T) {
+	if err := Validate(completeSections(), jsonschema.Complete); err != nil {
+		t.Errorf("Заполненная анкета не должна содержать ошибок: %v", err)
+	}
+}
+
+func TestValidate_CrossFieldRules(t *testing.T) {
+	sections := completeSections()
+	sections["contact"] = json.RawMessage(`{"primaryPhone": "+79161234567", "email": "ivan@example.com", "registrationAddress": "Москва", "sameAddress": false}`)
+	sections["additional"] = json.RawMessage(`{"additionalInfo": {"hasCriminalRecord": false, "hasAdministrativeViolations": false, "hasTaxDebts": false, "hasAlimonyObligations": false}, "consents": {"dataProcessing": true, "creditHistory": true, "scoring": false}}`)
+	delete(sections, "family")
+
+	err := Validate(sections, jsonschema.Complete)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Ожидалась ошибка валидации, получено %v", err)
+	}
+
+	paths := make(map[string]bool)
+	for _, fieldError := range validationErr.Errors {
+		paths[fieldError.Path] = true
+	}
+	for _, path := range []string{"contactData.actualAddress", "additionalData.consents.scoring", "familyData"} {
+		if !paths[path] {
+			t.Errorf("Ожидалась ошибка поля %s, получено %v", path, validationErr.Errors)
+		}
+	}
+	if len(validationErr.Errors) != 3 {
+		t.Errorf("Ожидалось 3 ошибки, получено %v", validationErr.Errors)
+	}
+}
+
+func TestFields(t *testing.T) {
+	fields := make(map[string]Field)
+	for _, field := range Fields() {
+		fields[field.Path] = field
+	}
+
+	if field, ok := fields["personalData.lastName"]; !ok || field.Title != "Фамилия" {
+		t.Errorf("Ожидалось поле personalData.lastName, получено %+v", field)
+	}
+	if field, ok := fields["professionalData.currentJob.companyName"]; !ok || field.Schema.Type != "string" {
+		t.Errorf("Ожидалось вложенное поле currentJob.companyName, получено %+v", field)
+	}
+	if _, ok := fields["contactData.additionalPhones"]; !ok {
+		t.Error("Список телефонов заполняется одним значением")
+	}
+	if _, ok := fields["familyData.emergencyContacts"]; ok {
+		t.Error("Массив объектов не заполняется одним значением")
+	}
+}
+
+func TestValidateStep_Draft(t *testing.T) {
+	step, ok := FindStep("personal")
+	if !ok {
+		t.Fatal("Шаг personal не найден")
+	}
+
+	// Черновик может быть заполнен частично, но не с ошибками формата
+	if err := ValidateStep(step, json.RawMessage(`{"lastName": "Иванов"}`), jsonschema.Partial); err != nil {
+		t.Errorf("Частично заполненный шаг допустим в черновике: %v", err)
+	}
+
+	err := ValidateStep(step, json.RawMessage(`{"passportNumber": "12 34 56"}`), jsonschema.Partial)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Errors[0].Path != "personalData.passportNumber" {
+		t.Errorf("Ожидалась ошибка personalData.passportNumber, получено %v", err)
+	}
+}
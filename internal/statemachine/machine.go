@@ -0,0 +1,155 @@
+package statemachine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// State статус объекта
+type State string
+
+// Event событие, переводящее объект из одного статуса в другой
+type Event string
+
+// Transition допустимый переход. Guard проверяет предусловия перехода до
+// сохранения нового статуса, Effect выполняется после успешного сохранения.
+type Transition[T any] struct {
+	Event  Event
+	From   []State
+	To     State
+	Guard  func(subject T) error
+	Effect func(subject T)
+}
+
+// TransitionError событие недопустимо в текущем статусе
+type TransitionError struct {
+	From    State
+	Event   Event
+	Allowed []Event
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("переход '%s' недопустим из статуса '%s'", e.Event, e.From)
+}
+
+// GuardError предусловие перехода не выполнено
+type GuardError struct {
+	Event Event
+	Err   error
+}
+
+func (e *GuardError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *GuardError) Unwrap() error {
+	return e.Err
+}
+
+// Machine набор статусов и переходов между ними
+type Machine[T any] struct {
+	states      map[State]bool
+	transitions map[Event][]Transition[T]
+}
+
+// New создает машину состояний. Все статусы, упомянутые в переходах, должны
+// быть объявлены, а одно событие не может вести из одного статуса в разные.
+func New[T any](states []State, transitions ...Transition[T]) (*Machine[T], error) {
+	m := &Machine[T]{
+		states:      make(map[State]bool, len(states)),
+		transitions: make(map[Event][]Transition[T]),
+	}
+	for _, state := range states {
+		m.states[state] = true
+	}
+
+	for _, t := range transitions {
+		if t.Event == "" || len(t.From) == 0 {
+			return nil, errors.New("у перехода должны быть событие и исходные статусы")
+		}
+		if !m.states[t.To] {
+			return nil, fmt.Errorf("переход '%s' ведет в необъявленный статус '%s'", t.Event, t.To)
+		}
+		for _, from := range t.From {
+			if !m.states[from] {
+				return nil, fmt.Errorf("переход '%s' ведет из необъявленного статуса '%s'", t.Event, from)
+			}
+			if _, ok := m.find(from, t.Event); ok {
+				return nil, fmt.Errorf("переход '%s' из статуса '%s' объявлен дважды", t.Event, from)
+			}
+		}
+		m.transitions[t.Event] = append(m.transitions[t.Event], t)
+	}
+	return m, nil
+}
+
+// MustNew как New, но паникует при ошибке в описании машины
+func MustNew[T any](states []State, transitions ...Transition[T]) *Machine[T] {
+	m, err := New(states, transitions...)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// find ищет переход по событию из статуса
+func (m *Machine[T]) find(from State, event Event) (Transition[T], bool) {
+	for _, t := range m.transitions[event] {
+		for _, state := range t.From {
+			if state == from {
+				return t, true
+			}
+		}
+	}
+	return Transition[T]{}, false
+}
+
+// HasState проверяет, что статус объявлен в машине
+func (m *Machine[T]) HasState(state State) bool {
+	return m.states[state]
+}
+
+// Allowed возвращает события, допустимые в статусе
+func (m *Machine[T]) Allowed(from State) []Event {
+	events := []Event{}
+	for event := range m.transitions {
+		if _, ok := m.find(from, event); ok {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i] < events[j] })
+	return events
+}
+
+// Check проверяет, что событие допустимо в статусе, не вызывая Guard
+func (m *Machine[T]) Check(from State, event Event) error {
+	if _, ok := m.find(from, event); !ok {
+		return &TransitionError{From: from, Event: event, Allowed: m.Allowed(from)}
+	}
+	return nil
+}
+
+// Fire выполняет переход: проверяет его допустимость и Guard, сохраняет новый
+// статус через commit и запускает Effect. Ошибка commit возвращается как есть.
+func (m *Machine[T]) Fire(subject T, from State, event Event, commit func(to State) error) (State, error) {
+	t, ok := m.find(from, event)
+	if !ok {
+		return from, &TransitionError{From: from, Event: event, Allowed: m.Allowed(from)}
+	}
+
+	if t.Guard != nil {
+		if err := t.Guard(subject); err != nil {
+			return from, &GuardError{Event: event, Err: err}
+		}
+	}
+
+	if err := commit(t.To); err != nil {
+		return from, err
+	}
+
+	if t.Effect != nil {
+		t.Effect(subject)
+	}
+	return t.To, nil
+}
@@ -0,0 +1,113 @@
+package statemachine
+
+import (
+	"errors"
+	"testing"
+)
+
+type order struct {
+	paid    bool
+	shipped int
+}
+
+func testMachine() *Machine[*order] {
+	return MustNew([]State{"new", "paid", "shipped", "cancelled"},
+		Transition[*order]{Event: "pay", From: []State{"new"}, To: "paid"},
+		Transition[*order]{
+			Event: "ship",
+			From:  []State{"paid"},
+			To:    "shipped",
+			Guard: func(o *order) error {
+				if !o.paid {
+					return errors.New("заказ не оплачен")
+				}
+				return nil
+			},
+			Effect: func(o *order) { o.shipped++ },
+		},
+		Transition[*order]{Event: "cancel", From: []State{"new", "paid"}, To: "cancelled"},
+	)
+}
+
+func TestMachine_Fire(t *testing.T) {
+	m := testMachine()
+	o := &order{paid: true}
+
+	committed := State("")
+	to, err := m.Fire(o, "paid", "ship", func(to State) error {
+		committed = to
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Ошибка перехода: %v", err)
+	}
+	if to != "shipped" || committed != "shipped" {
+		t.Errorf("Ожидался статус shipped, получено %s (сохранено %s)", to, committed)
+	}
+	if o.shipped != 1 {
+		t.Errorf("Effect должен выполниться один раз, выполнен %d", o.shipped)
+	}
+}
+
+func TestMachine_IllegalTransition(t *testing.T) {
+	m := testMachine()
+
+	_, err := m.Fire(&order{}, "cancelled", "ship", func(State) error {
+		t.Fatal("commit не должен вызываться для недопустимого перехода")
+		return nil
+	})
+
+	var transitionErr *TransitionError
+	if !errors.As(err, &transitionErr) {
+		t.Fatalf("Ожидалась ошибка перехода, получено %v", err)
+	}
+	if len(transitionErr.Allowed) != 0 {
+		t.Errorf("Из отмененного заказа переходов нет, получено %v", transitionErr.Allowed)
+	}
+
+	if allowed := m.Allowed("new"); len(allowed) != 2 || allowed[0] != "cancel" || allowed[1] != "pay" {
+		t.Errorf("Ожидались события [cancel pay], получено %v", allowed)
+	}
+}
+
+func TestMachine_GuardAndCommitErrors(t *testing.T) {
+	m := testMachine()
+	o := &order{}
+
+	_, err := m.Fire(o, "paid", "ship", func(State) error { return nil })
+	var guardErr *GuardError
+	if !errors.As(err, &guardErr) {
+		t.Fatalf("Ожидалась ошибка Guard, получено %v", err)
+	}
+
+	commitErr := errors.New("статус изменен другим запросом")
+	o.paid = true
+	from, err := m.Fire(o, "paid", "ship", func(State) error { return commitErr })
+	if !errors.Is(err, commitErr) || from != "paid" {
+		t.Errorf("Ожидалась ошибка сохранения и прежний статус, получено %v, %s", err, from)
+	}
+	if o.shipped != 0 {
+		t.Error("Effect не должен выполняться при ошибке сохранения")
+	}
+}
+
+func TestMachine_HasState(t *testing.T) {
+	m := testMachine()
+	if !m.HasState("shipped") || m.HasState("lost") {
+		t.Error("HasState должен учитывать только объявленные статусы")
+	}
+}
+
+func TestNew_InvalidDefinition(t *testing.T) {
+	if _, err := New([]State{"a"}, Transition[int]{Event: "go", From: []State{"a"}, To: "b"}); err == nil {
+		t.Error("Переход в необъявленный статус должен отклоняться")
+	}
+
+	_, err := New([]State{"a", "b", "c"},
+		Transition[int]{Event: "go", From: []State{"a"}, To: "b"},
+		Transition[int]{Event: "go", From: []State{"a"}, To: "c"},
+	)
+	if err == nil {
+		t.Error("Неоднозначный переход должен отклоняться")
+	}
+}
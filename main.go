@@ -3,9 +3,12 @@ package main
 import (
 	"log"
 	"net/http"
+	"tenderhelp/internal/auth"
 	"tenderhelp/internal/database"
 	"tenderhelp/internal/handlers"
+	"tenderhelp/internal/mailer"
 	"tenderhelp/internal/models"
+	"tenderhelp/internal/pii"
 	"tenderhelp/internal/scoring"
 
 	"github.com/gin-contrib/cors"
@@ -33,8 +36,75 @@ func main() {
 		&handlers.File{},
 		&handlers.POSApplication{},
 		&handlers.GuaranteeApplication{},
+		&handlers.Session{},
+		&handlers.Role{},
+		&handlers.Permission{},
+		&handlers.ClientAccess{},
+		&handlers.BankMembership{},
+		&handlers.ApplicationRoute{},
+		&handlers.UserSecurity{},
+		&handlers.Invitation{},
+		&handlers.RecoveryCode{},
+		&handlers.LoginThrottle{},
+		&handlers.SecurityEvent{},
+		&handlers.APIKey{},
+		&handlers.AuditEntry{},
+		&handlers.PipelineRun{},
+		&handlers.PipelineTask{},
+		&handlers.ApplicationRevision{},
+		&handlers.FilterPreset{},
+		&handlers.ApplicationComment{},
+		&handlers.CommentMention{},
+		&handlers.CommentRevision{},
+		&handlers.Notification{},
+		&handlers.ManagerProfile{},
+		&handlers.ApplicationAssignment{},
+		&handlers.SLATarget{},
+		&handlers.SLABreach{},
+		&handlers.DuplicateOverride{},
+		&handlers.IdempotencyKey{},
+		&handlers.ImportJob{},
+		&handlers.ImportError{},
 	)
 
+	// Системные роли и права
+	if err := handlers.EnsureDefaultRoles(); err != nil {
+		log.Fatalf("Ошибка инициализации ролей: %v", err)
+	}
+
+	// Инициализация подписи токенов доступа
+	tokenManager := auth.NewTokenManager(auth.LoadSecret(), auth.DefaultAccessTokenTTL)
+	handlers.SetTokenManager(tokenManager)
+
+	// Шифрование персональных данных заявок
+	piiKeyring, err := pii.KeyringFromEnv()
+	if err != nil {
+		log.Fatalf("Ошибка загрузки ключей шифрования PII: %v", err)
+	}
+	handlers.SetPIIKeyring(piiKeyring)
+	if encrypted, err := handlers.EncryptLegacyPII(); err != nil {
+		log.Fatalf("Ошибка шифрования персональных данных: %v", err)
+	} else if encrypted > 0 {
+		log.Printf("Зашифровано активным ключом заявок: %d", encrypted)
+	}
+
+	// Отправка писем (приглашения)
+	handlers.SetMailer(mailer.FromEnv())
+
+	// Пароли, сохраненные в открытом виде старой регистрацией агентов
+	if secured, err := handlers.SecureLegacyPasswords(); err != nil {
+		log.Fatalf("Ошибка проверки паролей: %v", err)
+	} else if secured > 0 {
+		log.Printf("Сброшено паролей в открытом виде: %d, пользователям отправлены приглашения", secured)
+	}
+
+	// Перенос заявок старого образца (/api/requests) в заявки
+	if migrated, err := handlers.MigrateLegacyRequests(); err != nil {
+		log.Printf("Ошибка переноса заявок старого образца: %v", err)
+	} else if migrated > 0 {
+		log.Printf("Перенесено заявок старого образца: %d", migrated)
+	}
+
 	// Инициализация системы скоринга
 	scoringEngine := scoring.NewScoringEngine()
 	handlers.SetScoringEngine(scoringEngine)
@@ -42,14 +112,26 @@ func main() {
 	// Инициализация системы интеграций
 	handlers.InitIntegrations()
 
+	// Автоматическая обработка отправленных заявок
+	handlers.StartPipeline()
+
+	// Контроль сроков рассмотрения заявок
+	handlers.StartSLAChecker()
+
 	// Настройка Gin
 	r := gin.Default()
+	// Адрес клиента из X-Forwarded-For принимается только от доверенных прокси
+	if err := r.SetTrustedProxies(handlers.TrustedProxies()); err != nil {
+		log.Fatalf("Некорректный TRUSTED_PROXIES: %v", err)
+	}
+	r.Use(handlers.RequestID())
 
 	// CORS настройки
 	config := cors.DefaultConfig()
 	config.AllowOrigins = []string{"http://localhost:3000"}
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Request-ID", "Idempotency-Key", "If-Match"}
+	config.ExposeHeaders = []string{"X-Request-ID", "ETag", "Idempotent-Replayed"}
 	r.Use(cors.New(config))
 
 	// Статические файлы
@@ -59,37 +141,64 @@ func main() {
 	// API маршруты
 	api := r.Group("/api")
 	{
-		// Заявки (старая система) - только для пользователей и выше
+		// Заявки в формате старой системы (представление заявок)
 		api.GET("/requests", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetRequests)
-		api.POST("/requests", handlers.RequireAuth(), handlers.RequirePermission("create_applications"), handlers.CreateRequest)
+		api.POST("/requests", handlers.RequireAuth(), handlers.RequirePermission("create_applications"), handlers.Idempotent(), handlers.CreateRequest)
 		api.PUT("/requests/:id", handlers.RequireAuth(), handlers.RequirePermission("manage_applications"), handlers.UpdateRequest)
 		api.DELETE("/requests/:id", handlers.RequireAuth(), handlers.RequirePermission("manage_applications"), handlers.DeleteRequest)
 
 		// Новые заявки (система брокериджа)
 		api.GET("/applications", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetApplications)
-		api.POST("/applications", handlers.RequireAuth(), handlers.RequirePermission("create_applications"), handlers.CreateApplication)
+		api.POST("/applications", handlers.RequireAuth(), handlers.RequirePermission("create_applications"), handlers.Idempotent(), handlers.CreateApplication)
+		api.GET("/applications/schema", handlers.RequireAuth(), handlers.GetApplicationSchema)
+		api.GET("/applications/export", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.ExportApplications)
 		api.GET("/applications/:id", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetApplication)
 		api.PUT("/applications/:id", handlers.RequireAuth(), handlers.RequirePermission("manage_applications"), handlers.UpdateApplication)
+		api.POST("/applications/import", handlers.RequireAuth(), handlers.RequirePermission("create_applications"), handlers.Idempotent(), handlers.ImportApplications)
+		api.GET("/applications/imports/:importId", handlers.RequireAuth(), handlers.RequirePermission("create_applications"), handlers.GetImportJob)
+		api.GET("/applications/imports/:importId/report", handlers.RequireAuth(), handlers.RequirePermission("create_applications"), handlers.DownloadImportReport)
+		api.GET("/applications/filters", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetFilterPresets)
+		api.POST("/applications/filters", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.CreateFilterPreset)
+		api.DELETE("/applications/filters/:presetId", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.DeleteFilterPreset)
+		api.GET("/applications/:id/revisions", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetApplicationRevisions)
+		api.GET("/applications/:id/revisions/diff", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.DiffApplicationRevisions)
+		api.GET("/applications/:id/revisions/:revisionId", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetApplicationRevision)
+		api.POST("/applications/:id/revisions/:revisionId/restore", handlers.RequireAuth(), handlers.RequirePermission("manage_applications"), handlers.RestoreApplicationRevision)
+		api.POST("/applications/:id/clone", handlers.RequireAuth(), handlers.RequirePermission("create_applications"), handlers.Idempotent(), handlers.CloneApplication)
+		api.GET("/applications/:id/lineage", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetApplicationLineage)
+		api.GET("/applications/:id/duplicates", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetApplicationDuplicates)
+		api.GET("/applications/:id/comments", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetApplicationComments)
+		api.POST("/applications/:id/comments", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.CreateApplicationComment)
+		api.PUT("/applications/:id/comments/:commentId", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.UpdateApplicationComment)
+		api.GET("/applications/:id/comments/:commentId/history", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetCommentHistory)
+		api.PUT("/applications/:id/manager", handlers.RequireAuth(), handlers.RequirePermission("assign_managers"), handlers.AssignApplicationManager)
+		api.POST("/applications/:id/manager/auto", handlers.RequireAuth(), handlers.RequirePermission("assign_managers"), handlers.AutoAssignApplicationManager)
+		api.GET("/applications/:id/assignments", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetApplicationAssignments)
 		api.POST("/applications/:id/submit", handlers.RequireAuth(), handlers.RequirePermission("manage_applications"), handlers.SubmitApplication)
 
 		// Файлы
-		api.POST("/files/upload", handlers.UploadFile)
-		api.GET("/files/presigned", handlers.GetPresignedUploadURL)
-		api.GET("/applications/:id/files", handlers.GetFiles)
-		api.DELETE("/files/:fileId", handlers.DeleteFile)
-		api.GET("/files/:fileId", handlers.DownloadFile)
+		api.POST("/files/upload", handlers.RequireAuth(), handlers.RequirePermission("manage_applications"), handlers.Idempotent(), handlers.UploadFile)
+		api.GET("/files/presigned", handlers.RequireAuth(), handlers.RequirePermission("manage_applications"), handlers.GetPresignedUploadURL)
+		api.GET("/applications/:id/files", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetFiles)
+		api.DELETE("/files/:fileId", handlers.RequireAuth(), handlers.RequirePermission("manage_applications"), handlers.DeleteFile)
+		api.GET("/files/:fileId", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.DownloadFile)
 
 		// Скоринг
-		api.POST("/scoring/run/:id", handlers.RunScoring)
-		api.GET("/scoring/result/:id", handlers.GetScoringResult)
+		api.POST("/scoring/run/:id", handlers.RequireAuth(), handlers.RequirePermission("manage_applications"), handlers.RunScoring)
+		api.GET("/scoring/result/:id", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetScoringResult)
+
+		// Обработка заявки
+		api.GET("/applications/:id/pipeline", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetApplicationPipeline)
+		api.POST("/applications/:id/pipeline/retry", handlers.RequireAuth(), handlers.RequirePermission("manage_applications"), handlers.RetryApplicationPipeline)
 
 		// Интеграции с банками
-		api.POST("/applications/:id/send", handlers.SendApplicationToBanks)
+		api.POST("/applications/:id/send", handlers.RequireAuth(), handlers.RequirePermission("manage_applications"), handlers.Idempotent(), handlers.SendApplicationToBanks)
 		api.GET("/banks/summary", handlers.GetBankSummary)
 		api.GET("/banks/supported", handlers.GetSupportedBanks)
 		api.GET("/banks/:bankId/availability", handlers.CheckBankAvailability)
-		api.GET("/applications/:id/banks/:bankId/status", handlers.GetApplicationStatusFromBank)
-		api.GET("/applications/:id/responses", handlers.GetBankResponses)
+		api.GET("/applications/:id/banks/:bankId/status", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetApplicationStatusFromBank)
+		api.GET("/applications/:id/responses", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetBankResponses)
+		api.POST("/applications/:id/decision", handlers.RequireAuth(), handlers.RequirePermission("submit_bank_decisions"), handlers.SubmitBankDecision)
 
 		// Очереди и задачи
 		api.GET("/queue/stats", handlers.GetQueueStats)
@@ -97,12 +206,12 @@ func main() {
 		api.POST("/queue/tasks", handlers.CreateTask)
 
 		// ПОС и банковские гарантии
-		api.POST("/applications/:id/pos", handlers.CreatePOSApplication)
-		api.GET("/applications/:id/pos", handlers.GetPOSApplication)
-		api.POST("/applications/:id/guarantee", handlers.CreateGuaranteeApplication)
-		api.GET("/applications/:id/guarantee", handlers.GetGuaranteeApplication)
-		api.POST("/applications/:id/pos/document", handlers.GeneratePOSDocument)
-		api.POST("/applications/:id/guarantee/document", handlers.GenerateGuaranteeDocument)
+		api.POST("/applications/:id/pos", handlers.RequireAuth(), handlers.RequirePermission("manage_applications"), handlers.CreatePOSApplication)
+		api.GET("/applications/:id/pos", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetPOSApplication)
+		api.POST("/applications/:id/guarantee", handlers.RequireAuth(), handlers.RequirePermission("manage_applications"), handlers.CreateGuaranteeApplication)
+		api.GET("/applications/:id/guarantee", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetGuaranteeApplication)
+		api.POST("/applications/:id/pos/document", handlers.RequireAuth(), handlers.RequirePermission("manage_applications"), handlers.GeneratePOSDocument)
+		api.POST("/applications/:id/guarantee/document", handlers.RequireAuth(), handlers.RequirePermission("manage_applications"), handlers.GenerateGuaranteeDocument)
 
 		// Клиенты - только для пользователей и выше
 		api.GET("/clients", handlers.RequireAuth(), handlers.RequirePermission("view_clients"), handlers.GetClients)
@@ -124,12 +233,44 @@ func main() {
 
 		// Аутентификация
 		api.POST("/login", handlers.Login)
-		api.POST("/register", handlers.Register)
-		api.POST("/logout", handlers.Logout)
+		api.POST("/login/2fa", handlers.LoginMFA)
+		api.POST("/register", handlers.OptionalAuth(), handlers.Register)
+		api.POST("/logout", handlers.RequireAuth(), handlers.Logout)
+		api.POST("/token/refresh", handlers.RefreshToken)
+		api.POST("/invitations/accept", handlers.AcceptInvitation)
+		api.POST("/password/change", handlers.RequireAuthFor(auth.PurposePasswordChange), handlers.ChangePassword)
 		api.GET("/profile", handlers.RequireAuth(), handlers.GetProfile)
 
+		// Двухфакторная аутентификация
+		api.GET("/2fa", handlers.RequireAuth(), handlers.GetTOTPStatus)
+		api.POST("/2fa/enroll", handlers.RequireAuthFor(auth.PurposeMFAEnroll), handlers.EnrollTOTP)
+		api.POST("/2fa/activate", handlers.RequireAuthFor(auth.PurposeMFAEnroll), handlers.ActivateTOTP)
+		api.POST("/2fa/recovery-codes", handlers.RequireAuth(), handlers.RegenerateRecoveryCodes)
+		api.POST("/2fa/disable", handlers.RequireAuth(), handlers.DisableTOTP)
+
+		// Менеджеры и распределение заявок
+		api.GET("/managers", handlers.RequireAuth(), handlers.RequirePermission("assign_managers"), handlers.GetManagers)
+		api.PUT("/managers/:userId", handlers.RequireAuth(), handlers.RequirePermission("assign_managers"), handlers.UpdateManagerProfile)
+		api.GET("/managers/:userId/queue", handlers.RequireAuth(), handlers.RequirePermission("view_applications"), handlers.GetManagerQueue)
+
+		// Сроки рассмотрения заявок
+		api.GET("/sla/targets", handlers.RequireAuth(), handlers.RequirePermission("manage_sla"), handlers.GetSLATargets)
+		api.PUT("/sla/targets", handlers.RequireAuth(), handlers.RequirePermission("manage_sla"), handlers.SetSLATarget)
+		api.DELETE("/sla/targets/:targetId", handlers.RequireAuth(), handlers.RequirePermission("manage_sla"), handlers.DeleteSLATarget)
+		api.GET("/sla/breaches", handlers.RequireAuth(), handlers.RequirePermission("manage_sla"), handlers.GetSLABreaches)
+
+		// Уведомления
+		api.GET("/notifications", handlers.RequireAuth(), handlers.GetNotifications)
+		api.POST("/notifications/read", handlers.RequireAuth(), handlers.MarkAllNotificationsRead)
+		api.POST("/notifications/:notificationId/read", handlers.RequireAuth(), handlers.MarkNotificationRead)
+
+		// Сессии
+		api.GET("/sessions", handlers.RequireAuth(), handlers.GetSessions)
+		api.DELETE("/sessions/:id", handlers.RequireAuth(), handlers.RevokeSession)
+		api.POST("/users/:id/sessions/revoke", handlers.RequireAuth(), handlers.RequirePermission("manage_sessions"), handlers.RevokeUserSessions)
+
 		// Пользователи / Регистрация
-		api.POST("/register-agent", handlers.RegisterAgent)
+		api.POST("/register-agent", handlers.RequireAuth(), handlers.RequirePermission("manage_users"), handlers.RegisterAgent)
 		api.POST("/register-client", handlers.RegisterClient)
 	}
 
@@ -148,6 +289,47 @@ func main() {
 		admin.GET("/scoring/rulesets", handlers.GetScoringRuleSets)
 		admin.POST("/scoring/rulesets", handlers.CreateScoringRuleSet)
 		admin.PUT("/scoring/rulesets/:id", handlers.UpdateScoringRuleSet)
+
+		// Роли и права
+		admin.GET("/roles", handlers.GetRoles)
+		admin.POST("/roles", handlers.CreateRole)
+		admin.PUT("/roles/:id", handlers.UpdateRole)
+		admin.DELETE("/roles/:id", handlers.DeleteRole)
+		admin.GET("/permissions", handlers.GetPermissions)
+		admin.POST("/permissions", handlers.CreatePermission)
+
+		// Доступ к клиентам и привязка сотрудников банков
+		admin.POST("/client-access", handlers.GrantClientAccess)
+		admin.DELETE("/client-access/:clientId/:userId", handlers.RevokeClientAccess)
+		admin.POST("/bank-members", handlers.SetBankMembership)
+
+		// Приглашения и пароли пользователей
+		admin.POST("/users/:id/invitation", handlers.ResendInvitation)
+		admin.POST("/users/:id/force-password-change", handlers.ForcePasswordChange)
+		admin.POST("/users/:id/2fa/reset", handlers.ResetUserTOTP)
+
+		// Блокировки входа и события безопасности
+		admin.POST("/users/:id/unlock", handlers.UnlockUser)
+		admin.GET("/login-locks", handlers.GetLoginLocks)
+		admin.DELETE("/login-locks/:id", handlers.DeleteLoginLock)
+		admin.GET("/security-events", handlers.GetSecurityEvents)
+
+		// Ключи API банков-партнеров
+		admin.GET("/api-keys", handlers.GetAPIKeys)
+		admin.POST("/api-keys", handlers.CreateAPIKey)
+		admin.DELETE("/api-keys/:id", handlers.RevokeAPIKey)
+
+		// Журнал аудита
+		admin.GET("/audit", handlers.GetAuditLog)
+		admin.GET("/audit/export", handlers.ExportAuditLog)
+		admin.GET("/audit/verify", handlers.VerifyAuditLog)
+
+		// Ключи шифрования персональных данных
+		admin.GET("/pii/keys", handlers.GetPIIKeyStatus)
+		admin.POST("/pii/rotate", handlers.RotatePIIKeys)
+
+		// Перенос заявок старого образца
+		admin.POST("/requests/migrate", handlers.MigrateRequests)
 	}
 
 	// Главная страница